@@ -0,0 +1,80 @@
+package kayak
+
+// bucket holds the tokens of a client, a token is added every rateT ticks
+// since the time at
+type bucket struct {
+	tokens uint
+	at     KTime
+}
+
+// checkPending reports whether one more job of the client fits in the limits
+// of pending jobs overall and per client, a forwarded job is not charged to
+// the client
+func (k *Kayak) checkPending(t Tracer, from KAddress, forwarded bool) bool {
+	if k.maxJobs > 0 && uint(k.jobs.Len()) >= k.maxJobs {
+		k.traceF(t.Logf("%d jobs pending, limit reached", k.jobs.Len()))
+		return false
+	}
+
+	if k.maxClientJobs == 0 || forwarded {
+		return true
+	}
+
+	pending := uint(k.jobs.clientLen(from))
+	if pending >= k.maxClientJobs {
+		k.traceF(t.Logf("%d jobs of %#v pending, limit reached", pending, from))
+		return false
+	}
+
+	return true
+}
+
+// takeToken consumes a token of the client, the bucket of a new client is
+// full
+func (k *Kayak) takeToken(t Tracer, from KAddress) bool {
+	if k.rateT == 0 {
+		return true
+	}
+
+	b, found := k.buckets[from]
+	if !found {
+		b = &bucket{tokens: k.burst, at: k.time}
+		k.buckets[from] = b
+	}
+	k.refill(b)
+
+	if b.tokens == 0 {
+		k.traceF(t.Logf("no tokens left for %#v until %#v", from, b.at+k.rateT))
+		return false
+	}
+
+	b.tokens--
+	return true
+}
+
+func (k *Kayak) refill(b *bucket) {
+	added := uint((k.time - b.at) / k.rateT)
+	if b.tokens+added >= k.burst {
+		b.tokens = k.burst
+		b.at = k.time
+		return
+	}
+	b.tokens += added
+	b.at += KTime(added) * k.rateT
+}
+
+// pruneBuckets forgets the clients whose buckets are full again, they are
+// the same as the buckets of new clients
+func (k *Kayak) pruneBuckets(t Tracer) {
+	var pruned int
+	for from, b := range k.buckets {
+		k.refill(b)
+		if b.tokens == k.burst {
+			delete(k.buckets, from)
+			pruned++
+		}
+	}
+	if pruned > 0 {
+		k.traceF(t.Logf("pruned %d full buckets, %d left", pruned, len(k.buckets)))
+	}
+}
@@ -0,0 +1,99 @@
+package kayak
+
+// pickBatch selects the oldest jobs to be proposed in the next round,
+// skipping the jobs already in flight. The batch is limited by the maximum
+// number of jobs and the maximum total payload size, and is full when either
+// limit is reached. The first job is always picked, even if its payload
+// alone exceeds the size limit.
+func (k *Kayak) pickBatch(t Tracer) ([]KJob, bool) {
+	var batch []KJob
+	var size uint
+
+	inflight := k.inflightBuzz(k.nextRound())
+
+	var full bool
+
+	k.jobs.ascend(func(buzz KHash, job *KJob) bool {
+		if _, found := inflight[buzz]; found {
+			return true
+		}
+		if uint(len(batch)) == k.batchSize {
+			full = true
+			return false
+		}
+		if len(batch) > 0 && size+uint(len(job.Request.Payload)) > k.batchBytes {
+			k.traceF(t.Logf("batch size limit reached at %d bytes", size))
+			full = true
+			return false
+		}
+		batch = append(batch, *job)
+		size += uint(len(job.Request.Payload))
+		return true
+	})
+
+	full = full || uint(len(batch)) == k.batchSize || size >= k.batchBytes
+
+	return batch, full
+}
+
+// checkBatch verifies that the batch can be decided at the round. The batch
+// limits are the leader's, only the hard caps are checked here.
+func (k *Kayak) checkBatch(t Tracer, batch []KJob, round KRound) bool {
+	if len(batch) == 0 {
+		k.traceF(t.Logf("empty batch"))
+		return false
+	}
+
+	if len(batch) > MaxBatchSize {
+		k.traceF(t.Logf("batch of %d jobs exceeds the cap %d", len(batch), MaxBatchSize))
+		return false
+	}
+
+	var size uint
+	seen := k.inflightBuzz(round)
+	seenSequences := k.inflightSequences(round)
+	for i := range batch {
+		request := batch[i].Request
+
+		if request.Index > round {
+			k.traceF(t.Logf("request index is ahead in %#v", batch[i]))
+			return false
+		}
+
+		buzz := hash(request)
+
+		if _, duplicate := seen[buzz]; duplicate {
+			k.traceF(t.Logf("duplicate or in flight job %#v", batch[i]))
+			return false
+		}
+		seen[buzz] = struct{}{}
+
+		if k.isProcessed(request, buzz, round) {
+			k.traceF(t.Logf("already processed %#v", batch[i]))
+			return false
+		}
+
+		if k.isSequenceApplied(request) {
+			k.traceF(t.Logf("sequence already decided in the session %#v", batch[i]))
+			return false
+		}
+
+		if request.Sequence != 0 {
+			sequence := sessionSequence{key: sessionKeyOf(request), sequence: request.Sequence}
+			if _, duplicate := seenSequences[sequence]; duplicate {
+				k.traceF(t.Logf("duplicate or in flight sequence %#v", batch[i]))
+				return false
+			}
+			seenSequences[sequence] = struct{}{}
+		}
+
+		size += uint(len(request.Payload))
+	}
+
+	if len(batch) > 1 && size > MaxBatchBytes {
+		k.traceF(t.Logf("batch of %d bytes exceeds the cap %d", size, MaxBatchBytes))
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,176 @@
+package kayak
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// makeCertificate collects the signed accepts of the slot. It returns nil
+// unless the messages are signed.
+func (k *Kayak) makeCertificate(t Tracer, s *slot) *KCertificate {
+	if k.privateKey == nil {
+		return nil
+	}
+
+	certificate := KCertificate{
+		Round: s.round,
+		Epoch: k.epoch,
+		Buzz:  s.buzz,
+	}
+	certificate.Signers, certificate.Signatures = collectSignatures(k.keys, k.accepts[s.round][k.epoch][s.hash])
+
+	if signed := k.signedVotes(k.accepts[s.round][k.epoch][s.hash]); signed < k.q {
+		k.errorF(t.Errorf("only %d signed accepts out of %d at %#v", signed, k.q, s.round))
+		return nil
+	}
+
+	k.traceF(t.Logf("made %#v", certificate))
+
+	return &certificate
+}
+
+// VerifyCertificate checks that the data with its origin has been decided
+// at the index. The keys are the membership at the round of the
+// certificate, which is the first index of the batch the entry has been
+// decided in. Each key weighs 1.
+func VerifyCertificate(certificate KCertificate, index KIndex, data []byte, origin KOrigin, keys []KAddress) error {
+	weights, _ := makeWeights(keys, nil)
+	return VerifyWeightedCertificate(certificate, index, data, origin, keys, weights, FaultModelByzantine)
+}
+
+// VerifyWeightedCertificate is VerifyCertificate with the voting weights of
+// the keys and the fault model of the servers
+func VerifyWeightedCertificate(certificate KCertificate, index KIndex, data []byte, origin KOrigin, keys []KAddress, weights []uint, model KFaultModel) error {
+	if err := checkCertifiedEntry(certificate, index, hash(requestOf(data, origin))); err != nil {
+		return err
+	}
+	return checkCertificateSignatures(certificate, keys, weights, model)
+}
+
+// checkCertifiedEntry checks that the certificate covers the buzz at the
+// index
+func checkCertifiedEntry(certificate KCertificate, index KIndex, buzz KHash) error {
+	if !certificate.covers(index) {
+		return fmt.Errorf("index %d is out of the certificate range", index)
+	}
+	if buzz != certificate.Buzz[index-certificate.Round] {
+		return errors.New("data does not match the certificate")
+	}
+	return nil
+}
+
+// checkCertificateSignatures checks the signed accepts of the batch
+func checkCertificateSignatures(certificate KCertificate, keys []KAddress, weights []uint, model KFaultModel) error {
+	accept := KAccept{
+		Round: certificate.Round,
+		Epoch: certificate.Epoch,
+		Hash:  cumBuzzHash(KHash{}, certificate.Buzz...),
+	}
+
+	return verifySignatures(signatureDigest(accept), certificate.Signers, certificate.Signatures, keys, weights, model)
+}
+
+func (c KCertificate) covers(index KIndex) bool {
+	return index >= c.Round && index < c.Round+KIndex(len(c.Buzz))
+}
+
+// isNewCertificate tells whether the certificate is not the one of the last
+// batch kept
+func (k *Kayak) isNewCertificate(certificate *KCertificate) bool {
+	if certificate == nil {
+		return false
+	}
+	return len(k.logCerts) == 0 || k.logCerts[len(k.logCerts)-1].Round != certificate.Round
+}
+
+// certificateAt returns the certificate of the batch holding the entry at
+// the index, if known
+func (k *Kayak) certificateAt(index KIndex) (KCertificate, bool) {
+	i := sort.Search(len(k.logCerts), func(i int) bool {
+		return k.logCerts[i].Round+KIndex(len(k.logCerts[i].Buzz)) > index
+	})
+	if i < len(k.logCerts) && k.logCerts[i].covers(index) {
+		return k.logCerts[i], true
+	}
+	return KCertificate{}, false
+}
+
+// certificatesBetween returns the known certificates of the batches holding
+// the entries first, ..., last-1
+func (k *Kayak) certificatesBetween(first, last KIndex) []KCertificate {
+	i := sort.Search(len(k.logCerts), func(i int) bool {
+		return k.logCerts[i].Round+KIndex(len(k.logCerts[i].Buzz)) > first
+	})
+	var certificates []KCertificate
+	for ; i < len(k.logCerts) && k.logCerts[i].Round < last; i++ {
+		certificates = append(certificates, k.logCerts[i])
+	}
+	return certificates
+}
+
+// pruneCertificates drops the certificates of the batches before the log
+// start
+func (k *Kayak) pruneCertificates() {
+	i := sort.Search(len(k.logCerts), func(i int) bool {
+		return k.logCerts[i].Round+KIndex(len(k.logCerts[i].Buzz)) > k.logDataOffset
+	})
+	if i > 0 {
+		k.logCerts = append([]KCertificate(nil), k.logCerts[i:]...)
+	}
+}
+
+// collectSignatures returns the signer bitmap over the keys with the
+// signatures in the order of the keys
+func collectSignatures(keys []KAddress, signatures map[KAddress][]byte) ([]byte, [][]byte) {
+	signers := make([]byte, (len(keys)+7)/8)
+	var collected [][]byte
+	for i, key := range keys {
+		signature := signatures[key]
+		if signature == nil {
+			continue
+		}
+		signers[i/8] |= 1 << uint(i%8)
+		collected = append(collected, signature)
+	}
+	return signers, collected
+}
+
+// verifySignatures checks that a quorum of the keys signed the digest
+func verifySignatures(digest KHash, signers []byte, signatures [][]byte, keys []KAddress, weights []uint, model KFaultModel) error {
+	if len(weights) != len(keys) {
+		return errors.New("weights do not match the membership")
+	}
+
+	if len(signers) != (len(keys)+7)/8 {
+		return errors.New("signer bitmap does not match the membership")
+	}
+
+	var signed, signedWeight uint
+	for i, key := range keys {
+		if signers[i/8]&(1<<uint(i%8)) == 0 {
+			continue
+		}
+		if int(signed) >= len(signatures) {
+			return errors.New("not enough signatures for the signer bitmap")
+		}
+		if !ed25519.Verify(ed25519.PublicKey(key[:]), digest[:], signatures[signed]) {
+			return fmt.Errorf("invalid signature of %#v", key)
+		}
+		signed++
+		signedWeight += weights[i]
+	}
+
+	if int(signed) != len(signatures) {
+		return errors.New("too many signatures for the signer bitmap")
+	}
+
+	_, q := quorumFactors(weights, model)
+
+	if signedWeight < q {
+		return fmt.Errorf("quorum (%d/%d) not reached", signedWeight, q)
+	}
+
+	return nil
+}
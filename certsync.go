@@ -0,0 +1,87 @@
+package kayak
+
+// maybeCertifiedSync asks a single peer for the missing entries with their
+// commit certificates. If the peer does not deliver, the next peer is asked
+// after the whatsup period.
+func (k *Kayak) maybeCertifiedSync(t Tracer) bool {
+	t = t.Fork("maybeCertifiedSync")
+
+	if k.syncSent[k.mostRecentRoundKnown] && k.time < k.syncRetryAt {
+		k.traceF(t.Logf("sync already sent, retry at %#v", k.syncRetryAt))
+		return false
+	}
+
+	if k.n < 2 {
+		k.traceF(t.Logf("no peers to sync from"))
+		return false
+	}
+
+	peer := k.keys[(uint(k.rkeys[k.key])+1+k.syncAttempt%(k.n-1))%k.n]
+	k.syncAttempt++
+
+	k.traceF(t.Logf("gogo, sync from %#v to %#v with %#v", k.round, k.mostRecentRoundKnown, peer))
+
+	need := KNeed{Last: k.mostRecentRoundKnown, First: k.round, Certified: true}
+	k.sendF(peer, need)
+
+	k.syncSent[k.mostRecentRoundKnown] = true
+	k.syncRetryAt = k.time + k.whatsupT
+
+	k.rescheduleWhatsup(t)
+
+	return true
+}
+
+// applyCertifiedChunk decides the entries of the chunk starting from
+// indexFrom, as long as their certificates are valid. The membership used
+// to verify a certificate is known once the previous entries are applied.
+func (k *Kayak) applyCertifiedChunk(t Tracer, chunk KChunk, indexFrom int) {
+	t = t.Fork("applyCertifiedChunk")
+
+	var applied int
+	var verified *KCertificate
+	for i := indexFrom; i < len(chunk.Data); i++ {
+		if verified == nil || !verified.covers(k.round) {
+			verified = nil
+			for c := range chunk.Certificates {
+				if chunk.Certificates[c].covers(k.round) {
+					verified = &chunk.Certificates[c]
+					break
+				}
+			}
+			if verified == nil {
+				k.traceF(t.Logf("no certificate for %#v", k.round))
+				break
+			}
+
+			keys, weights := k.keysAtRound(verified.Round)
+			if err := checkCertificateSignatures(*verified, keys, weights, k.faultModel); err != nil {
+				k.traceF(t.Logf("certificate at %#v is invalid: %s", verified.Round, err))
+				break
+			}
+		}
+
+		buzz := hash(requestOf(chunk.Data[i], chunk.Origins[i]))
+		if err := checkCertifiedEntry(*verified, k.round, buzz); err != nil {
+			k.traceF(t.Logf("entry at %#v is not certified: %s", k.round, err))
+			break
+		}
+
+		certificate := *verified
+		k.decide(t, chunk.Data[i], buzz, chunk.Origins[i], &certificate)
+		applied++
+	}
+
+	if applied == 0 {
+		k.traceF(t.Logf("nothing applied"))
+		return
+	}
+
+	k.traceF(t.Logf("applied %d entries, now at %#v", applied, k.round))
+
+	if k.mostRecentRoundToSync < k.round {
+		k.mostRecentRoundToSync = k.round
+	}
+
+	k.completeUpdate(t)
+}
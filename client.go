@@ -11,14 +11,17 @@ import (
 type Client struct {
 	sync.Mutex
 
-	key        KAddress
-	serverKeys []KAddress
-	n, f, q    uint
+	key           KAddress
+	serverKeys    []KAddress
+	serverWeights []uint
+	n, f, q       uint
+	faultModel    KFaultModel
 
 	time        KTime
 	timeout     KTime
 	bonjourT    KTime
 	nextBonjour KTime
+	forwardT    KTime
 
 	lastKnownIndex           KIndex
 	hasFreshIndex            bool
@@ -32,6 +35,11 @@ type Client struct {
 	ticketsToSend map[KNonce]KTicket
 	sentTickets   map[KNonce]KTicket
 
+	// forwarded holds the requests sent to a single server, to broadcast
+	// them if not answered in time
+	forwarded  map[KNonce]KRequest
+	nextServer int
+
 	responseCounters map[KHash]map[KAddress]struct{}
 	responses        map[KHash]KResponse
 	tipCounters      map[KIndex]map[KAddress]struct{}
@@ -39,6 +47,22 @@ type Client struct {
 	responsesToReturn []KResponse
 	ticketsTimeout    []KTicket
 
+	readsToSend     map[KNonce]KReadTicket
+	sentReads       map[KNonce]KReadTicket
+	entriesCounters map[KNonce]map[KHash]map[KAddress]struct{}
+	readsToReturn   []KReadReturn
+	readsTimeout    []KReadTicket
+
+	// sessions makes the client number its requests, which are sent one at
+	// a time in the order of their sequence numbers. The session is opened
+	// at random when the client is made, a restarted client starts over in
+	// a new session.
+	sessions bool
+	session  KNonce
+	sequence uint64
+
+	requireSignatures bool
+
 	byzantineFlags int
 }
 
@@ -47,33 +71,62 @@ func NewClient(c *KClientConfig) *Client {
 	serverKeys := make([]KAddress, len(c.ServerKeys))
 	copy(serverKeys[:], c.ServerKeys[:])
 
+	serverWeights, weightsErr := makeWeights(serverKeys, c.ServerWeights)
+	if weightsErr != nil {
+		serverWeights, _ = makeWeights(serverKeys, nil)
+	}
+
 	ticketsToSend := make(map[KNonce]KTicket)
 	sentTickets := make(map[KNonce]KTicket)
+	forwarded := make(map[KNonce]KRequest)
 
 	responseCounters := make(map[KHash]map[KAddress]struct{})
 	responses := make(map[KHash]KResponse)
 	tipCounters := make(map[KIndex]map[KAddress]struct{})
 
+	readsToSend := make(map[KNonce]KReadTicket)
+	sentReads := make(map[KNonce]KReadTicket)
+	entriesCounters := make(map[KNonce]map[KHash]map[KAddress]struct{})
+
 	client := Client{
 		key:              c.Key,
 		serverKeys:       serverKeys,
+		serverWeights:    serverWeights,
+		faultModel:       c.FaultModel,
 		timeout:          KTime(c.CallT),
 		bonjourT:         KTime(c.BonjourT),
+		forwardT:         KTime(c.ForwardT),
 		ticketsToSend:    ticketsToSend,
 		sentTickets:      sentTickets,
+		forwarded:        forwarded,
 		responseCounters: responseCounters,
 		responses:        responses,
 		tipCounters:      tipCounters,
+		readsToSend:      readsToSend,
+		sentReads:        sentReads,
+		entriesCounters:  entriesCounters,
 		extSendF:         c.SendF,
 		extReturnF:       c.ReturnF,
 		extTraceF:        c.TraceF,
 		extErrorF:        c.ErrorF,
 
+		sessions: c.Sessions,
+
+		requireSignatures: c.RequireSignatures,
+
 		byzantineFlags: c.ByzantineFlags,
 	}
 
 	client.updateFactors()
 
+	if client.sessions {
+		client.session = client.getNonce()
+	}
+
+	if weightsErr != nil {
+		client.errorF(weightsErr)
+	}
+
 	return &client
 }
 
@@ -97,6 +150,8 @@ func (c *Client) ReceiveCall(payload interface{}) {
 	switch msg := payload.(type) {
 	case KCall:
 		c.receiveCall(t, msg)
+	case KReadCall:
+		c.receiveReadCall(t, msg)
 	default:
 		c.errorF(t.Errorf("unknown message: %#v", payload))
 	}
@@ -113,11 +168,19 @@ func (c *Client) ReceiveNet(from KAddress, payload interface{}) {
 
 	t := NewTracer("[C]            ")
 
+	payload, _, err := unwrapSigned(from, payload, c.requireSignatures)
+	if err != nil {
+		c.traceF(t.Logf("rejected as %s", err))
+		return
+	}
+
 	switch msg := payload.(type) {
 	case KResponse:
 		c.receiveResponse(t, from, msg)
 	case KTip:
 		c.receiveTip(t, from, msg)
+	case KEntries:
+		c.receiveEntries(t, from, msg)
 	default:
 		c.errorF(t.Errorf("unknown message: %#v", payload))
 	}
@@ -148,6 +211,7 @@ func (c *Client) Tick(_tick uint) {
 
 	for i := range c.ticketsTimeout {
 		delete(c.sentTickets, c.ticketsTimeout[i].Nonce)
+		delete(c.forwarded, c.ticketsTimeout[i].Nonce)
 	}
 
 	c.traceF(t.Logf("after timeout: %d sent tickets wait for responses", len(c.sentTickets)))
@@ -166,10 +230,34 @@ func (c *Client) Tick(_tick uint) {
 
 	c.traceF(t.Logf("after timeout: %d tickets to send wait for responses", len(c.ticketsToSend)))
 
+	for nonce := range c.sentReads {
+		if c.sentReads[nonce].Timestamp+c.timeout <= c.time {
+			c.traceF(t.Logf("read timeout %#v", c.sentReads[nonce]))
+			c.readsTimeout = append(c.readsTimeout, c.sentReads[nonce])
+		}
+	}
+
+	for nonce := range c.readsToSend {
+		if c.readsToSend[nonce].Timestamp+c.timeout <= c.time {
+			c.traceF(t.Logf("read timeout %#v", c.readsToSend[nonce]))
+			c.readsTimeout = append(c.readsTimeout, c.readsToSend[nonce])
+		}
+	}
+
+	for i := range c.readsTimeout {
+		delete(c.sentReads, c.readsTimeout[i].Nonce)
+		delete(c.readsToSend, c.readsTimeout[i].Nonce)
+		delete(c.entriesCounters, c.readsTimeout[i].Nonce)
+	}
+
+	c.traceF(t.Logf("%d reads wait for entries", len(c.sentReads)+len(c.readsToSend)))
+
 	c.proceed(t)
 }
 
-func (c *Client) ReconfigureTo(keys []KAddress) {
+// ReconfigureTo replaces the servers, each server weighs 1 if no weights are
+// given
+func (c *Client) ReconfigureTo(keys []KAddress, weights []uint) {
 	c.Lock()
 	defer c.Unlock()
 
@@ -177,6 +265,12 @@ func (c *Client) ReconfigureTo(keys []KAddress) {
 	t := NewTracer("[C]            ")
 	keys = append([]KAddress(nil), keys...)
 
+	serverWeights, err := makeWeights(keys, weights)
+	if err != nil {
+		c.errorF(t.Errorf("cannot reconfigure: %s", err))
+		return
+	}
+
 	c.traceF(t.Logf("old keys %#v", c.serverKeys))
 	c.traceF(t.Logf("new keys %#v", keys))
 
@@ -220,7 +314,16 @@ func (c *Client) ReconfigureTo(keys []KAddress) {
 	}
 	c.tipCounters = tipCounters
 
+	for nonce := range c.entriesCounters {
+		for hash := range c.entriesCounters[nonce] {
+			for address := range removedKeysMap {
+				delete(c.entriesCounters[nonce][hash], address)
+			}
+		}
+	}
+
 	c.serverKeys = keys
+	c.serverWeights = serverWeights
 	c.updateFactors()
 
 	c.traceF(t.Logf("--------------------------------------------------------------------"))
@@ -249,8 +352,12 @@ func (c *Client) tryProceed(t Tracer) bool {
 
 	progressMade = progressMade || c.maybeBonjour(t)
 	progressMade = progressMade || c.maybeSendTickets(t)
+	progressMade = progressMade || c.maybeBroadcastForwarded(t)
 	progressMade = progressMade || c.maybeReturnResponses(t)
 	progressMade = progressMade || c.maybeReturnTimeouts(t)
+	progressMade = progressMade || c.maybeSendReads(t)
+	progressMade = progressMade || c.maybeReturnReads(t)
+	progressMade = progressMade || c.maybeReturnReadTimeouts(t)
 
 	return progressMade
 }
@@ -276,15 +383,47 @@ func (c *Client) receiveCall(t Tracer, call KCall) {
 		Payload:   call.Payload,
 	}
 
+	if c.sessions {
+		ticket.Sequence = call.Sequence
+		if ticket.Sequence == 0 {
+			c.sequence++
+			ticket.Sequence = c.sequence
+		}
+	}
+
 	c.traceF(t.Logf("made %#v", ticket))
 
 	c.ticketsToSend[nonce] = ticket
 
 }
 
+func (c *Client) receiveReadCall(t Tracer, call KReadCall) {
+	t = t.Fork("receiveReadCall")
+
+	ticket := KReadTicket{
+		Tag:       call.Tag,
+		Timestamp: c.time,
+		Nonce:     c.getNonce(),
+		First:     call.First,
+		Last:      call.Last,
+	}
+
+	c.traceF(t.Logf("made %#v", ticket))
+
+	c.readsToSend[ticket.Nonce] = ticket
+
+}
+
 func (c *Client) receiveResponse(t Tracer, from KAddress, response KResponse) {
 	t = t.Fork("receiveResponse")
 
+	// The responses after the quorum are late, there may be enough of them
+	// for another quorum if the quorum is at most half of the servers
+	if _, waiting := c.sentTickets[response.Nonce]; !waiting {
+		c.traceF(t.Logf("ignored as no request is waiting for it"))
+		return
+	}
+
 	responseHash := hash(response)
 
 	if _, ok := c.responseCounters[responseHash]; !ok {
@@ -300,15 +439,67 @@ func (c *Client) receiveResponse(t Tracer, from KAddress, response KResponse) {
 		c.traceF(t.Logf("response with its hash has been already received"))
 	}
 
-	if uint(len(c.responseCounters[responseHash])) >= c.q {
-		c.traceF(t.Logf("response quorum (%d/%d) reached", uint(len(c.responseCounters[responseHash])), c.q))
+	if c.votes(c.responseCounters[responseHash]) >= c.q {
+		c.traceF(t.Logf("response quorum (%d/%d) reached", c.votes(c.responseCounters[responseHash]), c.q))
 		c.responsesToReturn = append(c.responsesToReturn, response)
 		delete(c.responseCounters, responseHash)
 		delete(c.responses, responseHash)
 	} else {
-		c.traceF(t.Logf("response quorum (%d/%d) not reached", uint(len(c.responseCounters[responseHash])), c.q))
+		c.traceF(t.Logf("response quorum (%d/%d) not reached", c.votes(c.responseCounters[responseHash]), c.q))
+	}
+
+}
+
+// receiveEntries accepts the entries once f+1 servers returned the same ones,
+// at least one of them is correct
+func (c *Client) receiveEntries(t Tracer, from KAddress, entries KEntries) {
+	t = t.Fork("receiveEntries")
+
+	ticket, waiting := c.sentReads[entries.Nonce]
+	if !waiting {
+		c.traceF(t.Logf("ignored as no read is waiting for it"))
+		return
+	}
+
+	entriesHash := hash(entries)
+
+	if _, ok := c.entriesCounters[entries.Nonce]; !ok {
+		c.entriesCounters[entries.Nonce] = make(map[KHash]map[KAddress]struct{})
+	}
+
+	// A server voting twice for different entries is counted for the first
+	for hash := range c.entriesCounters[entries.Nonce] {
+		if _, alreadyReceived := c.entriesCounters[entries.Nonce][hash][from]; alreadyReceived {
+			c.traceF(t.Logf("ignored as already received from the server"))
+			return
+		}
+	}
+
+	if _, ok := c.entriesCounters[entries.Nonce][entriesHash]; !ok {
+		c.entriesCounters[entries.Nonce][entriesHash] = make(map[KAddress]struct{})
+	}
+
+	c.entriesCounters[entries.Nonce][entriesHash][from] = struct{}{}
+
+	votes := c.votes(c.entriesCounters[entries.Nonce][entriesHash])
+	if votes < c.f+1 {
+		c.traceF(t.Logf("entries quorum (%d/%d) not reached", votes, c.f+1))
+		return
 	}
 
+	c.traceF(t.Logf("entries quorum (%d/%d) reached", votes, c.f+1))
+	c.readsToReturn = append(c.readsToReturn, KReadReturn{
+		Tag:   ticket.Tag,
+		First: entries.First,
+		Data:  entries.Data,
+		Hash:  entries.Hash,
+		Error: entries.Error,
+	})
+
+	c.traceF(t.Logf("remove read as completed %#v", ticket))
+	delete(c.sentReads, entries.Nonce)
+	delete(c.entriesCounters, entries.Nonce)
+
 }
 
 func (c *Client) receiveTip(t Tracer, from KAddress, tip KTip) {
@@ -325,15 +516,15 @@ func (c *Client) receiveTip(t Tracer, from KAddress, tip KTip) {
 
 	c.tipCounters[tip.Round][from] = struct{}{}
 
-	if uint(len(c.tipCounters[tip.Round])) >= c.q {
-		c.traceF(t.Logf("tip quorum (%d/%d) reached", uint(len(c.tipCounters[tip.Round])), c.q))
+	if c.votes(c.tipCounters[tip.Round]) >= c.q {
+		c.traceF(t.Logf("tip quorum (%d/%d) reached", c.votes(c.tipCounters[tip.Round]), c.q))
 		c.traceF(t.Logf("update last known index from %#v to %#v", c.lastKnownIndex, tip.Round))
 		c.lastKnownIndex = tip.Round
 		c.hasFreshIndex = true
 
 		// TODO: cleanup old indexes
 	} else {
-		c.traceF(t.Logf("tip quorum (%d/%d) not reached", uint(len(c.tipCounters[tip.Round])), c.q))
+		c.traceF(t.Logf("tip quorum (%d/%d) not reached", c.votes(c.tipCounters[tip.Round]), c.q))
 	}
 
 }
@@ -380,26 +571,105 @@ func (c *Client) maybeSendTickets(t Tracer) bool {
 		return false
 	}
 
+	if c.sessions {
+		return c.maybeSendNextInSession(t)
+	}
+
 	c.traceF(t.Logf("gogo"))
 
 	for nonce := range c.ticketsToSend {
-		request := KRequest{
-			Payload: c.ticketsToSend[nonce].Payload,
-			Nonce:   c.ticketsToSend[nonce].Nonce,
-			Index:   c.lastKnownIndex,
+		c.sendTicket(t, c.ticketsToSend[nonce])
+	}
+
+	c.ticketsToSend = make(map[KNonce]KTicket)
+
+	return true
+}
+
+// maybeSendNextInSession sends the ticket with the lowest sequence number
+// once the previous one is answered or timed out, so that the servers decide
+// the requests of the session in order
+func (c *Client) maybeSendNextInSession(t Tracer) bool {
+	if len(c.sentTickets) > 0 {
+		c.traceF(t.Logf("%d tickets in flight in the session", len(c.sentTickets)))
+		return false
+	}
+
+	var next KTicket
+	for nonce := range c.ticketsToSend {
+		if next.Sequence == 0 || c.ticketsToSend[nonce].Sequence < next.Sequence {
+			next = c.ticketsToSend[nonce]
 		}
+	}
+
+	c.traceF(t.Logf("gogo, sequence %d", next.Sequence))
 
+	c.sendTicket(t, next)
+	delete(c.ticketsToSend, next.Nonce)
+
+	return true
+}
+
+func (c *Client) sendTicket(t Tracer, ticket KTicket) {
+	request := KRequest{
+		Payload:  ticket.Payload,
+		Nonce:    ticket.Nonce,
+		Index:    c.lastKnownIndex,
+		Sequence: ticket.Sequence,
+	}
+	if ticket.Sequence != 0 {
+		request.Client = c.key
+		request.Session = c.session
+	}
+
+	// The request in a session is admitted from the client only
+	if c.forwardT > 0 && ticket.Sequence == 0 {
+		key := c.serverKeys[c.nextServer%len(c.serverKeys)]
+		c.nextServer++
+		c.traceF(t.Logf("send to %#v only, to be forwarded", key))
+		c.sendF(key, request)
+		c.forwarded[ticket.Nonce] = request
+	} else {
 		for _, key := range c.serverKeys {
 			c.sendF(key, request)
 		}
+	}
+
+	c.sentTickets[ticket.Nonce] = ticket
+}
 
-		c.sentTickets[nonce] = c.ticketsToSend[nonce]
+// maybeBroadcastForwarded sends the request to all the servers if the
+// server it was sent to did not get it decided in time, the request is the
+// same so that it is decided once
+func (c *Client) maybeBroadcastForwarded(t Tracer) bool {
+	t = t.Fork("maybeBroadcastForwarded")
 
+	if len(c.forwarded) == 0 {
+		c.traceF(t.Logf("no forwarded requests"))
+		return false
 	}
 
-	c.ticketsToSend = make(map[KNonce]KTicket)
+	var progressMade bool
 
-	return true
+	for nonce, request := range c.forwarded {
+		if c.sentTickets[nonce].Timestamp+c.forwardT > c.time {
+			continue
+		}
+
+		c.traceF(t.Logf("broadcast %#v as not answered in time", request))
+		for _, key := range c.serverKeys {
+			c.sendF(key, request)
+		}
+
+		delete(c.forwarded, nonce)
+		progressMade = true
+	}
+
+	if !progressMade {
+		c.traceF(t.Logf("no forwarded requests to broadcast yet"))
+	}
+
+	return progressMade
 }
 
 func (c *Client) maybeReturnResponses(t Tracer) bool {
@@ -413,20 +683,28 @@ func (c *Client) maybeReturnResponses(t Tracer) bool {
 	c.traceF(t.Logf("gogo, returning %d responses", len(c.responsesToReturn)))
 
 	for i := range c.responsesToReturn {
-		// TODO: check errors
 		ticket, ticketFound := c.sentTickets[c.responsesToReturn[i].Nonce]
+		if !ticketFound && c.responsesToReturn[i].Error != ErrorReasonNone {
+			// Servers which received the request late may reject it after
+			// it has been decided
+			c.traceF(t.Logf("ignore rejection %#v of non-existing request", c.responsesToReturn[i]))
+			continue
+		}
 		if !ticketFound {
 			c.errorF(t.Errorf("received response for non-existing request %#v", c.responsesToReturn[i].Nonce))
 			continue
 		}
 		r := KReturn{
-			Tag:   ticket.Tag,
-			Index: c.responsesToReturn[i].Index,
+			Tag:      ticket.Tag,
+			Index:    c.responsesToReturn[i].Index,
+			Sequence: ticket.Sequence,
+			Error:    c.responsesToReturn[i].Error,
 		}
 		c.returnF(r)
 
 		c.traceF(t.Logf("remove ticket as completed %#v", c.sentTickets[c.responsesToReturn[i].Nonce]))
 		delete(c.sentTickets, c.responsesToReturn[i].Nonce)
+		delete(c.forwarded, c.responsesToReturn[i].Nonce)
 	}
 
 	c.responsesToReturn = nil
@@ -447,8 +725,9 @@ func (c *Client) maybeReturnTimeouts(t Tracer) bool {
 
 	for i := range c.ticketsTimeout {
 		r := KReturn{
-			Tag:     c.ticketsTimeout[i].Tag,
-			Timeout: true,
+			Tag:      c.ticketsTimeout[i].Tag,
+			Sequence: c.ticketsTimeout[i].Sequence,
+			Timeout:  true,
 		}
 		c.returnF(r)
 	}
@@ -459,6 +738,80 @@ func (c *Client) maybeReturnTimeouts(t Tracer) bool {
 
 }
 
+func (c *Client) maybeSendReads(t Tracer) bool {
+	t = t.Fork("maybeSendReads")
+
+	if len(c.readsToSend) == 0 {
+		c.traceF(t.Logf("no reads to send"))
+		return false
+	}
+
+	c.traceF(t.Logf("gogo"))
+
+	for nonce := range c.readsToSend {
+		read := KRead{
+			Nonce: nonce,
+			First: c.readsToSend[nonce].First,
+			Last:  c.readsToSend[nonce].Last,
+		}
+
+		for _, key := range c.serverKeys {
+			c.sendF(key, read)
+		}
+
+		c.sentReads[nonce] = c.readsToSend[nonce]
+
+	}
+
+	c.readsToSend = make(map[KNonce]KReadTicket)
+
+	return true
+}
+
+func (c *Client) maybeReturnReads(t Tracer) bool {
+	t = t.Fork("maybeReturnReads")
+
+	if len(c.readsToReturn) == 0 {
+		c.traceF(t.Logf("no reads to return"))
+		return false
+	}
+
+	c.traceF(t.Logf("gogo, returning %d reads", len(c.readsToReturn)))
+
+	for i := range c.readsToReturn {
+		c.returnF(c.readsToReturn[i])
+	}
+
+	c.readsToReturn = nil
+
+	return true
+
+}
+
+func (c *Client) maybeReturnReadTimeouts(t Tracer) bool {
+	t = t.Fork("maybeReturnReadTimeouts")
+
+	if len(c.readsTimeout) == 0 {
+		c.traceF(t.Logf("no reads - no timeouts to return"))
+		return false
+	}
+
+	c.traceF(t.Logf("gogo, returning %d timeouts", len(c.readsTimeout)))
+
+	for i := range c.readsTimeout {
+		r := KReadReturn{
+			Tag:     c.readsTimeout[i].Tag,
+			Timeout: true,
+		}
+		c.returnF(r)
+	}
+
+	c.readsTimeout = nil
+
+	return true
+
+}
+
 func (c *Client) getNonce() KNonce {
 	buf := make([]byte, NonceSize)
 	var nonce KNonce
@@ -474,16 +827,25 @@ func (c *Client) getNonce() KNonce {
 	return nonce
 }
 
+// updateFactors computes the response quorum. Correct servers do not lie in
+// the crash model, so f+1 matching responses are enough.
 func (c *Client) updateFactors() {
-	c.f = uint(len(c.serverKeys)-1) / 3
 	c.n = uint(len(c.serverKeys))
-	c.q = (c.n+c.f)/2 + 1
+	c.f, c.q = quorumFactors(c.serverWeights, c.faultModel)
+	if c.faultModel == FaultModelCrash {
+		c.q = c.f + 1
+	}
+}
 
-	// Special case for 2-process system
-	// TODO: test 2-process configuration for consensus stability
-	if c.n == 2 {
-		c.q = 1
+// votes sums the weights of the servers, other senders weigh nothing
+func (c *Client) votes(voters map[KAddress]struct{}) uint {
+	var w uint
+	for i, key := range c.serverKeys {
+		if _, found := voters[key]; found {
+			w += c.serverWeights[i]
+		}
 	}
+	return w
 }
 
 func (c *Client) sendF(to KAddress, payload interface{}) {
@@ -1,6 +1,9 @@
 package kayak
 
-import "bytes"
+import (
+	"bytes"
+	"encoding/binary"
+)
 
 func (k *Kayak) receiveRequest(t Tracer, from KAddress, request KRequest) {
 	t = t.Fork("receiveRequest")
@@ -14,37 +17,130 @@ func (k *Kayak) receiveRequest(t Tracer, from KAddress, request KRequest) {
 	}
 	// ======== End of Byzantine behavior ========
 
-	if _, fromServer := k.rkeys[from]; !fromServer && !k.allowExternal {
-		k.traceF(t.Logf("rejected as only internal requests allowed"))
+	if !k.admitRequest(t, from, request, false) {
 		return
 	}
 
-	if request.Index > k.round {
-		k.traceF(t.Logf("request index is ahead"))
-		return
+	// The request in a session is sent to all the servers, as the leader
+	// does not admit it from another server
+	if k.forwardRequests && k.leader() != k.key && request.Sequence == 0 {
+		k.traceF(t.Logf("forward to leader %#v", k.leader()))
+		k.sendF(k.leader(), KLoad{From: from, Request: request})
 	}
+}
 
-	if request.Index+k.indexTolerance < k.round {
-		k.traceF(t.Logf("request index is too behind -- client out of sync or replay attack"))
+// receiveLoad admits the request which a follower forwarded to the leader,
+// it is answered to the client which sent it once decided. The follower
+// vouches for the client, which did not sign the request, so the request is
+// not charged to the quotas of the client, and is not admitted if it would
+// advance the session of the client.
+func (k *Kayak) receiveLoad(t Tracer, from KAddress, load KLoad) {
+	t = t.Fork("receiveLoad")
+
+	if _, fromServer := k.rkeys[from]; !fromServer {
+		k.traceF(t.Logf("rejected as not from server"))
 		return
 	}
 
+	k.admitRequest(t, load.From, load.Request, true)
+}
+
+// admitRequest records the request of the client as a job, unless it is
+// rejected or already pending. The forwarded request is not rejected to the
+// client, the follower which forwarded it already answered the rejections.
+func (k *Kayak) admitRequest(t Tracer, from KAddress, request KRequest, forwarded bool) bool {
+	reject := func(reason KErrorReason) {
+		if !forwarded {
+			k.reject(from, request, reason)
+		}
+	}
+
+	if _, fromServer := k.rkeys[from]; !fromServer && !k.allowExternal {
+		k.traceF(t.Logf("rejected as only internal requests allowed"))
+		reject(ErrorReasonNotAllowed)
+		return false
+	}
+
+	if k.maxPayload > 0 && uint(len(request.Payload)) > k.maxPayload {
+		k.traceF(t.Logf("rejected as payload of %d bytes exceeds the limit %d", len(request.Payload), k.maxPayload))
+		reject(ErrorReasonTooLarge)
+		return false
+	}
+
+	// The leader cannot tell that the client sent a forwarded request, which
+	// would advance its session once decided
+	if request.Sequence != 0 && (forwarded || request.Client != from) {
+		k.traceF(t.Logf("rejected as the session is not of the sender"))
+		reject(ErrorReasonNotAllowed)
+		return false
+	}
+
+	if k.isSequenceApplied(request) {
+		k.answerSession(t, from, request)
+		return false
+	}
+
+	// The retry is answered once the pending request is decided
+	if k.hasPendingSequence(request) {
+		k.traceF(t.Logf("sequence %d of the client is pending", request.Sequence))
+		return false
+	}
+
 	buzz := hash(request)
-	if _, alreadyReceived := k.jobs[buzz]; alreadyReceived {
+
+	// The pending request is answered once decided
+	if _, alreadyReceived := k.jobs.get(buzz); alreadyReceived {
 		k.traceF(t.Logf("buzz found in jobs, possible replay attack"))
-		return
+		return false
 	}
 
 	if _, alreadyProcessed := k.setBuzz[buzz]; alreadyProcessed {
 		k.traceF(t.Logf("buzz found in processed requests, possible replay attack"))
-		return
+		reject(ErrorReasonReplay)
+		return false
 	}
 
-	job := KJob{From: from, Timestamp: k.time, Request: request}
+	if request.Index > k.round {
+		k.traceF(t.Logf("request index is ahead"))
+		reject(ErrorReasonAhead)
+		return false
+	}
+
+	if request.Index+k.indexTolerance < k.round {
+		k.traceF(t.Logf("request index is too behind -- client out of sync or replay attack"))
+		reject(ErrorReasonTooOld)
+		return false
+	}
+
+	if err := k.validateF(request.Payload); err != nil {
+		k.traceF(t.Logf("rejected as invalid: %s", err))
+		reject(ErrorReasonInvalid)
+		return false
+	}
+
+	if !k.checkPending(t, from, forwarded) {
+		k.traceF(t.Logf("rejected as too many jobs pending"))
+		reject(ErrorReasonOverloaded)
+		return false
+	}
+
+	if !forwarded && !k.takeToken(t, from) {
+		k.traceF(t.Logf("rejected as rate limited"))
+		reject(ErrorReasonRateLimited)
+		return false
+	}
+
+	job := KJob{From: from, Timestamp: k.time, Request: request, Forwarded: forwarded}
 	k.traceF(t.Logf("created new %#v", job))
-	k.jobs[buzz] = &job
-	k.updateEarliestJobTimestamp()
+	k.jobs.add(buzz, &job)
 	k.traceF(t.Logf("recorded"))
+	return true
+}
+
+// reject sends a negative response to the request. The index is not set so
+// that the rejections of different servers are the same.
+func (k *Kayak) reject(to KAddress, request KRequest, reason KErrorReason) {
+	k.sendF(to, KResponse{Nonce: request.Nonce, Error: reason})
 }
 
 // TODO: 3 functions are essentially the same. With generics it'd be cleaner
@@ -56,6 +152,11 @@ func (k *Kayak) receivePropose(t Tracer, from KAddress, propose KPropose) {
 		return
 	}
 
+	if propose.Round < k.round || (propose.Round == k.round && propose.Epoch < k.epoch) {
+		k.traceF(t.Logf("rejected as outdated"))
+		return
+	}
+
 	if _, ok := k.proposes[propose.Round]; !ok {
 		k.proposes[propose.Round] = make(map[KEpoch]map[KAddress]KPropose)
 	}
@@ -69,10 +170,8 @@ func (k *Kayak) receivePropose(t Tracer, from KAddress, propose KPropose) {
 		return
 	}
 
-	buzz := hash(propose.Job.Request)
-
-	if _, alreadyProcessed := k.setBuzz[buzz]; alreadyProcessed {
-		k.traceF(t.Logf("rejected as already processed"))
+	if !k.checkBatch(t, propose.Jobs, propose.Round) {
+		k.traceF(t.Logf("rejected as invalid batch"))
 		return
 	}
 
@@ -81,7 +180,9 @@ func (k *Kayak) receivePropose(t Tracer, from KAddress, propose KPropose) {
 
 }
 
-func (k *Kayak) receiveWrite(t Tracer, from KAddress, write KWrite) {
+// receiveWrite keeps the signature of the write, if any, to prove the write
+// quorum in a view change
+func (k *Kayak) receiveWrite(t Tracer, from KAddress, write KWrite, signature []byte) {
 	t = t.Fork("receiveWrite")
 
 	if _, fromServer := k.rkeys[from]; !fromServer {
@@ -89,16 +190,21 @@ func (k *Kayak) receiveWrite(t Tracer, from KAddress, write KWrite) {
 		return
 	}
 
+	if write.Round < k.round || (write.Round == k.round && write.Epoch < k.epoch) {
+		k.traceF(t.Logf("rejected as outdated"))
+		return
+	}
+
 	if _, ok := k.writes[write.Round]; !ok {
-		k.writes[write.Round] = make(map[KEpoch]map[KHash]map[KAddress]struct{})
+		k.writes[write.Round] = make(map[KEpoch]map[KHash]map[KAddress][]byte)
 	}
 
 	if _, ok := k.writes[write.Round][write.Epoch]; !ok {
-		k.writes[write.Round][write.Epoch] = make(map[KHash]map[KAddress]struct{})
+		k.writes[write.Round][write.Epoch] = make(map[KHash]map[KAddress][]byte)
 	}
 
 	if _, ok := k.writes[write.Round][write.Epoch][write.Hash]; !ok {
-		k.writes[write.Round][write.Epoch][write.Hash] = make(map[KAddress]struct{})
+		k.writes[write.Round][write.Epoch][write.Hash] = make(map[KAddress][]byte)
 	}
 
 	if _, alreadyReceived := k.writes[write.Round][write.Epoch][write.Hash][from]; alreadyReceived {
@@ -106,12 +212,14 @@ func (k *Kayak) receiveWrite(t Tracer, from KAddress, write KWrite) {
 		return
 	}
 
-	k.writes[write.Round][write.Epoch][write.Hash][from] = struct{}{}
+	k.writes[write.Round][write.Epoch][write.Hash][from] = signature
 	k.traceF(t.Logf("recorded"))
 
 }
 
-func (k *Kayak) receiveAccept(t Tracer, from KAddress, accept KAccept) {
+// receiveAccept keeps the signature of the accept, if any, to build the
+// commit certificate
+func (k *Kayak) receiveAccept(t Tracer, from KAddress, accept KAccept, signature []byte) {
 	t = t.Fork("receiveAccept")
 
 	if _, fromServer := k.rkeys[from]; !fromServer {
@@ -119,16 +227,21 @@ func (k *Kayak) receiveAccept(t Tracer, from KAddress, accept KAccept) {
 		return
 	}
 
+	if accept.Round < k.round || (accept.Round == k.round && accept.Epoch < k.epoch) {
+		k.traceF(t.Logf("rejected as outdated"))
+		return
+	}
+
 	if _, ok := k.accepts[accept.Round]; !ok {
-		k.accepts[accept.Round] = make(map[KEpoch]map[KHash]map[KAddress]struct{})
+		k.accepts[accept.Round] = make(map[KEpoch]map[KHash]map[KAddress][]byte)
 	}
 
 	if _, ok := k.accepts[accept.Round][accept.Epoch]; !ok {
-		k.accepts[accept.Round][accept.Epoch] = make(map[KHash]map[KAddress]struct{})
+		k.accepts[accept.Round][accept.Epoch] = make(map[KHash]map[KAddress][]byte)
 	}
 
 	if _, ok := k.accepts[accept.Round][accept.Epoch][accept.Hash]; !ok {
-		k.accepts[accept.Round][accept.Epoch][accept.Hash] = make(map[KAddress]struct{})
+		k.accepts[accept.Round][accept.Epoch][accept.Hash] = make(map[KAddress][]byte)
 	}
 
 	if _, alreadyReceived := k.accepts[accept.Round][accept.Epoch][accept.Hash][from]; alreadyReceived {
@@ -136,7 +249,7 @@ func (k *Kayak) receiveAccept(t Tracer, from KAddress, accept KAccept) {
 		return
 	}
 
-	k.accepts[accept.Round][accept.Epoch][accept.Hash][from] = struct{}{}
+	k.accepts[accept.Round][accept.Epoch][accept.Hash][from] = signature
 	k.traceF(t.Logf("recorded"))
 
 }
@@ -149,32 +262,60 @@ func (k *Kayak) maybePropose(t Tracer) bool {
 		return false
 	}
 
-	if len(k.jobs) == 0 {
+	if k.transferring {
+		k.traceF(t.Logf("leadership is being transferred"))
+		return false
+	}
+
+	if k.privateKey != nil && k.viewEpoch != k.epoch {
+		k.traceF(t.Logf("view of %#v not known", k.epoch))
+		return false
+	}
+
+	if uint(len(k.slots)) >= k.pipelineDepth {
+		k.traceF(t.Logf("pipeline is full with %d slots", len(k.slots)))
+		return false
+	}
+
+	if carried, found := k.carried[k.nextRound()]; found {
+		return k.proposeCarried(t, carried)
+	}
+
+	if k.jobs.Len() == 0 {
 		k.traceF(t.Logf("no jobs"))
 		return false
 	}
 
-	if k.consensusState != ConsensusStateIdle {
-		k.traceF(t.Logf("not in expected %#v state", ConsensusStateIdle))
+	batch, full := k.pickBatch(t)
+
+	if len(batch) == 0 {
+		k.traceF(t.Logf("all jobs are in flight"))
 		return false
 	}
 
-	// Pick first job
-	var job KJob
-	for jobHash := range k.jobs {
-		job = *k.jobs[jobHash]
-		break
+	if !k.batchPending {
+		k.batchPending = true
+		k.batchSince = k.time
+	}
+
+	if !full && k.batchSince+k.batchT > k.time {
+		k.traceF(t.Logf("waiting for more jobs until %#v", k.batchSince+k.batchT))
+		return false
 	}
 
-	k.traceF(t.Logf("gogo, total jobs: %d, picked %#v", len(k.jobs), job))
+	k.batchPending = false
+
+	round := k.nextRound()
 
-	propose := KPropose{Round: k.round, Epoch: k.epoch, Job: job}
+	k.traceF(t.Logf("gogo, total jobs: %d, picked %d for round %#v", k.jobs.Len(), len(batch), round))
+
+	propose := KPropose{Round: round, Epoch: k.epoch, Jobs: batch}
 	for i, key := range k.keys {
 
 		// ====== Byzantine behavior if enabled ======
 		if k.byzantineFlags&ByzantineFlagSendDifferentProposes != 0 {
 			t2 := t.Fork("ByzantineFlagSendDifferentProposes")
-			if len(k.jobs) < 2 {
+			if k.jobs.Len() < 2 {
 				k.traceF(t2.Logf("not enough jobs, returning"))
 				return false
 			}
@@ -184,15 +325,15 @@ func (k *Kayak) maybePropose(t Tracer) bool {
 			} else {
 				k.traceF(t2.Logf("sending another"))
 				var anotherJob KJob
-				for jobHash := range k.jobs {
-					if k.jobs[jobHash].Request.Nonce == job.Request.Nonce {
-						continue
+				k.jobs.ascend(func(_ KHash, job *KJob) bool {
+					if job.Request.Nonce == batch[0].Request.Nonce {
+						return true
 					}
-					anotherJob = *k.jobs[jobHash]
-					break
-				}
+					anotherJob = *job
+					return false
+				})
 
-				anotherPropose := KPropose{Round: k.round, Epoch: k.epoch, Job: anotherJob}
+				anotherPropose := KPropose{Round: round, Epoch: k.epoch, Jobs: []KJob{anotherJob}}
 				k.sendF(key, anotherPropose)
 			}
 			continue
@@ -202,8 +343,8 @@ func (k *Kayak) maybePropose(t Tracer) bool {
 		k.sendF(key, propose)
 	}
 
-	k.traceF(t.Logf("modify consensus state from %#v to %#v", k.consensusState, ConsensusStateIdlePropose))
-	k.consensusState = ConsensusStateIdlePropose
+	k.traceF(t.Logf("add slot at %#v in %#v state", round, ConsensusStateIdlePropose))
+	k.slots = append(k.slots, newSlot(round, ConsensusStateIdlePropose, batch))
 	return true
 
 }
@@ -211,102 +352,215 @@ func (k *Kayak) maybePropose(t Tracer) bool {
 func (k *Kayak) maybeWrite(t Tracer) bool {
 	t = t.Fork("maybeWrite")
 
+	if k.privateKey != nil && k.viewEpoch != k.epoch {
+		k.traceF(t.Logf("view of %#v not known", k.epoch))
+		return false
+	}
+
+	var target *slot
+	var round KRound
+
 	if k.key == k.leader() {
-		if k.consensusState != ConsensusStateIdlePropose {
-			k.traceF(t.Logf("leader not in expected %#v state", ConsensusStateIdlePropose))
+		for _, s := range k.slots {
+			if s.state == ConsensusStateIdlePropose {
+				target = s
+				break
+			}
+		}
+		if target == nil {
+			k.traceF(t.Logf("leader has no slot in expected %#v state", ConsensusStateIdlePropose))
 			return false
 		}
+		round = target.round
 	} else {
-		if k.consensusState != ConsensusStateIdle {
-			k.traceF(t.Logf("follower not in expected %#v state", ConsensusStateIdle))
+		if uint(len(k.slots)) >= k.pipelineDepth {
+			k.traceF(t.Logf("follower pipeline is full with %d slots", len(k.slots)))
 			return false
 		}
+		round = k.nextRound()
 	}
 
-	propose, exists := k.proposes[k.round][k.epoch][k.leader()]
+	propose, exists := k.proposes[round][k.epoch][k.leader()]
 
 	if !exists {
-		k.traceF(t.Logf("propose not found"))
+		k.traceF(t.Logf("propose for %#v not found", round))
+		return false
+	}
+
+	if !k.checkBatch(t, propose.Jobs, round) {
+		k.traceF(t.Logf("cannot write invalid %#v", propose))
+		return false
+	}
+
+	for _, job := range propose.Jobs {
+		if err := k.validateF(job.Request.Payload); err != nil {
+			k.traceF(t.Logf("cannot write %#v rejected by application: %s", job, err))
+			return false
+		}
+	}
+
+	proposed := newSlot(round, ConsensusStateProposeWrite, propose.Jobs)
+
+	if carried, found := k.carried[round]; found && preparedHash(carried) != proposed.hash {
+		k.traceF(t.Logf("cannot write %#v as it overrides %#v", propose, carried))
+		return false
+	}
+
+	if !k.castVote(t, KVote{Kind: VoteWrite, Round: round, Epoch: k.epoch, Hash: proposed.hash}) {
+		k.traceF(t.Logf("cannot vote for %#v", propose))
 		return false
 	}
 
 	k.traceF(t.Logf("gogo, pick %#v", propose))
 
 	// TODO: also add into k.jobs?
-	k.currentJob = propose.Job
-	k.currentBuzz = hash(propose.Job.Request)
+	if target != nil {
+		*target = *proposed
+	} else {
+		k.slots = append(k.slots, proposed)
+	}
 
-	write := KWrite{Round: k.round, Epoch: k.epoch, Hash: k.currentBuzz}
+	write := KWrite{Round: round, Epoch: k.epoch, Hash: proposed.hash}
 
 	for _, key := range k.keys {
 		k.sendF(key, write)
 	}
 
-	k.traceF(t.Logf("modify consensus state from %#v to %#v", k.consensusState, ConsensusStateProposeWrite))
-	k.consensusState = ConsensusStateProposeWrite
+	k.traceF(t.Logf("slot at %#v moved to %#v state", round, ConsensusStateProposeWrite))
 	return true
 }
 
 func (k *Kayak) maybeAccept(t Tracer) bool {
 	t = t.Fork("maybeAccept")
 
-	if k.consensusState != ConsensusStateProposeWrite {
-		k.traceF(t.Logf("not in expected %#v state", ConsensusStateProposeWrite))
+	var target *slot
+	for _, s := range k.slots {
+		if s.state == ConsensusStateProposeWrite {
+			target = s
+			break
+		}
+	}
+
+	if target == nil {
+		k.traceF(t.Logf("no slot in expected %#v state", ConsensusStateProposeWrite))
 		return false
 	}
 
-	if uint(len(k.writes[k.round][k.epoch][k.currentBuzz])) < k.q {
-		k.traceF(t.Logf("write quorum (%d/%d) not reached", uint(len(k.writes[k.round][k.epoch][k.currentBuzz])), k.q))
+	if k.signedVotes(k.writes[target.round][k.epoch][target.hash]) < k.q {
+		k.traceF(t.Logf("write quorum (%d/%d) at %#v not reached", k.signedVotes(k.writes[target.round][k.epoch][target.hash]), k.q, target.round))
 		return false
 	}
 
-	k.traceF(t.Logf("gogo, write quorum (%d/%d) reached", uint(len(k.writes[k.round][k.epoch][k.currentBuzz])), k.q))
+	if !k.castVote(t, KVote{Kind: VoteAccept, Round: target.round, Epoch: k.epoch, Hash: target.hash}) {
+		k.traceF(t.Logf("cannot vote for %#v", target.hash))
+		return false
+	}
+
+	k.traceF(t.Logf("gogo, write quorum (%d/%d) at %#v reached", k.signedVotes(k.writes[target.round][k.epoch][target.hash]), k.q, target.round))
+
+	prepared := KPrepared{Round: target.round, Epoch: k.epoch, Jobs: target.jobs}
+	if k.privateKey != nil {
+		prepared.Signers, prepared.Signatures = collectSignatures(k.keys, k.writes[target.round][k.epoch][target.hash])
+	}
+	k.prepared[target.round] = prepared
 
-	accept := KAccept{Round: k.round, Epoch: k.epoch, Hash: k.currentBuzz}
+	accept := KAccept{Round: target.round, Epoch: k.epoch, Hash: target.hash}
 	for _, key := range k.keys {
 		k.sendF(key, accept)
 	}
 
-	k.traceF(t.Logf("modify consensus state from %#v to %#v", k.consensusState, ConsensusStateWriteAccept))
-	k.consensusState = ConsensusStateWriteAccept
+	k.traceF(t.Logf("slot at %#v moved to %#v state", target.round, ConsensusStateWriteAccept))
+	target.state = ConsensusStateWriteAccept
 	return true
 }
 
 func (k *Kayak) maybeDecide(t Tracer) bool {
 	t = t.Fork("maybeDecide")
 
-	if k.consensusState != ConsensusStateWriteAccept {
-		k.traceF(t.Logf("not in expected %#v state", ConsensusStateWriteAccept))
+	if len(k.slots) == 0 {
+		k.traceF(t.Logf("no slots in flight"))
 		return false
 	}
 
-	if uint(len(k.accepts[k.round][k.epoch][k.currentBuzz])) < k.q {
-		k.traceF(t.Logf("accept quorum (%d/%d) not reached", uint(len(k.accepts[k.round][k.epoch][k.currentBuzz])), k.q))
+	// Decisions are applied strictly in order, so only the first slot
+	target := k.slots[0]
+
+	if target.state != ConsensusStateWriteAccept {
+		k.traceF(t.Logf("slot at %#v not in expected %#v state", target.round, ConsensusStateWriteAccept))
 		return false
 	}
-	k.traceF(t.Logf("gogo, accept quorum (%d/%d) reached", uint(len(k.accepts[k.round][k.epoch][k.currentBuzz])), k.q))
 
-	response := KResponse{
-		Index: k.round,
-		Nonce: k.currentJob.Request.Nonce,
+	if k.signedVotes(k.accepts[target.round][k.epoch][target.hash]) < k.q {
+		k.traceF(t.Logf("accept quorum (%d/%d) at %#v not reached", k.signedVotes(k.accepts[target.round][k.epoch][target.hash]), k.q, target.round))
+		return false
+	}
+	k.traceF(t.Logf("gogo, accept quorum (%d/%d) at %#v reached", k.signedVotes(k.accepts[target.round][k.epoch][target.hash]), k.q, target.round))
+
+	certificate := k.makeCertificate(t, target)
+
+	first := k.round
+
+	for i, job := range target.jobs {
+		response := KResponse{
+			Index: k.round,
+			Nonce: job.Request.Nonce,
+		}
+		k.sendF(job.From, response)
+
+		k.decide(t, job.Request.Payload, target.buzz[i], originOf(job.Request), certificate)
 	}
-	k.sendF(k.currentJob.From, response)
 
-	k.decide(t, k.currentJob.Request.Payload, k.currentBuzz)
+	k.pushToLearners(t, first)
 
 	return true
 }
 
-func (k *Kayak) decide(t Tracer, data KData, buzz KHash) {
+func (k *Kayak) decide(t Tracer, data KData, buzz KHash, origin KOrigin, certificate *KCertificate) {
 	t = t.Fork("decide")
 
 	k.traceF(t.Logf("with data %#v and buzz %#v", data, buzz))
 
-	k.storage.Append(data)
+	if storage, ok := k.storage.(KRecoverableStorage); ok {
+		storage.AppendEntry(data, origin)
+	} else {
+		k.storage.Append(data)
+	}
+
+	if storage, ok := k.storage.(KCertificateStorage); ok && k.isNewCertificate(certificate) {
+		storage.SaveCertificate(*certificate)
+	}
+
+	if job, found := k.jobs.get(buzz); found {
+		k.traceF(t.Logf("remove job as completed %#v", job))
+		k.jobs.remove(buzz)
+	} else {
+		k.traceF(t.Logf("no jobs associated with buzz %#v", buzz))
+	}
+
+	k.appendLog(t, data, buzz, origin, certificate)
+	k.dropStaleJobs(t)
+	k.pruneRounds(t)
+
+	k.trimPipeline(t)
+
+	k.rescheduleWhatsup(t)
+}
+
+// appendLog adds the entry to the in-memory log, advances the round and
+// executes reconfiguration commands. It is shared by decide and recoverLog.
+// The certificate of a batch is kept once, with the first entry it covers.
+func (k *Kayak) appendLog(t Tracer, data KData, buzz KHash, origin KOrigin, certificate *KCertificate) {
 	k.logData = append(k.logData, data)
+	k.logOrigins = append(k.logOrigins, origin)
+
+	if k.isNewCertificate(certificate) {
+		k.logCerts = append(k.logCerts, *certificate)
+	}
 
 	k.logBuzz = append(k.logBuzz, buzz)
-	k.setBuzz[buzz] = struct{}{}
+	k.setBuzz[buzz] = k.round
+
+	k.recordSession(t, origin)
 
 	k.logDataHash = append(k.logDataHash, cumDataHash(
 		k.logDataHash[len(k.logDataHash)-1],
@@ -315,20 +569,14 @@ func (k *Kayak) decide(t Tracer, data KData, buzz KHash) {
 
 	k.logBuzzHash = append(k.logBuzzHash, cumBuzzHash(
 		k.logBuzzHash[len(k.logBuzzHash)-1],
-		k.logBuzz[len(k.logData)-1],
+		k.logBuzz[len(k.logBuzz)-1],
 	))
 
-	if job, found := k.jobs[buzz]; found {
-		k.traceF(t.Logf("remove job as completed %#v", job))
-		delete(k.jobs, buzz)
-		k.updateEarliestJobTimestamp()
-	} else {
-		k.traceF(t.Logf("no jobs associated with buzz %#v", buzz))
-	}
-
 	k.traceF(t.Logf("increase round from %#v to %#v", k.round, k.round+1))
 	k.round++
 
+	k.expireBuzz(t)
+
 	if len(data) == len(MagicAddProcess)+AddressSize && bytes.Equal(data[:len(MagicAddProcess)], MagicAddProcess[:]) {
 		k.traceF(t.Logf("found add process command"))
 		var processKey KAddress
@@ -343,10 +591,35 @@ func (k *Kayak) decide(t Tracer, data KData, buzz KHash) {
 		k.removeProcess(t, processKey)
 	}
 
-	if k.consensusState != ConsensusStateIdle {
-		k.traceF(t.Logf("modify consensus state from %#v to %#v", k.consensusState, ConsensusStateIdle))
-		k.consensusState = ConsensusStateIdle
+	if len(data) == len(MagicSetWeight)+AddressSize+8 && bytes.Equal(data[:len(MagicSetWeight)], MagicSetWeight[:]) {
+		k.traceF(t.Logf("found set weight command"))
+		var processKey KAddress
+		copy(processKey[:], data[len(MagicSetWeight):])
+		weight := binary.BigEndian.Uint64(data[len(MagicSetWeight)+AddressSize:])
+		k.setWeight(t, processKey, uint(weight))
 	}
 
-	k.rescheduleWhatsup(t)
+	if len(data) == len(MagicAddLearner)+AddressSize && bytes.Equal(data[:len(MagicAddLearner)], MagicAddLearner[:]) {
+		k.traceF(t.Logf("found add learner command"))
+		var learnerKey KAddress
+		copy(learnerKey[:], data[len(MagicAddLearner):])
+		k.addLearner(t, learnerKey)
+	}
+
+	if len(data) == len(MagicRemoveLearner)+AddressSize && bytes.Equal(data[:len(MagicRemoveLearner)], MagicRemoveLearner[:]) {
+		k.traceF(t.Logf("found remove learner command"))
+		var learnerKey KAddress
+		copy(learnerKey[:], data[len(MagicRemoveLearner):])
+		k.removeLearner(t, learnerKey)
+	}
+
+	last := k.keysLog[len(k.keysLog)-1]
+	if !sameKeys(last.keys, k.keys) || !sameWeights(last.weights, k.weights) || !sameKeys(last.learners, k.learners) {
+		k.keysLog = append(k.keysLog, keysAt{
+			round:    k.round,
+			keys:     append([]KAddress(nil), k.keys...),
+			weights:  append([]uint(nil), k.weights...),
+			learners: append([]KAddress(nil), k.learners...),
+		})
+	}
 }
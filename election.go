@@ -0,0 +1,9 @@
+package kayak
+
+// RoundRobinLeaderPolicy elects the processes in turn. It is the default
+// policy.
+type RoundRobinLeaderPolicy struct{}
+
+func (RoundRobinLeaderPolicy) Leader(epoch KEpoch, keys []KAddress) KAddress {
+	return keys[uint(epoch)%uint(len(keys))]
+}
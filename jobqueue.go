@@ -0,0 +1,257 @@
+package kayak
+
+import (
+	"bytes"
+	"container/heap"
+)
+
+// jobQueue keeps the pending jobs ordered by timestamp, ties are broken by
+// buzz. The order is the same on every run, so the leader always proposes
+// the oldest jobs first and the runs are reproducible. The jobs are also
+// ordered by request index to drop the stale ones, and grouped by client.
+// The forwarded jobs are not grouped, as their client is only claimed.
+type jobQueue struct {
+	heap    jobHeap
+	byIndex indexHeap
+	index   map[KHash]*queuedJob
+	clients map[KAddress]map[KHash]*queuedJob
+}
+
+type queuedJob struct {
+	buzz     KHash
+	job      *KJob
+	pos      int
+	indexPos int
+}
+
+func newJobQueue() *jobQueue {
+	return &jobQueue{
+		index:   make(map[KHash]*queuedJob),
+		clients: make(map[KAddress]map[KHash]*queuedJob),
+	}
+}
+
+func (q *jobQueue) Len() int {
+	return len(q.heap)
+}
+
+func (q *jobQueue) get(buzz KHash) (*KJob, bool) {
+	item, found := q.index[buzz]
+	if !found {
+		return nil, false
+	}
+	return item.job, true
+}
+
+// add inserts the job, or replaces the job with the same buzz
+func (q *jobQueue) add(buzz KHash, job *KJob) {
+	if item, found := q.index[buzz]; found {
+		q.leaveClient(item)
+		item.job = job
+		q.joinClient(item)
+		heap.Fix(&q.heap, item.pos)
+		heap.Fix(&q.byIndex, item.indexPos)
+		return
+	}
+	item := &queuedJob{buzz: buzz, job: job}
+	q.index[buzz] = item
+	q.joinClient(item)
+	heap.Push(&q.heap, item)
+	heap.Push(&q.byIndex, item)
+}
+
+func (q *jobQueue) remove(buzz KHash) bool {
+	item, found := q.index[buzz]
+	if !found {
+		return false
+	}
+	heap.Remove(&q.heap, item.pos)
+	heap.Remove(&q.byIndex, item.indexPos)
+	delete(q.index, buzz)
+	q.leaveClient(item)
+	return true
+}
+
+func (q *jobQueue) joinClient(item *queuedJob) {
+	if item.job.Forwarded {
+		return
+	}
+	jobs, found := q.clients[item.job.From]
+	if !found {
+		jobs = make(map[KHash]*queuedJob)
+		q.clients[item.job.From] = jobs
+	}
+	jobs[item.buzz] = item
+}
+
+func (q *jobQueue) leaveClient(item *queuedJob) {
+	if item.job.Forwarded {
+		return
+	}
+	jobs := q.clients[item.job.From]
+	delete(jobs, item.buzz)
+	if len(jobs) == 0 {
+		delete(q.clients, item.job.From)
+	}
+}
+
+// clientLen returns the number of jobs the client sent
+func (q *jobQueue) clientLen(from KAddress) int {
+	return len(q.clients[from])
+}
+
+// clientJobs returns the jobs the client sent in no particular order
+func (q *jobQueue) clientJobs(from KAddress) map[KHash]*KJob {
+	jobs := make(map[KHash]*KJob, len(q.clients[from]))
+	for buzz, item := range q.clients[from] {
+		jobs[buzz] = item.job
+	}
+	return jobs
+}
+
+// lowestIndex returns the job with the lowest request index
+func (q *jobQueue) lowestIndex() (KHash, *KJob, bool) {
+	if len(q.byIndex) == 0 {
+		return KHash{}, nil, false
+	}
+	return q.byIndex[0].buzz, q.byIndex[0].job, true
+}
+
+// earliest returns the oldest job
+func (q *jobQueue) earliest() (*KJob, bool) {
+	if len(q.heap) == 0 {
+		return nil, false
+	}
+	return q.heap[0].job, true
+}
+
+// reschedule sets the timestamp of all jobs
+func (q *jobQueue) reschedule(timestamp KTime) {
+	for _, item := range q.heap {
+		item.job.Timestamp = timestamp
+	}
+	heap.Init(&q.heap)
+}
+
+// ascend calls f for the jobs from the oldest to the newest until f returns
+// false. Visiting m jobs takes O(m log m) regardless of the queue length.
+// The queue must not be modified by f.
+func (q *jobQueue) ascend(f func(buzz KHash, job *KJob) bool) {
+	if len(q.heap) == 0 {
+		return
+	}
+	frontier := &jobFrontier{heap: q.heap, pos: []int{0}}
+	for frontier.Len() > 0 {
+		pos := heap.Pop(frontier).(int)
+		if !f(q.heap[pos].buzz, q.heap[pos].job) {
+			return
+		}
+		for _, child := range []int{2*pos + 1, 2*pos + 2} {
+			if child < len(q.heap) {
+				heap.Push(frontier, child)
+			}
+		}
+	}
+}
+
+// jobHeap implements heap.Interface
+type jobHeap []*queuedJob
+
+func (h jobHeap) Len() int {
+	return len(h)
+}
+
+func (h jobHeap) Less(i, j int) bool {
+	return isJobBefore(h[i], h[j])
+}
+
+func (h jobHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].pos = i
+	h[j].pos = j
+}
+
+func (h *jobHeap) Push(x interface{}) {
+	item := x.(*queuedJob)
+	item.pos = len(*h)
+	*h = append(*h, item)
+}
+
+func (h *jobHeap) Pop() interface{} {
+	old := *h
+	item := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return item
+}
+
+// indexHeap orders the jobs by request index, ties are broken by buzz. It
+// implements heap.Interface
+type indexHeap []*queuedJob
+
+func (h indexHeap) Len() int {
+	return len(h)
+}
+
+func (h indexHeap) Less(i, j int) bool {
+	if h[i].job.Request.Index != h[j].job.Request.Index {
+		return h[i].job.Request.Index < h[j].job.Request.Index
+	}
+	return bytes.Compare(h[i].buzz[:], h[j].buzz[:]) < 0
+}
+
+func (h indexHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].indexPos = i
+	h[j].indexPos = j
+}
+
+func (h *indexHeap) Push(x interface{}) {
+	item := x.(*queuedJob)
+	item.indexPos = len(*h)
+	*h = append(*h, item)
+}
+
+func (h *indexHeap) Pop() interface{} {
+	old := *h
+	item := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return item
+}
+
+// jobFrontier holds the positions in jobHeap to visit next, it implements
+// heap.Interface
+type jobFrontier struct {
+	heap jobHeap
+	pos  []int
+}
+
+func (f *jobFrontier) Len() int {
+	return len(f.pos)
+}
+
+func (f *jobFrontier) Less(i, j int) bool {
+	return isJobBefore(f.heap[f.pos[i]], f.heap[f.pos[j]])
+}
+
+func (f *jobFrontier) Swap(i, j int) {
+	f.pos[i], f.pos[j] = f.pos[j], f.pos[i]
+}
+
+func (f *jobFrontier) Push(x interface{}) {
+	f.pos = append(f.pos, x.(int))
+}
+
+func (f *jobFrontier) Pop() interface{} {
+	pos := f.pos[len(f.pos)-1]
+	f.pos = f.pos[:len(f.pos)-1]
+	return pos
+}
+
+func isJobBefore(a, b *queuedJob) bool {
+	if a.job.Timestamp != b.job.Timestamp {
+		return a.job.Timestamp < b.job.Timestamp
+	}
+	return bytes.Compare(a.buzz[:], b.buzz[:]) < 0
+}
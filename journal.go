@@ -0,0 +1,76 @@
+package kayak
+
+// replayJournal restores the last votes and the epoch from the journal
+func (k *Kayak) replayJournal(t Tracer) {
+	t = t.Fork("replayJournal")
+
+	votes, err := k.journal.Load()
+	if err != nil {
+		k.errorF(t.Errorf("cannot load votes: %s", err))
+		return
+	}
+
+	k.traceF(t.Logf("journal contains %d votes", len(votes)))
+
+	for _, vote := range votes {
+		if last, found := k.lastVotes[vote.Kind]; found && !isVoteAfter(vote, last) {
+			continue
+		}
+		k.traceF(t.Logf("restore %#v", vote))
+		k.lastVotes[vote.Kind] = vote
+	}
+
+	for _, vote := range k.lastVotes {
+		epoch := vote.Epoch
+		if vote.Kind == VoteSuspect {
+			// The suspect was sent for the next epoch
+			epoch--
+		}
+		if epoch > k.epoch {
+			k.traceF(t.Logf("advancing epoch %#v >> %#v", k.epoch, epoch))
+			k.epoch = epoch
+		}
+	}
+}
+
+// castVote checks the vote against the last vote of the same kind and
+// records it in the journal. The vote must not be sent unless castVote
+// returns true.
+func (k *Kayak) castVote(t Tracer, vote KVote) bool {
+	t = t.Fork("castVote")
+
+	if last, found := k.lastVotes[vote.Kind]; found {
+		if vote.Round == last.Round && vote.Epoch == last.Epoch {
+			if vote.Hash != last.Hash {
+				k.traceF(t.Logf("refused as conflicts with %#v", last))
+				return false
+			}
+			k.traceF(t.Logf("already recorded"))
+			return true
+		}
+		if !isVoteAfter(vote, last) {
+			k.traceF(t.Logf("refused as behind %#v", last))
+			return false
+		}
+	}
+
+	if k.journal != nil {
+		if err := k.journal.Record(vote); err != nil {
+			k.errorF(t.Errorf("cannot record %#v: %s", vote, err))
+			return false
+		}
+	}
+
+	k.lastVotes[vote.Kind] = vote
+	k.traceF(t.Logf("recorded %#v", vote))
+
+	return true
+}
+
+// isVoteAfter orders votes by epoch, then by round
+func isVoteAfter(vote, last KVote) bool {
+	if vote.Epoch != last.Epoch {
+		return vote.Epoch > last.Epoch
+	}
+	return vote.Round > last.Round
+}
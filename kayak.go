@@ -1,6 +1,8 @@
 package kayak
 
 import (
+	"bytes"
+	"crypto/ed25519"
 	"errors"
 	"fmt"
 	"log"
@@ -10,53 +12,110 @@ import (
 type Kayak struct {
 	sync.Mutex
 
-	key   KAddress
-	keys  []KAddress
-	rkeys map[KAddress]int
+	key     KAddress
+	keys    []KAddress
+	rkeys   map[KAddress]int
+	weights []uint
 
-	n, f, q uint
-	round   KRound
-	epoch   KEpoch
+	// learners receive the decided entries, they are not counted in n, f
+	// and q
+	learners []KAddress
 
-	time                 KTime
-	timeout              KTime
-	whatsupT             KTime
-	callT                KTime
-	bonjourT             KTime
-	nextWhatsup          KTime
-	earliestJobTimestamp KTime
+	n, f, q    uint
+	faultModel KFaultModel
+	round      KRound
+	epoch      KEpoch
 
-	storage KStorage
+	time        KTime
+	timeout     KTime
+	whatsupT    KTime
+	callT       KTime
+	bonjourT    KTime
+	nextWhatsup KTime
 
-	logData     []KData
-	logDataHash []KHash
-	logBuzz     []KHash
-	logBuzzHash []KHash
-	setBuzz     map[KHash]struct{}
+	storage KStorage
+	journal KVoteJournal
+
+	// logOrigins follow logData, logCerts hold the known certificates of the
+	// batches which are not entirely before logDataOffset, by round
+	logData       []KData
+	logOrigins    []KOrigin
+	logCerts      []KCertificate
+	logDataHash   []KHash
+	logDataOffset KRound
+	logBuzz       []KHash
+	logBuzzHash   []KHash
+	logBuzzOffset KRound
+	setBuzz       map[KHash]KRound
+	sessions      *sessionTable
+	maxSessions   uint
+	keysLog       []keysAt
+
+	snapshot           *KSnapshot
+	snapshots          map[KRound]map[KHash]KSnapshot
+	snapshotEnsureSent map[KRound]bool
+	snapshotConfirms   map[KRound]map[KHash]map[KAddress]struct{}
 
 	proposes map[KRound]map[KEpoch]map[KAddress]KPropose
-	writes   map[KRound]map[KEpoch]map[KHash]map[KAddress]struct{}
-	accepts  map[KRound]map[KEpoch]map[KHash]map[KAddress]struct{}
+	writes   map[KRound]map[KEpoch]map[KHash]map[KAddress][]byte
+	accepts  map[KRound]map[KEpoch]map[KHash]map[KAddress][]byte
 	suspects map[KEpoch]map[KAddress]KSuspect
-	heads    map[KRound]map[KEpoch]map[KAddress]struct{}
-	syncSent map[KRound]bool
-	syncData map[KRound]map[KHash][]KData
-	syncBuzz map[KRound]map[KHash][]KHash
-	confirms map[KRound]map[KHash]map[KHash]map[KAddress]struct{}
-
-	jobs        map[KHash]*KJob
-	currentJob  KJob
-	currentBuzz KHash
-
-	extSendF   func(to KAddress, payload interface{})
-	extReturnF func(payload interface{})
-	extTraceF  func(payload interface{})
-	extErrorF  func(error)
+	// suspectSignatures are kept to prove the new view to the followers
+	suspectSignatures map[KEpoch]map[KAddress][]byte
+	heads             map[KRound]map[KEpoch]map[KAddress]struct{}
+	syncSent          map[KRound]bool
+	syncData          map[KRound]map[KHash][]KData
+	syncBuzz          map[KRound]map[KHash][]KHash
+	// syncOrigins are keyed as syncBuzz, syncCerts by the round of the batch
+	// and the sender
+	syncOrigins map[KRound]map[KHash][]KOrigin
+	syncCerts   map[KRound]map[KAddress]KCertificate
+	confirms    map[KRound]map[KHash]map[KHash]map[KAddress]struct{}
+
+	// prepared holds the batches accepted at each round, carried holds the
+	// batches to propose again in viewEpoch. view is the latest new view
+	// known, viewRetryAt is when to ask the leader for it again.
+	prepared    map[KRound]KPrepared
+	carried     map[KRound]KPrepared
+	view        *KNewView
+	viewEpoch   KEpoch
+	viewRetryAt KTime
+
+	lastVotes map[KVoteKind]KVote
+
+	jobs          *jobQueue
+	slots         []*slot
+	pipelineDepth uint
+
+	leaderPolicy KLeaderPolicy
+
+	batchSize    uint
+	batchBytes   uint
+	batchT       KTime
+	batchSince   KTime
+	batchPending bool
+
+	extSendF     func(to KAddress, payload interface{})
+	extReturnF   func(payload interface{})
+	extTraceF    func(payload interface{})
+	extErrorF    func(error)
+	extInstallF  func(index KIndex, state []byte)
+	extValidateF func(KData) error
+
+	privateKey ed25519.PrivateKey
 
 	localClient *Client
 
-	consensusState KConsensusState
-	lcState        KLCState
+	lcState KLCState
+
+	// transferring is set on the leader which hands the leadership over at
+	// the end of transferEpoch for the call transferTag, transfer is the
+	// handover to follow
+	transferring  bool
+	transferEpoch KEpoch
+	transferTag   int
+	transfer      *KTransfer
+	transferProof KSigned
 
 	mostRecentRoundKnown  KRound
 	mostRecentEpochKnown  KEpoch
@@ -64,7 +123,22 @@ type Kayak struct {
 	mostRecentHashToSync  KHash
 	mostRecentBuzzToSync  KHash
 
-	allowExternal bool
+	certifiedSync bool
+	syncAttempt   uint
+	syncRetryAt   KTime
+
+	allowExternal   bool
+	forwardRequests bool
+
+	// admission control, zero disables a limit. A client gains a token every
+	// rateT ticks up to burst tokens, and spends one per request.
+	maxJobs       uint
+	maxClientJobs uint
+	maxPayload    uint
+	maxRead       uint
+	rateT         KTime
+	burst         uint
+	buckets       map[KAddress]*bucket
 
 	indexTolerance KRound
 
@@ -82,65 +156,181 @@ func NewKayak(c *KServerConfig) *Kayak {
 		rkeys[key] = i
 	}
 
-	jobs := make(map[KHash]*KJob)
+	// Each key weighs 1 if the weights are invalid, the error is reported
+	// once the errors can be
+	weights, weightsErr := makeWeights(keys, c.Weights)
+	if weightsErr != nil {
+		weights, _ = makeWeights(keys, nil)
+	}
+
+	learners := append([]KAddress(nil), c.Learners...)
+
+	jobs := newJobQueue()
 	proposes := make(map[KRound]map[KEpoch]map[KAddress]KPropose)
-	writes := make(map[KRound]map[KEpoch]map[KHash]map[KAddress]struct{})
-	accepts := make(map[KRound]map[KEpoch]map[KHash]map[KAddress]struct{})
+	writes := make(map[KRound]map[KEpoch]map[KHash]map[KAddress][]byte)
+	accepts := make(map[KRound]map[KEpoch]map[KHash]map[KAddress][]byte)
 	suspects := make(map[KEpoch]map[KAddress]KSuspect)
+	suspectSignatures := make(map[KEpoch]map[KAddress][]byte)
 	heads := make(map[KRound]map[KEpoch]map[KAddress]struct{})
 	syncSent := make(map[KRound]bool)
 	syncData := make(map[KRound]map[KHash][]KData)
 	syncBuzz := make(map[KRound]map[KHash][]KHash)
+	syncOrigins := make(map[KRound]map[KHash][]KOrigin)
+	syncCerts := make(map[KRound]map[KAddress]KCertificate)
 	confirms := make(map[KRound]map[KHash]map[KHash]map[KAddress]struct{})
+	prepared := make(map[KRound]KPrepared)
+	carried := make(map[KRound]KPrepared)
+	snapshots := make(map[KRound]map[KHash]KSnapshot)
+	snapshotEnsureSent := make(map[KRound]bool)
+	snapshotConfirms := make(map[KRound]map[KHash]map[KAddress]struct{})
+
+	setBuzz := make(map[KHash]KRound)
 
-	setBuzz := make(map[KHash]struct{})
+	lastVotes := make(map[KVoteKind]KVote)
 
 	localClient := NewClient(&KClientConfig{
-		Key:        c.Key,
-		ServerKeys: keys,
-		CallT:      c.CallT,
-		BonjourT:   c.BonjourT,
-		SendF:      c.SendF,
-		ReturnF:    c.ReturnF,
-		TraceF:     c.TraceF,
-		ErrorF:     c.ErrorF,
+		Key:           c.Key,
+		ServerKeys:    keys,
+		ServerWeights: weights,
+		FaultModel:    c.FaultModel,
+		CallT:         c.CallT,
+		BonjourT:      c.BonjourT,
+		SendF:         c.SendF,
+		ReturnF:       c.ReturnF,
+		TraceF:        c.TraceF,
+		ErrorF:        c.ErrorF,
 	})
 
+	batchSize := c.BatchSize
+	if batchSize == 0 {
+		batchSize = 1
+	}
+	if batchSize > MaxBatchSize {
+		batchSize = MaxBatchSize
+	}
+
+	batchBytes := c.BatchBytes
+	if batchBytes == 0 || batchBytes > MaxBatchBytes {
+		batchBytes = MaxBatchBytes
+	}
+
+	pipelineDepth := c.PipelineDepth
+	if pipelineDepth == 0 {
+		pipelineDepth = 1
+	}
+
+	maxSessions := c.MaxSessions
+	if maxSessions == 0 {
+		maxSessions = DefaultMaxSessions
+	}
+
+	maxRead := c.MaxRead
+	if maxRead == 0 {
+		maxRead = DefaultMaxRead
+	}
+
+	burst := c.ClientBurst
+	if burst == 0 {
+		burst = 1
+	}
+
+	leaderPolicy := c.LeaderPolicy
+	if leaderPolicy == nil {
+		leaderPolicy = RoundRobinLeaderPolicy{}
+	}
+
 	k := Kayak{
-		key:            c.Key,
-		keys:           keys,
-		rkeys:          rkeys,
-		timeout:        KTime(c.RequestT),
-		whatsupT:       KTime(c.WhatsupT),
-		callT:          KTime(c.CallT),
-		bonjourT:       KTime(c.BonjourT),
-		storage:        c.Storage,
-		localClient:    localClient,
-		jobs:           jobs,
-		proposes:       proposes,
-		writes:         writes,
-		accepts:        accepts,
-		suspects:       suspects,
-		heads:          heads,
-		syncSent:       syncSent,
-		syncData:       syncData,
-		syncBuzz:       syncBuzz,
-		confirms:       confirms,
-		setBuzz:        setBuzz,
-		logDataHash:    []KHash{KHash{}},
-		logBuzzHash:    []KHash{KHash{}},
-		indexTolerance: KRound(c.IndexTolerance),
-		allowExternal:  c.AllowExternal,
-		extSendF:       c.SendF,
-		extReturnF:     c.ReturnF,
-		extTraceF:      c.TraceF,
-		extErrorF:      c.ErrorF,
+		key:                c.Key,
+		keys:               keys,
+		rkeys:              rkeys,
+		timeout:            KTime(c.RequestT),
+		whatsupT:           KTime(c.WhatsupT),
+		callT:              KTime(c.CallT),
+		bonjourT:           KTime(c.BonjourT),
+		storage:            c.Storage,
+		journal:            c.Journal,
+		localClient:        localClient,
+		jobs:               jobs,
+		proposes:           proposes,
+		writes:             writes,
+		accepts:            accepts,
+		suspects:           suspects,
+		suspectSignatures:  suspectSignatures,
+		heads:              heads,
+		syncSent:           syncSent,
+		syncData:           syncData,
+		syncBuzz:           syncBuzz,
+		syncOrigins:        syncOrigins,
+		syncCerts:          syncCerts,
+		confirms:           confirms,
+		prepared:           prepared,
+		carried:            carried,
+		snapshots:          snapshots,
+		snapshotEnsureSent: snapshotEnsureSent,
+		snapshotConfirms:   snapshotConfirms,
+		setBuzz:            setBuzz,
+		sessions:           newSessionTable(maxSessions, nil),
+		maxSessions:        maxSessions,
+		weights:            weights,
+		learners:           learners,
+		faultModel:         c.FaultModel,
+		keysLog:            []keysAt{{round: 0, keys: append([]KAddress(nil), keys...), weights: append([]uint(nil), weights...), learners: append([]KAddress(nil), learners...)}},
+		lastVotes:          lastVotes,
+		logDataHash:        []KHash{KHash{}},
+		logBuzzHash:        []KHash{KHash{}},
+		indexTolerance:     KRound(c.IndexTolerance),
+		batchSize:          batchSize,
+		batchBytes:         batchBytes,
+		batchT:             KTime(c.BatchT),
+		pipelineDepth:      pipelineDepth,
+		leaderPolicy:       leaderPolicy,
+		allowExternal:      c.AllowExternal,
+		forwardRequests:    c.ForwardRequests,
+		maxJobs:            c.MaxJobs,
+		maxClientJobs:      c.MaxClientJobs,
+		maxPayload:         c.MaxPayload,
+		maxRead:            maxRead,
+		rateT:              KTime(c.ClientRateT),
+		burst:              burst,
+		buckets:            make(map[KAddress]*bucket),
+		extSendF:           c.SendF,
+		extReturnF:         c.ReturnF,
+		extTraceF:          c.TraceF,
+		extErrorF:          c.ErrorF,
+		extInstallF:        c.InstallF,
+		extValidateF:       c.ValidateF,
+		privateKey:         c.PrivateKey,
+		certifiedSync:      c.CertifiedSync,
 
 		byzantineFlags: c.ByzantineFlags,
 	}
 
 	k.updateFactors()
 
+	if weightsErr != nil {
+		k.errorF(weightsErr)
+	}
+
+	if err := checkLearners(keys, learners); err != nil {
+		k.errorF(err)
+	}
+
+	if k.privateKey != nil && !bytes.Equal(k.privateKey.Public().(ed25519.PublicKey), k.key[:]) {
+		k.errorF(errors.New("private key does not match the key"))
+	}
+
+	if k.certifiedSync && k.privateKey == nil {
+		k.errorF(errors.New("certified sync requires a private key"))
+	}
+
+	if k.storage != nil {
+		k.recoverLog(NewTracer("               "))
+	}
+
+	if k.journal != nil {
+		k.replayJournal(NewTracer("               "))
+	}
+
 	return &k
 }
 
@@ -159,7 +349,15 @@ func (k *Kayak) ReceiveCall(call interface{}) {
 	defer k.Unlock()
 
 	k.traceF(fmt.Sprintf("<--------------: CALL %#v", call))
-	k.localClient.ReceiveCall(call)
+
+	switch call := call.(type) {
+	case KTransferCall:
+		t := NewTracer("               ")
+		k.receiveTransferCall(t, call)
+		k.proceed(t)
+	default:
+		k.localClient.ReceiveCall(call)
+	}
 }
 
 // ReceiveNet implements Process.ReceiveNet
@@ -171,17 +369,33 @@ func (k *Kayak) ReceiveNet(from KAddress, payload interface{}) {
 
 	t := NewTracer("               ")
 
+	payload, signature, err := unwrapSigned(from, payload, k.privateKey != nil)
+	if err != nil {
+		k.traceF(t.Logf("rejected as %s", err))
+		return
+	}
+
 	switch msg := payload.(type) {
 	case KRequest:
 		k.receiveRequest(t, from, msg)
+	case KLoad:
+		k.receiveLoad(t, from, msg)
+	case KRead:
+		k.receiveRead(t, from, msg)
 	case KPropose:
 		k.receivePropose(t, from, msg)
 	case KWrite:
-		k.receiveWrite(t, from, msg)
+		k.receiveWrite(t, from, msg, signature)
 	case KAccept:
-		k.receiveAccept(t, from, msg)
+		k.receiveAccept(t, from, msg, signature)
 	case KSuspect:
-		k.receiveSuspect(t, from, msg)
+		k.receiveSuspect(t, from, msg, signature)
+	case KNewView:
+		k.receiveNewView(t, from, msg)
+	case KNeedView:
+		k.receiveNeedView(t, from, msg)
+	case KTransfer:
+		k.receiveTransfer(t, from, msg, signature)
 	case KWhatsup:
 		k.receiveWhatsup(t, from)
 	case KBonjour:
@@ -196,7 +410,13 @@ func (k *Kayak) ReceiveNet(from KAddress, payload interface{}) {
 		k.receiveChunk(t, from, msg)
 	case KConfirm:
 		k.receiveConfirm(t, from, msg)
-	case KResponse, KTip:
+	case KSnapshot:
+		k.receiveSnapshot(t, from, msg)
+	case KEnsureSnapshot:
+		k.receiveEnsureSnapshot(t, from, msg)
+	case KConfirmSnapshot:
+		k.receiveConfirmSnapshot(t, from, msg)
+	case KResponse, KTip, KEntries:
 		k.localClient.ReceiveNet(from, payload)
 	default:
 		k.errorF(t.Errorf("unknown message %#v", payload))
@@ -217,6 +437,8 @@ func (k *Kayak) Tick(_tick uint) {
 	k.time += tick
 	k.traceF(t.Logf("increased time from %#v to %#v", k.time-tick, k.time))
 
+	k.pruneBuckets(t)
+
 	k.proceed(t)
 
 	k.localClient.Tick(_tick)
@@ -234,6 +456,31 @@ func (k *Kayak) Status() *KStatus {
 	}
 }
 
+// Footprint reports the sizes of the internal collections, which is useful
+// to monitor memory usage of a long-running process
+func (k *Kayak) Footprint() *KFootprint {
+	k.Lock()
+	defer k.Unlock()
+
+	return &KFootprint{
+		LogData:          len(k.logData),
+		LogBuzz:          len(k.logBuzz),
+		SetBuzz:          len(k.setBuzz),
+		Jobs:             k.jobs.Len(),
+		Proposes:         len(k.proposes),
+		Writes:           len(k.writes),
+		Accepts:          len(k.accepts),
+		Suspects:         len(k.suspects),
+		Heads:            len(k.heads),
+		SyncSent:         len(k.syncSent),
+		SyncData:         len(k.syncData),
+		SyncBuzz:         len(k.syncBuzz),
+		Confirms:         len(k.confirms),
+		Snapshots:        len(k.snapshots),
+		SnapshotConfirms: len(k.snapshotConfirms),
+	}
+}
+
 func (k *Kayak) proceed(t Tracer) {
 	const maxIterations = 1000
 	var i int
@@ -253,9 +500,13 @@ func (k *Kayak) proceed(t Tracer) {
 
 func (k *Kayak) tryProceed(t Tracer) bool {
 
-	k.traceF(t.Logf("consensus state : %#v", k.consensusState))
+	k.traceF(t.Logf("rounds in flight : %d", len(k.slots)))
 	k.traceF(t.Logf("leader change state : %#v", k.lcState))
 
+	if _, voter := k.rkeys[k.key]; !voter {
+		return k.tryLearn(t)
+	}
+
 	var progressMade bool
 
 	progressMade = progressMade || k.maybeWhatsup(t)
@@ -265,8 +516,13 @@ func (k *Kayak) tryProceed(t Tracer) bool {
 	progressMade = progressMade || k.maybeDecide(t)
 	progressMade = progressMade || k.maybeSuspect(t)
 	progressMade = progressMade || k.maybeLeaderChange(t)
+	progressMade = progressMade || k.maybeCatchUpEpoch(t)
+	progressMade = progressMade || k.maybeNewView(t)
+	progressMade = progressMade || k.maybeTransfer(t)
+	progressMade = progressMade || k.maybeFollowTransfer(t)
 	progressMade = progressMade || k.maybeSync(t)
 	progressMade = progressMade || k.maybeUpdate(t)
+	progressMade = progressMade || k.maybeInstallSnapshot(t)
 
 	return progressMade
 }
@@ -276,38 +532,21 @@ func (k *Kayak) getSomeoneElseKey() KAddress {
 }
 
 func (k *Kayak) leader() KAddress {
-	return k.keys[uint(k.epoch)%k.n]
-}
-
-func (k *Kayak) updateEarliestJobTimestamp() {
-	firstIteration := true
-	for buzz := range k.jobs {
-		if firstIteration {
-			k.earliestJobTimestamp = k.jobs[buzz].Timestamp
-			firstIteration = false
-			continue
-		}
-		if k.jobs[buzz].Timestamp < k.earliestJobTimestamp {
-			k.earliestJobTimestamp = k.jobs[buzz].Timestamp
-		}
-	}
+	return k.leaderPolicy.Leader(k.epoch, k.keys)
 }
 
+// updateFactors computes the faulty and the quorum weights, n is the number
+// of processes
 func (k *Kayak) updateFactors() {
-	k.f = uint(len(k.keys)-1) / 3
 	k.n = uint(len(k.keys))
-	k.q = (k.n+k.f)/2 + 1
-
-	// Special case for 2-process system
-	// TODO: test 2-process configuration for consensus stability
-	if k.n == 2 {
-		k.q = 1
-	}
-
+	k.f, k.q = quorumFactors(k.weights, k.faultModel)
 }
 
 func (k *Kayak) sendF(to KAddress, payload interface{}) {
 	k.traceF(fmt.Sprintf(">------> %#v: SEND %#v", to, payload))
+	if k.privateKey != nil && isSignedMessage(payload) {
+		payload = sign(k.privateKey, payload)
+	}
 	if k.extSendF != nil {
 		k.extSendF(to, payload)
 	} else {
@@ -324,6 +563,22 @@ func (k *Kayak) returnF(payload interface{}) {
 	}
 }
 
+func (k *Kayak) installF(index KIndex, state []byte) {
+	k.traceF(fmt.Sprintf("+++++++++++++++: INSTALL snapshot at %#v", index))
+	if k.extInstallF != nil {
+		k.extInstallF(index, state)
+	} else {
+		k.errorF(errors.New("install function not defined"))
+	}
+}
+
+func (k *Kayak) validateF(data KData) error {
+	if k.extValidateF != nil {
+		return k.extValidateF(data)
+	}
+	return nil
+}
+
 func (k *Kayak) traceF(payload interface{}) {
 	if k.extTraceF != nil {
 		k.extTraceF(payload)
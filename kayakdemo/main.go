@@ -48,18 +48,32 @@ func init() {
 	gob.Register(kayak.KJob{})
 	gob.Register(kayak.KTicket{})
 	gob.Register(kayak.KResponse{})
+	gob.Register(kayak.KReadCall{})
+	gob.Register(kayak.KReadReturn{})
+	gob.Register(kayak.KTransferCall{})
+	gob.Register(kayak.KTransferReturn{})
+	gob.Register(kayak.KReadTicket{})
+	gob.Register(kayak.KRead{})
+	gob.Register(kayak.KEntries{})
 	gob.Register(kayak.KBonjour{})
 	gob.Register(kayak.KWhatsup{})
 	gob.Register(kayak.KPropose{})
 	gob.Register(kayak.KWrite{})
 	gob.Register(kayak.KAccept{})
 	gob.Register(kayak.KSuspect{})
+	gob.Register(kayak.KTransfer{})
+	gob.Register(kayak.KNewView{})
+	gob.Register(kayak.KNeedView{})
 	gob.Register(kayak.KHead{})
 	gob.Register(kayak.KTip{})
 	gob.Register(kayak.KNeed{})
 	gob.Register(kayak.KEnsure{})
 	gob.Register(kayak.KChunk{})
 	gob.Register(kayak.KConfirm{})
+	gob.Register(kayak.KSnapshot{})
+	gob.Register(kayak.KEnsureSnapshot{})
+	gob.Register(kayak.KConfirmSnapshot{})
+	gob.Register(kayak.KSigned{})
 	gob.Register(kayak.KStatus{})
 
 	flag.IntVar(&fMe, "me", 0, "tcp port to use by the process")
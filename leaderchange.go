@@ -1,6 +1,18 @@
 package kayak
 
-func (k *Kayak) receiveSuspect(t Tracer, from KAddress, suspect KSuspect) {
+// suspectLoads returns the loads of the suspect up to the limit of pending
+// jobs. The suspect is kept whole, as its signature proves the new view, but
+// the loads over the limit are ignored rather than the vote: the servers may
+// be configured with different limits.
+func (k *Kayak) suspectLoads(t Tracer, suspect KSuspect) []KLoad {
+	if k.maxJobs > 0 && uint(len(suspect.Loads)) > k.maxJobs {
+		k.traceF(t.Logf("truncate %d loads to the limit %d", len(suspect.Loads), k.maxJobs))
+		return suspect.Loads[:k.maxJobs]
+	}
+	return suspect.Loads
+}
+
+func (k *Kayak) receiveSuspect(t Tracer, from KAddress, suspect KSuspect, signature []byte) {
 	t = t.Fork("receiveSuspect")
 
 	if _, fromServer := k.rkeys[from]; !fromServer {
@@ -8,8 +20,8 @@ func (k *Kayak) receiveSuspect(t Tracer, from KAddress, suspect KSuspect) {
 		return
 	}
 
-	if suspect.Epoch != k.epoch+1 {
-		k.traceF(t.Logf("rejected as with incorrect epoch"))
+	if suspect.Epoch <= k.epoch {
+		k.traceF(t.Logf("rejected as with outdated epoch"))
 		return
 	}
 
@@ -22,11 +34,20 @@ func (k *Kayak) receiveSuspect(t Tracer, from KAddress, suspect KSuspect) {
 		return
 	}
 
+	for _, prepared := range suspect.Prepared {
+		if !k.checkPrepared(t, prepared) {
+			k.traceF(t.Logf("rejected as with invalid prepared"))
+			return
+		}
+	}
+
+	loads := k.suspectLoads(t, suspect)
+
 	somethingNew := false
-	for lid := range suspect.Loads {
-		k.traceF(t.Logf("pick %#v", suspect.Loads[lid]))
-		buzz := hash(suspect.Loads[lid].Request)
-		if _, loadProcessed := k.setBuzz[buzz]; loadProcessed {
+	for lid := range loads {
+		k.traceF(t.Logf("pick %#v", loads[lid]))
+		buzz := hash(loads[lid].Request)
+		if k.isProcessed(loads[lid].Request, buzz, k.round) {
 			k.traceF(t.Logf("load already processed"))
 		} else {
 			k.traceF(t.Logf("load is new"))
@@ -35,15 +56,116 @@ func (k *Kayak) receiveSuspect(t Tracer, from KAddress, suspect KSuspect) {
 		}
 	}
 
+	for _, prepared := range suspect.Prepared {
+		if prepared.Round >= k.round {
+			k.traceF(t.Logf("prepared at %#v is new", prepared.Round))
+			somethingNew = true
+			break
+		}
+	}
+
 	if !somethingNew {
 		k.traceF(t.Logf("rejected as nothing new in the Suspect"))
 		return
 	}
 
+	// Only the suspect for the highest epoch is kept from the servers ahead,
+	// so that a server cannot fill the memory with suspects
+	if suspect.Epoch > k.epoch+1 {
+		for epoch := range k.suspects {
+			if epoch > k.epoch+1 && epoch < suspect.Epoch {
+				delete(k.suspects[epoch], from)
+				delete(k.suspectSignatures[epoch], from)
+			}
+		}
+	}
+
 	k.suspects[suspect.Epoch][from] = suspect
+	if signature != nil {
+		if _, ok := k.suspectSignatures[suspect.Epoch]; !ok {
+			k.suspectSignatures[suspect.Epoch] = make(map[KAddress][]byte)
+		}
+		k.suspectSignatures[suspect.Epoch][from] = signature
+	}
 	k.traceF(t.Logf("recorded"))
 }
 
+// maybeCatchUpEpoch jumps to a later epoch without new rounds to sync, once
+// f+1 servers report heads in the epoch or suspect the leader of the epoch,
+// so at least a correct server is in it. The leader changes in between are
+// skipped as for the epoch advanced by sync.
+func (k *Kayak) maybeCatchUpEpoch(t Tracer) bool {
+	t = t.Fork("maybeCatchUpEpoch")
+
+	epoch := k.epoch
+
+	if k.round >= k.mostRecentRoundKnown && k.mostRecentEpochKnown > epoch {
+		k.traceF(t.Logf("heads in epoch %#v", k.mostRecentEpochKnown))
+		epoch = k.mostRecentEpochKnown
+	}
+
+	for suspectEpoch := range k.suspects {
+		if suspectEpoch > k.epoch+1 && suspectEpoch-1 > epoch && k.suspectVotes(k.suspects[suspectEpoch]) >= k.f+1 {
+			k.traceF(t.Logf("suspects for epoch %#v", suspectEpoch))
+			epoch = suspectEpoch - 1
+		}
+	}
+
+	if epoch == k.epoch {
+		k.traceF(t.Logf("no later epoch known"))
+		return false
+	}
+
+	k.traceF(t.Logf("gogo"))
+
+	k.advanceEpoch(t, epoch)
+
+	// The leader sent the view before the jump, it is asked for at once
+	k.viewRetryAt = k.time
+
+	return true
+}
+
+// advanceEpoch moves to the epoch after a leader change, a handover or a
+// jump over epochs. The jobs wait for the new leader, and the rounds in
+// flight are dropped as they are proposed again by it. The batches to carry
+// are those of the suspect quorum of the epoch: the process neither proposes
+// nor writes until the view of the leader gives them. The leader of the
+// epoch announces the view if it holds the quorum.
+func (k *Kayak) advanceEpoch(t Tracer, epoch KEpoch) {
+	if k.epoch >= epoch {
+		k.traceF(t.Logf("no need to advance epoch"))
+		return
+	}
+
+	k.traceF(t.Logf("all local %d jobs need to be rescheduled", k.jobs.Len()))
+	k.jobs.reschedule(k.time + k.timeout)
+
+	k.traceF(t.Logf("old leader %#v", k.leader()))
+	k.traceF(t.Logf("advancing epoch %#v >> %#v", k.epoch, epoch))
+	k.epoch = epoch
+	k.carried = make(map[KRound]KPrepared)
+	k.viewRetryAt = k.time + k.whatsupT
+
+	if k.key == k.leader() {
+		k.traceF(t.Logf("I am leader now"))
+		if k.suspectVotes(k.suspects[epoch]) >= k.q {
+			k.announceView(t)
+		}
+	} else {
+		k.traceF(t.Logf("new leader %#v", k.leader()))
+	}
+
+	k.resetPipeline(t)
+
+	if k.lcState != LCStateIdle {
+		k.traceF(t.Logf("modify leader change state from %#v to %#v", k.lcState, LCStateIdle))
+		k.lcState = LCStateIdle
+	}
+
+	k.pruneEpochs(t)
+}
+
 func (k *Kayak) maybeSuspect(t Tracer) bool {
 	t = t.Fork("maybeSuspect")
 
@@ -52,8 +174,9 @@ func (k *Kayak) maybeSuspect(t Tracer) bool {
 		return false
 	}
 
-	hasTimeoutJobs := len(k.jobs) > 0 && k.earliestJobTimestamp+k.timeout <= k.time
-	enoughSuspectsToRunLC := uint(len(k.suspects[k.epoch+1])) >= k.f+1
+	earliestJob, hasJobs := k.jobs.earliest()
+	hasTimeoutJobs := hasJobs && earliestJob.Timestamp+k.timeout <= k.time
+	enoughSuspectsToRunLC := k.suspectVotes(k.suspects[k.epoch+1]) >= k.f+1
 
 	if hasTimeoutJobs {
 		k.traceF(t.Logf("due to local timeout"))
@@ -68,22 +191,29 @@ func (k *Kayak) maybeSuspect(t Tracer) bool {
 		return false
 	}
 
+	if !k.castVote(t, KVote{Kind: VoteSuspect, Round: k.round, Epoch: k.epoch + 1}) {
+		k.traceF(t.Logf("cannot suspect epoch %#v", k.epoch+1))
+		return false
+	}
+
 	k.traceF(t.Logf("gogo"))
 
 	var loads []KLoad
 
 	if hasTimeoutJobs {
 		k.traceF(t.Logf("creating loads from local jobs"))
-		for buzz := range k.jobs {
-			if k.jobs[buzz].Timestamp+k.timeout <= k.time {
-				load := KLoad{
-					From:    k.jobs[buzz].From,
-					Request: k.jobs[buzz].Request,
-				}
-				k.traceF(t.Logf("made %#v", load))
-				loads = append(loads, load)
+		k.jobs.ascend(func(_ KHash, job *KJob) bool {
+			if job.Timestamp+k.timeout > k.time {
+				return false
 			}
-		}
+			load := KLoad{
+				From:    job.From,
+				Request: job.Request,
+			}
+			k.traceF(t.Logf("made %#v", load))
+			loads = append(loads, load)
+			return true
+		})
 		k.traceF(t.Logf("created %d loads from local jobs", len(loads)))
 	}
 
@@ -94,11 +224,11 @@ func (k *Kayak) maybeSuspect(t Tracer) bool {
 
 		for _, suspect := range k.suspects[k.epoch+1] {
 			k.traceF(t.Logf("pick %#v", suspect))
-			for _, load := range suspect.Loads {
+			for _, load := range k.suspectLoads(t, suspect) {
 				k.traceF(t.Logf("pick %#v", load))
 
 				buzz := hash(load.Request)
-				if _, alreadyProcessed := k.setBuzz[buzz]; alreadyProcessed {
+				if k.isProcessed(load.Request, buzz, k.round) {
 					k.traceF(t.Logf("request has been already processed, skip"))
 					continue
 				}
@@ -114,6 +244,9 @@ func (k *Kayak) maybeSuspect(t Tracer) bool {
 	}
 
 	suspect := KSuspect{Epoch: k.epoch + 1, Loads: loads}
+	if k.privateKey != nil {
+		suspect.Prepared = k.listPrepared()
+	}
 	for _, key := range k.keys {
 		k.sendF(key, suspect)
 	}
@@ -133,60 +266,66 @@ func (k *Kayak) maybeLeaderChange(t Tracer) bool {
 		return false
 	}
 
-	if uint(len(k.suspects[k.epoch+1])) < k.q {
-		k.traceF(t.Logf("suspect quorum (%d/%d) not reached", uint(len(k.suspects[k.epoch+1])), k.q))
+	if k.suspectVotes(k.suspects[k.epoch+1]) < k.q {
+		k.traceF(t.Logf("suspect quorum (%d/%d) not reached", k.suspectVotes(k.suspects[k.epoch+1]), k.q))
 		return false
 	}
 
-	k.traceF(t.Logf("gogo, suspect quorum (%d/%d) reached", uint(len(k.suspects[k.epoch+1])), k.q))
-
-	k.traceF(t.Logf("all local %d jobs need to be rescheduled", len(k.jobs)))
-	for _, job := range k.jobs {
-		job.Timestamp = k.time + k.timeout
-	}
+	k.traceF(t.Logf("gogo, suspect quorum (%d/%d) reached", k.suspectVotes(k.suspects[k.epoch+1]), k.q))
 
 	k.traceF(t.Logf("have %d suspects for next epoch %#v", len(k.suspects[k.epoch+1]), k.epoch+1))
 	for _, suspect := range k.suspects[k.epoch+1] {
 		k.traceF(t.Logf("pick %#v", suspect))
-		for _, load := range suspect.Loads {
+		for _, load := range k.suspectLoads(t, suspect) {
 			k.traceF(t.Logf("pick %#v", load))
 
 			buzz := hash(load.Request)
 
-			if _, alreadyProcessed := k.setBuzz[buzz]; alreadyProcessed {
+			if k.isProcessed(load.Request, buzz, k.round) {
 				k.traceF(t.Logf("request has been already processed, skip"))
 				continue
 			}
 
+			if err := k.validateF(load.Request.Payload); err != nil {
+				k.traceF(t.Logf("request is invalid, skip: %s", err))
+				continue
+			}
+
+			// The client of a load is claimed by the suspect, the job is
+			// forwarded unless the client sent the request to this server
+			forwarded := true
+			if pending, found := k.jobs.get(buzz); found {
+				forwarded = pending.Forwarded
+			} else {
+				if load.Request.Sequence != 0 {
+					k.traceF(t.Logf("request in a session not received from the client, skip"))
+					continue
+				}
+				if k.maxPayload > 0 && uint(len(load.Request.Payload)) > k.maxPayload {
+					k.traceF(t.Logf("request is too large, skip"))
+					continue
+				}
+				if !k.checkPending(t, load.From, true) {
+					k.traceF(t.Logf("too many jobs pending, skip"))
+					continue
+				}
+			}
+
 			job := KJob{
 				From:      load.From,
 				Request:   load.Request,
 				Timestamp: k.time + k.timeout,
+				Forwarded: forwarded,
 			}
 
-			k.jobs[buzz] = &job
+			k.jobs.add(buzz, &job)
 			k.traceF(t.Logf("added"))
 		}
 	}
-	k.updateEarliestJobTimestamp()
-
-	k.traceF(t.Logf("now has %d jobs", len(k.jobs)))
-
-	k.traceF(t.Logf("old leader %#v", k.leader()))
-	k.traceF(t.Logf("increase epoch from %#v to %#v", k.epoch, k.epoch+1))
-	k.epoch++
-
-	if k.key == k.leader() {
-		k.traceF(t.Logf("I am leader now"))
-	} else {
-		k.traceF(t.Logf("new leader %#v", k.leader()))
-	}
 
-	k.traceF(t.Logf("modify consensus state from %#v to %#v", k.consensusState, ConsensusStateIdle))
-	k.consensusState = ConsensusStateIdle
+	k.traceF(t.Logf("now has %d jobs", k.jobs.Len()))
 
-	k.traceF(t.Logf("modify leader change state from %#v to %#v", k.lcState, LCStateIdle))
-	k.lcState = LCStateIdle
+	k.advanceEpoch(t, k.epoch+1)
 
 	return true
 }
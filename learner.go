@@ -0,0 +1,102 @@
+package kayak
+
+import (
+	"errors"
+	"fmt"
+)
+
+// checkLearners ensures that a learner is not a voter too
+func checkLearners(keys, learners []KAddress) error {
+	voters := make(map[KAddress]struct{})
+	for _, key := range keys {
+		voters[key] = struct{}{}
+	}
+	for _, learner := range learners {
+		if _, found := voters[learner]; found {
+			return errors.New(fmt.Sprintf("learner %#v is a voter too", learner))
+		}
+	}
+	return nil
+}
+
+func (k *Kayak) isLearner(key KAddress) bool {
+	for _, learner := range k.learners {
+		if learner == key {
+			return true
+		}
+	}
+	return false
+}
+
+func (k *Kayak) addLearner(t Tracer, learnerKey KAddress) {
+	t = t.Fork("addLearner")
+	k.traceF(t.Logf("%#v", learnerKey))
+
+	if _, exists := k.rkeys[learnerKey]; exists {
+		k.traceF(t.Logf("process is a voter, abort"))
+		return
+	}
+
+	if k.isLearner(learnerKey) {
+		k.traceF(t.Logf("learner already exists, abort"))
+		return
+	}
+
+	k.traceF(t.Logf("increasing learners size from %d to %d", len(k.learners), len(k.learners)+1))
+	k.learners = append(k.learners, learnerKey)
+}
+
+func (k *Kayak) removeLearner(t Tracer, learnerKey KAddress) {
+	t = t.Fork("removeLearner")
+	k.traceF(t.Logf("%#v", learnerKey))
+
+	for i, learner := range k.learners {
+		if learner == learnerKey {
+			k.traceF(t.Logf("decreasing learners size from %d to %d", len(k.learners), len(k.learners)-1))
+			k.learners = append(k.learners[:i:i], k.learners[i+1:]...)
+			return
+		}
+	}
+
+	k.traceF(t.Logf("learner does not exist, abort"))
+}
+
+// pushToLearners sends the entries decided from the round on to the
+// learners. A learner applies them once f+1 voters pushed the same ones, as
+// if it synced them.
+func (k *Kayak) pushToLearners(t Tracer, from KRound) {
+	t = t.Fork("pushToLearners")
+
+	if len(k.learners) == 0 || from >= k.round || from < k.logDataOffset {
+		k.traceF(t.Logf("nothing to push"))
+		return
+	}
+
+	chunk := KChunk{
+		Last:         k.round,
+		Data:         k.logData[from-k.logDataOffset : k.round-k.logDataOffset],
+		Buzz:         k.logBuzz[from-k.logBuzzOffset : k.round-k.logBuzzOffset],
+		Origins:      k.logOrigins[from-k.logDataOffset : k.round-k.logDataOffset],
+		Certificates: k.certificatesBetween(from, k.round),
+	}
+
+	k.traceF(t.Logf("gogo, %d entries to %d learners", len(chunk.Data), len(k.learners)))
+	for _, learner := range k.learners {
+		k.sendF(learner, chunk)
+	}
+}
+
+// tryLearn is the loop of a process which is not a voter: it never votes,
+// it only follows the decisions of the voters
+func (k *Kayak) tryLearn(t Tracer) bool {
+	t = t.Fork("learner")
+
+	var progressMade bool
+
+	progressMade = progressMade || k.maybeWhatsup(t)
+	progressMade = progressMade || k.maybeSync(t)
+	progressMade = progressMade || k.maybeUpdate(t)
+	progressMade = progressMade || k.maybeInstallSnapshot(t)
+
+	return progressMade
+}
@@ -0,0 +1,67 @@
+package kayak
+
+// slot is a round in flight. Slots are kept in the order of their rounds,
+// the first one starts at the current round and each next one starts right
+// after the entries of the previous one.
+type slot struct {
+	round KRound
+	state KConsensusState
+	jobs  []KJob
+	buzz  []KHash
+	hash  KHash
+}
+
+func newSlot(round KRound, state KConsensusState, jobs []KJob) *slot {
+	buzz := make([]KHash, len(jobs))
+	for i := range jobs {
+		buzz[i] = hash(jobs[i].Request)
+	}
+	return &slot{
+		round: round,
+		state: state,
+		jobs:  jobs,
+		buzz:  buzz,
+		hash:  cumBuzzHash(KHash{}, buzz...),
+	}
+}
+
+// nextRound returns the round following the last round in flight
+func (k *Kayak) nextRound() KRound {
+	if len(k.slots) == 0 {
+		return k.round
+	}
+	last := k.slots[len(k.slots)-1]
+	return last.round + KRound(len(last.jobs))
+}
+
+// inflightBuzz returns the buzz of the jobs in the slots before the round
+func (k *Kayak) inflightBuzz(round KRound) map[KHash]struct{} {
+	inflight := make(map[KHash]struct{})
+	for _, s := range k.slots {
+		if s.round >= round {
+			break
+		}
+		for _, buzz := range s.buzz {
+			inflight[buzz] = struct{}{}
+		}
+	}
+	return inflight
+}
+
+// trimPipeline drops the slots whose entries are all in the log
+func (k *Kayak) trimPipeline(t Tracer) {
+	for len(k.slots) > 0 && k.slots[0].round+KRound(len(k.slots[0].jobs)) <= k.round {
+		k.traceF(t.Logf("slot at %#v is complete", k.slots[0].round))
+		k.slots = k.slots[1:]
+	}
+}
+
+// resetPipeline drops all the rounds in flight. Their jobs stay in k.jobs
+// and are proposed again.
+func (k *Kayak) resetPipeline(t Tracer) {
+	if len(k.slots) > 0 {
+		k.traceF(t.Logf("drop %d slots in flight", len(k.slots)))
+		k.slots = nil
+	}
+	k.batchPending = false
+}
@@ -0,0 +1,151 @@
+package kayak
+
+// pruneRounds drops the protocol state of decided rounds and of synced ranges
+func (k *Kayak) pruneRounds(t Tracer) {
+	t = t.Fork("pruneRounds")
+
+	var pruned int
+
+	for round := range k.proposes {
+		if round < k.round {
+			delete(k.proposes, round)
+			pruned++
+		}
+	}
+	for round := range k.writes {
+		if round < k.round {
+			delete(k.writes, round)
+			pruned++
+		}
+	}
+	for round := range k.accepts {
+		if round < k.round {
+			delete(k.accepts, round)
+			pruned++
+		}
+	}
+	for round := range k.prepared {
+		if round < k.round {
+			delete(k.prepared, round)
+			pruned++
+		}
+	}
+	for round := range k.carried {
+		if round < k.round {
+			delete(k.carried, round)
+			pruned++
+		}
+	}
+	for round := range k.heads {
+		if round < k.mostRecentRoundKnown {
+			delete(k.heads, round)
+			pruned++
+		}
+	}
+	for round := range k.syncSent {
+		if round <= k.round {
+			delete(k.syncSent, round)
+			pruned++
+		}
+	}
+	for round := range k.syncData {
+		if round <= k.round {
+			delete(k.syncData, round)
+			pruned++
+		}
+	}
+	for round := range k.syncBuzz {
+		if round <= k.round {
+			delete(k.syncBuzz, round)
+			pruned++
+		}
+	}
+	for round := range k.syncOrigins {
+		if round <= k.round {
+			delete(k.syncOrigins, round)
+			pruned++
+		}
+	}
+	for round := range k.syncCerts {
+		if round < k.round {
+			delete(k.syncCerts, round)
+			pruned++
+		}
+	}
+	for round := range k.confirms {
+		if round <= k.round || round <= k.mostRecentRoundToSync {
+			delete(k.confirms, round)
+			pruned++
+		}
+	}
+	for round := range k.snapshots {
+		if round <= k.round {
+			delete(k.snapshots, round)
+			pruned++
+		}
+	}
+	for round := range k.snapshotEnsureSent {
+		if round <= k.round {
+			delete(k.snapshotEnsureSent, round)
+			pruned++
+		}
+	}
+	for round := range k.snapshotConfirms {
+		if round <= k.round {
+			delete(k.snapshotConfirms, round)
+			pruned++
+		}
+	}
+
+	if pruned > 0 {
+		k.traceF(t.Logf("pruned %d entries before %#v", pruned, k.round))
+	}
+}
+
+// pruneEpochs drops the protocol state of past epochs
+func (k *Kayak) pruneEpochs(t Tracer) {
+	t = t.Fork("pruneEpochs")
+
+	var pruned int
+
+	for epoch := range k.suspects {
+		if epoch <= k.epoch {
+			delete(k.suspects, epoch)
+			pruned++
+		}
+	}
+	for epoch := range k.suspectSignatures {
+		if epoch <= k.epoch {
+			delete(k.suspectSignatures, epoch)
+			pruned++
+		}
+	}
+	for round := range k.proposes {
+		for epoch := range k.proposes[round] {
+			if epoch < k.epoch {
+				delete(k.proposes[round], epoch)
+				pruned++
+			}
+		}
+	}
+	for round := range k.writes {
+		for epoch := range k.writes[round] {
+			if epoch < k.epoch {
+				delete(k.writes[round], epoch)
+				pruned++
+			}
+		}
+	}
+	for round := range k.accepts {
+		for epoch := range k.accepts[round] {
+			if epoch < k.epoch {
+				delete(k.accepts[round], epoch)
+				pruned++
+			}
+		}
+	}
+
+	if pruned > 0 {
+		k.traceF(t.Logf("pruned %d entries before %#v", pruned, k.epoch))
+	}
+}
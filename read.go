@@ -0,0 +1,50 @@
+package kayak
+
+// receiveRead answers with the decided entries and the cumulative data hash
+// after the last one. The client trusts the entries once f+1 servers return
+// the same ones.
+func (k *Kayak) receiveRead(t Tracer, from KAddress, read KRead) {
+	t = t.Fork("receiveRead")
+
+	if _, fromServer := k.rkeys[from]; !fromServer && !k.allowExternal {
+		k.traceF(t.Logf("rejected as only internal reads allowed"))
+		k.rejectRead(from, read, ErrorReasonNotAllowed)
+		return
+	}
+
+	if read.First >= read.Last {
+		k.traceF(t.Logf("rejected as invalid"))
+		k.rejectRead(from, read, ErrorReasonInvalid)
+		return
+	}
+
+	if uint(read.Last-read.First) > k.maxRead {
+		k.traceF(t.Logf("rejected as %d entries exceed the limit %d", read.Last-read.First, k.maxRead))
+		k.rejectRead(from, read, ErrorReasonTooLarge)
+		return
+	}
+
+	if read.Last > k.round {
+		k.traceF(t.Logf("rejected as entries are not decided yet"))
+		k.rejectRead(from, read, ErrorReasonAhead)
+		return
+	}
+
+	if read.First < k.logDataOffset {
+		k.traceF(t.Logf("rejected as log is truncated at %#v", k.logDataOffset))
+		k.rejectRead(from, read, ErrorReasonTooOld)
+		return
+	}
+
+	entries := KEntries{
+		Nonce: read.Nonce,
+		First: read.First,
+		Data:  k.logData[read.First-k.logDataOffset : read.Last-k.logDataOffset],
+		Hash:  k.logDataHash[read.Last-k.logDataOffset],
+	}
+	k.sendF(from, entries)
+}
+
+func (k *Kayak) rejectRead(to KAddress, read KRead, reason KErrorReason) {
+	k.sendF(to, KEntries{Nonce: read.Nonce, First: read.First, Error: reason})
+}
@@ -9,29 +9,21 @@ func (k *Kayak) addProcess(t Tracer, processKey KAddress) {
 		return
 	}
 
-	leaderPos := uint(k.epoch) % k.n
-
-	epochDelta := KEpoch(0)
-	for {
-		if uint(k.epoch+epochDelta)%(k.n+1) == leaderPos {
-			break
-		}
-		epochDelta++
+	if k.isLearner(processKey) {
+		k.traceF(t.Logf("promoting learner to voter"))
+		k.removeLearner(t, processKey)
 	}
 
-	if epochDelta > 0 {
-		k.traceF(t.Logf("epoch jump from %#v to %#v to keep current leader %#v at pos %d",
-			k.epoch, k.epoch+epochDelta, k.leader(), leaderPos))
-		k.epoch += epochDelta
-	} else {
-		k.traceF(t.Logf("no epoch jump needed"))
-	}
+	leader := k.leader()
 
 	k.traceF(t.Logf("increasing keys size from %d to %d", len(k.keys), len(k.keys)+1))
 	k.keys = append(k.keys, processKey)
+	k.weights = append(k.weights, 1)
 	k.rkeys[processKey] = len(k.keys) - 1
 	k.updateFactors()
-	k.localClient.ReconfigureTo(k.keys)
+	k.localClient.ReconfigureTo(k.keys, k.weights)
+
+	k.keepLeader(t, leader)
 
 }
 
@@ -50,37 +42,26 @@ func (k *Kayak) removeProcess(t Tracer, processKey KAddress) {
 
 	k.traceF(t.Logf("removing process %#v", processKey))
 
-	if processKey == k.leader() {
-		k.traceF(t.Logf("removing current leader, no epoch jump"))
-	} else {
-		k.traceF(t.Logf("removing not leader, performing epoch jump"))
-		leaderPos := uint(k.epoch) % k.n
-
-		epochDelta := KEpoch(0)
-		for {
-			if uint(k.epoch+epochDelta)%(k.n-1) == leaderPos {
-				break
-			}
-			epochDelta++
-		}
-
-		if epochDelta > 0 {
-			k.traceF(t.Logf("epoch jump from %#v to %#v to keep current leader %#v at pos %d",
-				k.epoch, k.epoch+epochDelta, k.leader(), leaderPos))
-			k.epoch += epochDelta
-		} else {
-			k.traceF(t.Logf("no epoch jump needed"))
-		}
-	}
+	leader := k.leader()
 
 	processPos := k.rkeys[processKey]
 
 	k.traceF(t.Logf("decreasing keys size from %d to %d", len(k.keys), len(k.keys)-1))
 	k.keys = append(k.keys[:processPos], k.keys[processPos+1:]...)
-	delete(k.rkeys, processKey)
+	k.weights = append(k.weights[:processPos], k.weights[processPos+1:]...)
+	k.rkeys = make(map[KAddress]int)
+	for i, key := range k.keys {
+		k.rkeys[key] = i
+	}
 
 	k.updateFactors()
-	k.localClient.ReconfigureTo(k.keys)
+	k.localClient.ReconfigureTo(k.keys, k.weights)
+
+	if processKey == leader {
+		k.traceF(t.Logf("removing current leader, no epoch jump"))
+	} else {
+		k.keepLeader(t, leader)
+	}
 
 	proposes := make(map[KRound]map[KEpoch]map[KAddress]KPropose)
 	for round := range k.proposes {
@@ -100,18 +81,18 @@ func (k *Kayak) removeProcess(t Tracer, processKey KAddress) {
 	}
 	k.proposes = proposes
 
-	writes := make(map[KRound]map[KEpoch]map[KHash]map[KAddress]struct{})
+	writes := make(map[KRound]map[KEpoch]map[KHash]map[KAddress][]byte)
 	for round := range k.writes {
 		if _, found := writes[round]; !found {
-			writes[round] = make(map[KEpoch]map[KHash]map[KAddress]struct{})
+			writes[round] = make(map[KEpoch]map[KHash]map[KAddress][]byte)
 		}
 		for epoch := range k.writes[round] {
 			if _, found := writes[round][epoch]; !found {
-				writes[round][epoch] = make(map[KHash]map[KAddress]struct{})
+				writes[round][epoch] = make(map[KHash]map[KAddress][]byte)
 			}
 			for hash := range k.writes[round][epoch] {
 				if _, found := writes[round][epoch][hash]; !found {
-					writes[round][epoch][hash] = make(map[KAddress]struct{})
+					writes[round][epoch][hash] = make(map[KAddress][]byte)
 				}
 				for address := range k.writes[round][epoch][hash] {
 					if address != processKey {
@@ -123,18 +104,18 @@ func (k *Kayak) removeProcess(t Tracer, processKey KAddress) {
 	}
 	k.writes = writes
 
-	accepts := make(map[KRound]map[KEpoch]map[KHash]map[KAddress]struct{})
+	accepts := make(map[KRound]map[KEpoch]map[KHash]map[KAddress][]byte)
 	for round := range k.accepts {
 		if _, found := accepts[round]; !found {
-			accepts[round] = make(map[KEpoch]map[KHash]map[KAddress]struct{})
+			accepts[round] = make(map[KEpoch]map[KHash]map[KAddress][]byte)
 		}
 		for epoch := range k.accepts[round] {
 			if _, found := accepts[round][epoch]; !found {
-				accepts[round][epoch] = make(map[KHash]map[KAddress]struct{})
+				accepts[round][epoch] = make(map[KHash]map[KAddress][]byte)
 			}
 			for hash := range k.accepts[round][epoch] {
 				if _, found := accepts[round][epoch][hash]; !found {
-					accepts[round][epoch][hash] = make(map[KAddress]struct{})
+					accepts[round][epoch][hash] = make(map[KAddress][]byte)
 				}
 				for address := range k.accepts[round][epoch][hash] {
 					if address != processKey {
@@ -159,6 +140,10 @@ func (k *Kayak) removeProcess(t Tracer, processKey KAddress) {
 	}
 	k.suspects = suspects
 
+	for epoch := range k.suspectSignatures {
+		delete(k.suspectSignatures[epoch], processKey)
+	}
+
 	heads := make(map[KRound]map[KEpoch]map[KAddress]struct{})
 	for round := range k.heads {
 		if _, found := heads[round]; !found {
@@ -201,3 +186,51 @@ func (k *Kayak) removeProcess(t Tracer, processKey KAddress) {
 	k.confirms = confirms
 
 }
+
+// setWeight changes the voting weight of the process. The leader stays the
+// same, since the membership does not change.
+func (k *Kayak) setWeight(t Tracer, processKey KAddress, weight uint) {
+	t = t.Fork("setWeight")
+
+	processPos, exists := k.rkeys[processKey]
+	if !exists {
+		k.traceF(t.Logf("process does not exist, abort"))
+		return
+	}
+
+	if weight == 0 {
+		k.traceF(t.Logf("weight should be positive, abort"))
+		return
+	}
+
+	k.traceF(t.Logf("changing weight of %#v from %d to %d", processKey, k.weights[processPos], weight))
+	k.weights[processPos] = weight
+
+	k.updateFactors()
+	k.localClient.ReconfigureTo(k.keys, k.weights)
+}
+
+// keepLeader performs an epoch jump to the first epoch which elects the
+// leader with the new keys, so that the reconfiguration does not change the
+// leader. The leader changes if the policy does not elect it soon enough.
+func (k *Kayak) keepLeader(t Tracer, leader KAddress) {
+	for epochDelta := KEpoch(0); epochDelta < KEpoch(k.n); epochDelta++ {
+		if k.leaderPolicy.Leader(k.epoch+epochDelta, k.keys) != leader {
+			continue
+		}
+		if epochDelta > 0 {
+			k.traceF(t.Logf("epoch jump from %#v to %#v to keep current leader %#v",
+				k.epoch, k.epoch+epochDelta, leader))
+			k.epoch += epochDelta
+			// The jump is decided, so all the processes make it at the same
+			// entry with nothing to carry and need no view change
+			k.viewEpoch = k.epoch
+			k.carried = make(map[KRound]KPrepared)
+		} else {
+			k.traceF(t.Logf("no epoch jump needed"))
+		}
+		return
+	}
+
+	k.traceF(t.Logf("current leader %#v is not elected again, new leader %#v", leader, k.leader()))
+}
@@ -0,0 +1,64 @@
+package kayak
+
+// recoverLog rebuilds the log, the cumulative hashes, the set of processed
+// requests and the membership from the storage, if the latter is readable.
+// The latest snapshot is restored first if the storage keeps snapshots, the
+// commit certificates are restored along if the storage keeps them.
+func (k *Kayak) recoverLog(t Tracer) {
+	t = t.Fork("recoverLog")
+
+	storage, ok := k.storage.(KRecoverableStorage)
+	if !ok {
+		k.traceF(t.Logf("storage is not recoverable, start from scratch"))
+		return
+	}
+
+	var first KIndex
+	if storage, ok := k.storage.(KSnapshotStorage); ok {
+		snapshot, found, err := storage.LoadSnapshot()
+		if err != nil {
+			k.errorF(t.Errorf("cannot read snapshot: %s", err))
+			return
+		}
+		if found {
+			k.traceF(t.Logf("restore %#v", snapshot))
+			k.installSnapshot(t, snapshot)
+			first = snapshot.Index
+		}
+	}
+
+	n := storage.Len()
+	k.traceF(t.Logf("storage contains entries up to %#v", n))
+
+	certificates, _ := k.storage.(KCertificateStorage)
+
+	var certificate *KCertificate
+	for index := first; index < n; index++ {
+		data, origin, err := storage.Entry(index)
+		if err != nil {
+			k.errorF(t.Errorf("cannot read entry at %#v: %s", index, err))
+			return
+		}
+
+		if certificates != nil && (certificate == nil || !certificate.covers(index)) {
+			certificate = nil
+			loaded, found, err := certificates.LoadCertificate(index)
+			if err != nil {
+				k.errorF(t.Errorf("cannot read certificate at %#v: %s", index, err))
+				return
+			}
+			if found && loaded.covers(index) {
+				certificate = &loaded
+			}
+		}
+
+		buzz := hash(requestOf(data, origin))
+		k.traceF(t.Logf("replay entry %#v with data %#v and buzz %#v", index, KData(data), buzz))
+		k.appendLog(t, data, buzz, origin, certificate)
+	}
+
+	k.mostRecentRoundKnown = k.round
+	k.mostRecentRoundToSync = k.round
+
+	k.traceF(t.Logf("recovered at %#v", k.round))
+}
@@ -0,0 +1,47 @@
+package kayak
+
+// isProcessed reports whether the request cannot be decided at the round,
+// either because it has been already decided or because its index falls
+// behind the tolerance window. The latter makes it safe to forget the buzz
+// of old requests.
+func (k *Kayak) isProcessed(request KRequest, buzz KHash, round KRound) bool {
+	if request.Index+k.indexTolerance < round {
+		return true
+	}
+	_, found := k.setBuzz[buzz]
+	return found
+}
+
+// expireBuzz forgets the buzz decided at the round which just left the
+// tolerance window. Any request with such buzz has its index behind the
+// window, and is rejected by the index check.
+func (k *Kayak) expireBuzz(t Tracer) {
+	if k.round <= k.indexTolerance {
+		return
+	}
+
+	expiredRound := k.round - k.indexTolerance - 1
+	if expiredRound < k.logBuzzOffset {
+		return
+	}
+
+	buzz := k.logBuzz[expiredRound-k.logBuzzOffset]
+	if round, found := k.setBuzz[buzz]; found && round == expiredRound {
+		k.traceF(t.Logf("expire buzz %#v decided at %#v", buzz, expiredRound))
+		delete(k.setBuzz, buzz)
+	}
+}
+
+// dropStaleJobs removes the jobs which cannot be decided anymore as their
+// index falls behind the tolerance window. The jobs are visited by index, so
+// only the stale ones are visited.
+func (k *Kayak) dropStaleJobs(t Tracer) {
+	for {
+		buzz, job, found := k.jobs.lowestIndex()
+		if !found || job.Request.Index+k.indexTolerance >= k.round {
+			return
+		}
+		k.traceF(t.Logf("drop job as its index is too behind %#v", job))
+		k.jobs.remove(buzz)
+	}
+}
@@ -0,0 +1,186 @@
+package kayak
+
+import "container/list"
+
+// sessionKey identifies a session, a client opens a new session each time
+// it starts so that its sequence numbers do not collide with the ones of
+// its previous run
+type sessionKey struct {
+	client  KAddress
+	session KNonce
+}
+
+type sessionSequence struct {
+	key      sessionKey
+	sequence uint64
+}
+
+func sessionKeyOf(request KRequest) sessionKey {
+	return sessionKey{client: request.Client, session: request.Session}
+}
+
+// sessionTable holds the last sequence number decided in each session with
+// the index it has been decided at. It is derived from the decided entries
+// only, so that all the processes hold the same sessions at the same index.
+// The sessions are ordered by the index of their last entry, the oldest one
+// is forgotten once there are more than max of them.
+type sessionTable struct {
+	max     uint
+	order   *list.List
+	entries map[sessionKey]*list.Element
+}
+
+// newSessionTable restores the sessions given in the order of their index
+func newSessionTable(max uint, sessions []KSession) *sessionTable {
+	s := &sessionTable{
+		max:     max,
+		order:   list.New(),
+		entries: make(map[sessionKey]*list.Element),
+	}
+	for _, session := range sessions {
+		s.put(session)
+	}
+	return s
+}
+
+func (s *sessionTable) get(key sessionKey) (KSession, bool) {
+	element, found := s.entries[key]
+	if !found {
+		return KSession{}, false
+	}
+	return element.Value.(KSession), true
+}
+
+// record advances the session of the entry decided at the index, it reports
+// whether the session advanced
+func (s *sessionTable) record(origin KOrigin, index KIndex) bool {
+	if origin.Sequence == 0 || origin.Client == (KAddress{}) {
+		return false
+	}
+
+	key := sessionKey{client: origin.Client, session: origin.Session}
+	if session, found := s.get(key); found && session.Sequence >= origin.Sequence {
+		return false
+	}
+
+	s.put(KSession{Client: origin.Client, Session: origin.Session, Sequence: origin.Sequence, Index: index})
+	return true
+}
+
+func (s *sessionTable) put(session KSession) {
+	key := sessionKey{client: session.Client, session: session.Session}
+	if element, found := s.entries[key]; found {
+		element.Value = session
+		s.order.MoveToBack(element)
+	} else {
+		s.entries[key] = s.order.PushBack(session)
+	}
+
+	for uint(s.order.Len()) > s.max {
+		oldest := s.order.Remove(s.order.Front()).(KSession)
+		delete(s.entries, sessionKey{client: oldest.Client, session: oldest.Session})
+	}
+}
+
+// list returns the sessions in the order of their index
+func (s *sessionTable) list() []KSession {
+	var sessions []KSession
+	for element := s.order.Front(); element != nil; element = element.Next() {
+		sessions = append(sessions, element.Value.(KSession))
+	}
+	return sessions
+}
+
+// recordSession advances the session of the entry decided at the current
+// round, and drops the pending jobs of the session which are decided
+func (k *Kayak) recordSession(t Tracer, origin KOrigin) {
+	if !k.sessions.record(origin, k.round) {
+		return
+	}
+
+	k.traceF(t.Logf("session of %#v advanced to sequence %d at %#v", origin.Client, origin.Sequence, k.round))
+	k.dropAppliedJobs(t, origin.Client)
+}
+
+// dropAppliedJobs removes the jobs of the client whose sequence numbers are
+// already decided, only the jobs of the client are visited
+func (k *Kayak) dropAppliedJobs(t Tracer, client KAddress) {
+	for buzz, job := range k.jobs.clientJobs(client) {
+		if k.isSequenceApplied(job.Request) {
+			k.traceF(t.Logf("drop job as its sequence is already decided %#v", job))
+			k.jobs.remove(buzz)
+		}
+	}
+}
+
+// isSequenceApplied reports whether the request repeats a sequence number
+// already decided in its session
+func (k *Kayak) isSequenceApplied(request KRequest) bool {
+	if request.Sequence == 0 {
+		return false
+	}
+	session, found := k.sessions.get(sessionKeyOf(request))
+	return found && request.Sequence <= session.Sequence
+}
+
+// answerSession responds to the retry of the last request decided in the
+// session with its original index, the retries of the older ones are
+// rejected
+func (k *Kayak) answerSession(t Tracer, from KAddress, request KRequest) {
+	t = t.Fork("answerSession")
+
+	session, _ := k.sessions.get(sessionKeyOf(request))
+	if request.Sequence != session.Sequence {
+		k.traceF(t.Logf("sequence %d is behind the session at %d", request.Sequence, session.Sequence))
+		k.reject(from, request, ErrorReasonReplay)
+		return
+	}
+
+	k.traceF(t.Logf("sequence %d already decided at %#v", request.Sequence, session.Index))
+	k.sendF(from, KResponse{Index: session.Index, Nonce: request.Nonce})
+}
+
+// hasPendingSequence reports whether a job with the same sequence number in
+// the session is waiting to be decided
+func (k *Kayak) hasPendingSequence(request KRequest) bool {
+	if request.Sequence == 0 {
+		return false
+	}
+	for _, job := range k.jobs.clientJobs(request.Client) {
+		if sessionKeyOf(job.Request) == sessionKeyOf(request) && job.Request.Sequence == request.Sequence {
+			return true
+		}
+	}
+	return false
+}
+
+// inflightSequences returns the sequence numbers of the jobs in the slots
+// before the round
+func (k *Kayak) inflightSequences(round KRound) map[sessionSequence]struct{} {
+	inflight := make(map[sessionSequence]struct{})
+	for _, s := range k.slots {
+		if s.round >= round {
+			break
+		}
+		for _, job := range s.jobs {
+			if job.Request.Sequence != 0 {
+				inflight[sessionSequence{key: sessionKeyOf(job.Request), sequence: job.Request.Sequence}] = struct{}{}
+			}
+		}
+	}
+	return inflight
+}
+
+// sessionsAt returns the sessions after the entry at index-1, they are
+// replayed from the latest snapshot
+func (k *Kayak) sessionsAt(index KIndex) []KSession {
+	var base []KSession
+	if k.snapshot != nil {
+		base = k.snapshot.Sessions
+	}
+	sessions := newSessionTable(k.maxSessions, base)
+	for i, origin := range k.logOrigins[:index-k.logDataOffset] {
+		sessions.record(origin, k.logDataOffset+KIndex(i))
+	}
+	return sessions.list()
+}
@@ -0,0 +1,75 @@
+package kayak
+
+import (
+	"crypto/ed25519"
+	"fmt"
+)
+
+// isSignedMessage reports whether the message is signed when signatures
+// are enabled.
+//
+// The requests KWhatsup, KBonjour, KNeed, KEnsure, KEnsureSnapshot, KNeedView
+// and KRead are not signed: they change no state and are only answered to
+// their sender. The answers are signed and trusted once a quorum agrees on
+// them, or for KNewView carry the signed suspects or transfer it is checked
+// against, so a spoofed request cannot make the sender trust anything. A
+// signature would not stop the replay of these requests either, as they
+// carry no nonce, and the clients sending KBonjour and KRead have no keys.
+func isSignedMessage(payload interface{}) bool {
+	switch payload.(type) {
+	case KPropose, KWrite, KAccept, KSuspect, KTransfer, KHead, KTip, KConfirm, KResponse, KEntries,
+		KChunk, KSnapshot, KConfirmSnapshot, KLoad:
+		return true
+	default:
+		return false
+	}
+}
+
+// signatureDigest includes the type of the message, so that a signature of
+// one message cannot be presented for a message of another type with the
+// same fields
+func signatureDigest(payload interface{}) KHash {
+	return hash(struct {
+		Type    string
+		Payload interface{}
+	}{
+		Type:    fmt.Sprintf("%T", payload),
+		Payload: payload,
+	})
+}
+
+func sign(key ed25519.PrivateKey, payload interface{}) KSigned {
+	digest := signatureDigest(payload)
+	return KSigned{
+		Payload:   payload,
+		Signature: ed25519.Sign(key, digest[:]),
+	}
+}
+
+// verify checks the signature against the sender, whose address is its
+// public key
+func verify(from KAddress, signed KSigned) bool {
+	if !isSignedMessage(signed.Payload) {
+		return false
+	}
+	digest := signatureDigest(signed.Payload)
+	return ed25519.Verify(ed25519.PublicKey(from[:]), digest[:], signed.Signature)
+}
+
+// unwrapSigned returns the message to dispatch and its signature. The signed
+// message is unwrapped if the signature is valid, the unsigned message is
+// accepted unless signatures are required.
+func unwrapSigned(from KAddress, payload interface{}, required bool) (interface{}, []byte, error) {
+	if signed, ok := payload.(KSigned); ok {
+		if !verify(from, signed) {
+			return nil, nil, fmt.Errorf("invalid signature of %#v", signed.Payload)
+		}
+		return signed.Payload, signed.Signature, nil
+	}
+
+	if required && isSignedMessage(payload) {
+		return nil, nil, fmt.Errorf("unsigned %#v", payload)
+	}
+
+	return payload, nil, nil
+}
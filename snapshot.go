@@ -0,0 +1,429 @@
+package kayak
+
+import (
+	"crypto/sha256"
+	"fmt"
+)
+
+type keysAt struct {
+	round    KRound
+	keys     []KAddress
+	weights  []uint
+	learners []KAddress
+}
+
+type snapshotHeader struct {
+	Index     KIndex
+	StateHash KHash
+	DataHash  KHash
+	BuzzHash  KHash
+	BuzzBase  KHash
+	BuzzLen   int
+	Sessions  KHash
+	Keys      []KAddress
+	Weights   []uint
+	Learners  []KAddress
+}
+
+// Snapshot records the application state after the entry at index-1 and
+// drops the log entries before index from memory. Processes lagging behind
+// the truncated log receive the snapshot instead of the missing entries. For
+// the snapshot to be confirmed by a quorum, all processes should snapshot at
+// the same indexes.
+func (k *Kayak) Snapshot(index KIndex, state []byte) error {
+	k.Lock()
+	defer k.Unlock()
+
+	k.traceF(fmt.Sprintf("<--------------: SNAPSHOT at %#v", index))
+	t := NewTracer("               ").Fork("Snapshot")
+
+	if index > k.round {
+		return t.Errorf("index %#v is ahead of round %#v", index, k.round)
+	}
+
+	if k.snapshot != nil && index <= k.snapshot.Index {
+		return t.Errorf("index %#v is not after the latest snapshot at %#v", index, k.snapshot.Index)
+	}
+
+	if index < k.logDataOffset {
+		return t.Errorf("index %#v is before the log start %#v", index, k.logDataOffset)
+	}
+
+	buzzFrom := k.snapshotBuzzFrom(index)
+	if buzzFrom < k.logBuzzOffset {
+		return t.Errorf("buzz from %#v is before the buzz log start %#v", buzzFrom, k.logBuzzOffset)
+	}
+
+	keys, weights := k.keysAtRound(index)
+	learners := k.learnersAtRound(index)
+
+	snapshot := KSnapshot{
+		Index:    index,
+		State:    append(KData(nil), state...),
+		Keys:     keys,
+		Weights:  weights,
+		Learners: learners,
+		DataHash: k.logDataHash[index-k.logDataOffset],
+		BuzzHash: k.logBuzzHash[index-k.logBuzzOffset],
+		BuzzBase: k.logBuzzHash[buzzFrom-k.logBuzzOffset],
+		Buzz:     append([]KHash(nil), k.logBuzz[buzzFrom-k.logBuzzOffset:index-k.logBuzzOffset]...),
+		Sessions: k.sessionsAt(index),
+	}
+
+	k.traceF(t.Logf("made %#v", snapshot))
+
+	if storage, ok := k.storage.(KSnapshotStorage); ok {
+		storage.SaveSnapshot(snapshot)
+	}
+
+	k.traceF(t.Logf("truncate data log from %#v to %#v", k.logDataOffset, index))
+	k.logData = append([]KData(nil), k.logData[index-k.logDataOffset:]...)
+	k.logOrigins = append([]KOrigin(nil), k.logOrigins[index-k.logDataOffset:]...)
+	k.logDataHash = append([]KHash(nil), k.logDataHash[index-k.logDataOffset:]...)
+	k.logDataOffset = index
+	k.pruneCertificates()
+
+	k.traceF(t.Logf("truncate buzz log from %#v to %#v", k.logBuzzOffset, buzzFrom))
+	k.logBuzz = append([]KHash(nil), k.logBuzz[buzzFrom-k.logBuzzOffset:]...)
+	k.logBuzzHash = append([]KHash(nil), k.logBuzzHash[buzzFrom-k.logBuzzOffset:]...)
+	k.logBuzzOffset = buzzFrom
+
+	for len(k.keysLog) > 1 && k.keysLog[1].round <= index {
+		k.keysLog = k.keysLog[1:]
+	}
+
+	k.snapshot = &snapshot
+
+	k.traceF(t.Logf("--------------------------------------------------------------------"))
+
+	return nil
+}
+
+func (k *Kayak) receiveSnapshot(t Tracer, from KAddress, snapshot KSnapshot) {
+	t = t.Fork("receiveSnapshot")
+
+	if _, fromServer := k.rkeys[from]; !fromServer {
+		k.traceF(t.Logf("rejected as not from server"))
+		return
+	}
+
+	if snapshot.Index <= k.round {
+		k.traceF(t.Logf("rejected as useless"))
+		return
+	}
+
+	if KIndex(len(snapshot.Buzz)) != snapshot.Index-k.snapshotBuzzFrom(snapshot.Index) {
+		k.traceF(t.Logf("rejected as invalid -- buzz do not cover the index tolerance"))
+		return
+	}
+
+	if cumBuzzHash(snapshot.BuzzBase, snapshot.Buzz...) != snapshot.BuzzHash {
+		k.traceF(t.Logf("rejected as invalid -- buzz do not match buzz hash"))
+		return
+	}
+
+	if _, err := makeWeights(snapshot.Keys, snapshot.Weights); err != nil {
+		k.traceF(t.Logf("rejected as invalid -- %s", err))
+		return
+	}
+
+	snapshotHash := hashSnapshot(snapshot)
+
+	if !k.receiveConfirmSnapshot(t, from, KConfirmSnapshot{Index: snapshot.Index, Hash: snapshotHash}) {
+		k.traceF(t.Logf("rejected as its confirm is rejected"))
+		return
+	}
+
+	if _, ok := k.snapshots[snapshot.Index]; !ok {
+		k.snapshots[snapshot.Index] = make(map[KHash]KSnapshot)
+	}
+	k.snapshots[snapshot.Index][snapshotHash] = snapshot
+	k.traceF(t.Logf("recorded with hash %#v", snapshotHash))
+
+	if k.snapshotEnsureSent[snapshot.Index] {
+		k.traceF(t.Logf("ensure already sent"))
+		return
+	}
+
+	ensure := KEnsureSnapshot{Index: snapshot.Index}
+	for _, key := range k.keys {
+		if key == k.key || key == from {
+			continue
+		}
+		k.sendF(key, ensure)
+	}
+	k.snapshotEnsureSent[snapshot.Index] = true
+}
+
+func (k *Kayak) receiveEnsureSnapshot(t Tracer, from KAddress, ensure KEnsureSnapshot) {
+	t = t.Fork("receiveEnsureSnapshot")
+
+	if _, fromServer := k.rkeys[from]; !fromServer && !k.isLearner(from) {
+		k.traceF(t.Logf("rejected as not from server or learner"))
+		return
+	}
+
+	if k.snapshot == nil || k.snapshot.Index != ensure.Index {
+		k.traceF(t.Logf("rejected as no snapshot at %#v", ensure.Index))
+		return
+	}
+
+	confirm := KConfirmSnapshot{Index: ensure.Index, Hash: hashSnapshot(*k.snapshot)}
+	k.sendF(from, confirm)
+}
+
+// receiveConfirmSnapshot records the confirm, it reports whether the confirm
+// is recorded
+func (k *Kayak) receiveConfirmSnapshot(t Tracer, from KAddress, confirm KConfirmSnapshot) bool {
+	t = t.Fork("receiveConfirmSnapshot")
+
+	if _, fromServer := k.rkeys[from]; !fromServer {
+		k.traceF(t.Logf("rejected as not from server"))
+		return false
+	}
+
+	if confirm.Index <= k.round {
+		k.traceF(t.Logf("rejected as useless"))
+		return false
+	}
+
+	if confirm.Index > k.mostRecentRoundKnown+k.indexTolerance {
+		k.traceF(t.Logf("rejected as beyond the index tolerance of the most recent round known %#v", k.mostRecentRoundKnown))
+		return false
+	}
+
+	// Only the confirm for the highest index is kept from each server, so that
+	// a server cannot fill the memory with snapshots
+	for index, confirms := range k.snapshotConfirms {
+		for snapshotHash, senders := range confirms {
+			if _, ok := senders[from]; !ok {
+				continue
+			}
+			if index > confirm.Index {
+				k.traceF(t.Logf("rejected as a snapshot at %#v is already confirmed", index))
+				return false
+			}
+			if index == confirm.Index && snapshotHash == confirm.Hash {
+				continue
+			}
+			k.dropSnapshotConfirm(index, snapshotHash, from)
+		}
+	}
+
+	if _, ok := k.snapshotConfirms[confirm.Index]; !ok {
+		k.snapshotConfirms[confirm.Index] = make(map[KHash]map[KAddress]struct{})
+	}
+	if _, ok := k.snapshotConfirms[confirm.Index][confirm.Hash]; !ok {
+		k.snapshotConfirms[confirm.Index][confirm.Hash] = make(map[KAddress]struct{})
+	}
+
+	k.snapshotConfirms[confirm.Index][confirm.Hash][from] = struct{}{}
+	k.traceF(t.Logf("recorded"))
+	return true
+}
+
+// dropSnapshotConfirm forgets the confirm of the server, the snapshot is
+// forgotten with its last confirm
+func (k *Kayak) dropSnapshotConfirm(index KIndex, snapshotHash KHash, from KAddress) {
+	delete(k.snapshotConfirms[index][snapshotHash], from)
+	if len(k.snapshotConfirms[index][snapshotHash]) > 0 {
+		return
+	}
+
+	delete(k.snapshotConfirms[index], snapshotHash)
+	delete(k.snapshots[index], snapshotHash)
+	if len(k.snapshotConfirms[index]) == 0 {
+		delete(k.snapshotConfirms, index)
+	}
+	if len(k.snapshots[index]) == 0 {
+		delete(k.snapshots, index)
+	}
+}
+
+func (k *Kayak) maybeInstallSnapshot(t Tracer) bool {
+	t = t.Fork("maybeInstallSnapshot")
+
+	var snapshot KSnapshot
+	var found bool
+
+	for index := range k.snapshots {
+		if index <= k.round || (found && index <= snapshot.Index) {
+			continue
+		}
+		for snapshotHash := range k.snapshots[index] {
+			hasN := k.votes(k.snapshotConfirms[index][snapshotHash])
+			if hasN >= k.factQuorum() {
+				k.traceF(t.Logf("confirm quorum (%d/%d) reached for snapshot at %#v", hasN, k.factQuorum(), index))
+				snapshot = k.snapshots[index][snapshotHash]
+				found = true
+			} else {
+				k.traceF(t.Logf("confirm quorum (%d/%d) not reached for snapshot at %#v", hasN, k.factQuorum(), index))
+			}
+		}
+	}
+
+	if !found {
+		k.traceF(t.Logf("no confirmed snapshots"))
+		return false
+	}
+
+	k.traceF(t.Logf("gogo, install %#v", snapshot))
+
+	if storage, ok := k.storage.(KSnapshotStorage); ok {
+		storage.SaveSnapshot(snapshot)
+	} else if _, ok := k.storage.(KRecoverableStorage); ok {
+		k.errorF(t.Errorf("storage does not keep snapshots, log cannot be recovered from it"))
+	}
+
+	k.installSnapshot(t, snapshot)
+
+	for index := range k.snapshots {
+		if index <= k.round {
+			delete(k.snapshots, index)
+			delete(k.snapshotEnsureSent, index)
+			delete(k.snapshotConfirms, index)
+		}
+	}
+
+	k.resetPipeline(t)
+
+	k.traceF(t.Logf("allow sync of the rest up to %#v", k.mostRecentRoundKnown))
+	delete(k.syncSent, k.mostRecentRoundKnown)
+
+	return true
+}
+
+// installSnapshot replaces the log with the snapshot. It is shared by
+// maybeInstallSnapshot and recoverLog.
+func (k *Kayak) installSnapshot(t Tracer, snapshot KSnapshot) {
+	t = t.Fork("installSnapshot")
+
+	weights, err := makeWeights(snapshot.Keys, snapshot.Weights)
+	if err != nil {
+		k.errorF(t.Errorf("cannot install the membership: %s", err))
+		return
+	}
+
+	k.logData = nil
+	k.logOrigins = nil
+	k.logCerts = nil
+	k.logDataHash = []KHash{snapshot.DataHash}
+	k.logDataOffset = snapshot.Index
+
+	k.logBuzz = append([]KHash(nil), snapshot.Buzz...)
+	k.logBuzzHash = []KHash{snapshot.BuzzBase}
+	k.logBuzzOffset = snapshot.Index - KIndex(len(snapshot.Buzz))
+	k.setBuzz = make(map[KHash]KRound)
+	for i, buzz := range snapshot.Buzz {
+		k.logBuzzHash = append(k.logBuzzHash, cumBuzzHash(k.logBuzzHash[len(k.logBuzzHash)-1], buzz))
+		k.setBuzz[buzz] = k.logBuzzOffset + KRound(i)
+
+		if job, found := k.jobs.get(buzz); found {
+			k.traceF(t.Logf("remove job as completed %#v", job))
+			k.jobs.remove(buzz)
+		}
+	}
+
+	k.sessions = newSessionTable(k.maxSessions, snapshot.Sessions)
+	var applied []KHash
+	k.jobs.ascend(func(buzz KHash, job *KJob) bool {
+		if k.isSequenceApplied(job.Request) {
+			applied = append(applied, buzz)
+		}
+		return true
+	})
+	for _, buzz := range applied {
+		k.traceF(t.Logf("remove job as its sequence is already decided %#v", buzz))
+		k.jobs.remove(buzz)
+	}
+
+	k.traceF(t.Logf("advance round from %#v to %#v", k.round, snapshot.Index))
+	k.round = snapshot.Index
+
+	if !sameKeys(k.keys, snapshot.Keys) || !sameWeights(k.weights, weights) {
+		k.traceF(t.Logf("replace keys %#v with %#v", k.keys, snapshot.Keys))
+		k.keys = append([]KAddress(nil), snapshot.Keys...)
+		k.weights = weights
+		k.rkeys = make(map[KAddress]int)
+		for i, key := range k.keys {
+			k.rkeys[key] = i
+		}
+		k.updateFactors()
+		k.localClient.ReconfigureTo(k.keys, k.weights)
+	}
+	if !sameKeys(k.learners, snapshot.Learners) {
+		k.traceF(t.Logf("replace learners %#v with %#v", k.learners, snapshot.Learners))
+		k.learners = append([]KAddress(nil), snapshot.Learners...)
+	}
+	k.keysLog = []keysAt{{
+		round:    snapshot.Index,
+		keys:     append([]KAddress(nil), snapshot.Keys...),
+		weights:  append([]uint(nil), weights...),
+		learners: append([]KAddress(nil), snapshot.Learners...),
+	}}
+
+	k.snapshot = &snapshot
+
+	k.installF(snapshot.Index, snapshot.State)
+}
+
+// snapshotBuzzFrom returns the first round whose buzz the snapshot at the
+// index keeps, so that the requests within the index tolerance are still
+// detected as processed
+func (k *Kayak) snapshotBuzzFrom(index KIndex) KRound {
+	if index > k.indexTolerance {
+		return index - k.indexTolerance
+	}
+	return 0
+}
+
+// keysAtRound returns the membership in force at the round with the weights
+func (k *Kayak) keysAtRound(round KRound) ([]KAddress, []uint) {
+	at := k.keysLog[0]
+	for _, entry := range k.keysLog {
+		if entry.round > round {
+			break
+		}
+		at = entry
+	}
+	return append([]KAddress(nil), at.keys...), append([]uint(nil), at.weights...)
+}
+
+// learnersAtRound returns the learners in force at the round
+func (k *Kayak) learnersAtRound(round KRound) []KAddress {
+	at := k.keysLog[0]
+	for _, entry := range k.keysLog {
+		if entry.round > round {
+			break
+		}
+		at = entry
+	}
+	return append([]KAddress(nil), at.learners...)
+}
+
+func hashSnapshot(snapshot KSnapshot) KHash {
+	return hash(snapshotHeader{
+		Index:     snapshot.Index,
+		StateHash: sha256.Sum256(snapshot.State),
+		DataHash:  snapshot.DataHash,
+		BuzzHash:  snapshot.BuzzHash,
+		BuzzBase:  snapshot.BuzzBase,
+		BuzzLen:   len(snapshot.Buzz),
+		Sessions:  hash(snapshot.Sessions),
+		Keys:      snapshot.Keys,
+		Weights:   snapshot.Weights,
+		Learners:  snapshot.Learners,
+	})
+}
+
+func sameKeys(a, b []KAddress) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -1,8 +1,8 @@
 package kayak
 
 func (k *Kayak) receiveWhatsup(t Tracer, from KAddress) {
-	if _, fromServer := k.rkeys[from]; !fromServer {
-		k.traceF(t.Logf("receiveWhatsup: rejected as not from server"))
+	if _, fromServer := k.rkeys[from]; !fromServer && !k.isLearner(from) {
+		k.traceF(t.Logf("receiveWhatsup: rejected as not from server or learner"))
 		return
 	}
 
@@ -43,8 +43,8 @@ func (k *Kayak) receiveHead(t Tracer, from KAddress, head KHead) {
 
 	k.heads[head.Round][head.Epoch][from] = struct{}{}
 
-	if uint(len(k.heads[head.Round][head.Epoch])) >= k.q {
-		k.traceF(t.Logf("head quorum (%d/%d) reached", uint(len(k.heads[head.Round][head.Epoch])), k.q))
+	if k.votes(k.heads[head.Round][head.Epoch]) >= k.factQuorum() {
+		k.traceF(t.Logf("head quorum (%d/%d) reached", k.votes(k.heads[head.Round][head.Epoch]), k.factQuorum()))
 		if head.Round >= k.mostRecentRoundKnown {
 			if head.Epoch >= k.mostRecentEpochKnown {
 				k.traceF(t.Logf("updating most recent known round and epoch from %#v:%#v to %#v:%#v",
@@ -58,15 +58,15 @@ func (k *Kayak) receiveHead(t Tracer, from KAddress, head KHead) {
 			k.traceF(t.Logf("head round is not the most recent known %#v", k.mostRecentRoundKnown))
 		}
 	} else {
-		k.traceF(t.Logf("head quorum (%d/%d) not reached", uint(len(k.heads[head.Round][head.Epoch])), k.q))
+		k.traceF(t.Logf("head quorum (%d/%d) not reached", k.votes(k.heads[head.Round][head.Epoch]), k.factQuorum()))
 	}
 }
 
 func (k *Kayak) receiveNeed(t Tracer, from KAddress, need KNeed) {
 	t = t.Fork("receiveNeed")
 
-	if _, fromServer := k.rkeys[from]; !fromServer {
-		k.traceF(t.Logf("rejected as not from server"))
+	if _, fromServer := k.rkeys[from]; !fromServer && !k.isLearner(from) {
+		k.traceF(t.Logf("rejected as not from server or learner"))
 		return
 	}
 
@@ -80,10 +80,22 @@ func (k *Kayak) receiveNeed(t Tracer, from KAddress, need KNeed) {
 		return
 	}
 
+	if need.First < k.logDataOffset {
+		if k.snapshot == nil {
+			k.traceF(t.Logf("rejected as log is truncated at %#v", k.logDataOffset))
+			return
+		}
+		k.traceF(t.Logf("log is truncated at %#v, sending snapshot", k.logDataOffset))
+		k.sendF(from, *k.snapshot)
+		return
+	}
+
 	chunk := KChunk{
-		Last: need.Last,
-		Data: k.logData[need.First:need.Last],
-		Buzz: k.logBuzz[need.First:need.Last],
+		Last:         need.Last,
+		Data:         k.logData[need.First-k.logDataOffset : need.Last-k.logDataOffset],
+		Buzz:         k.logBuzz[need.First-k.logBuzzOffset : need.Last-k.logBuzzOffset],
+		Origins:      k.logOrigins[need.First-k.logDataOffset : need.Last-k.logDataOffset],
+		Certificates: k.certificatesBetween(need.First, need.Last),
 	}
 
 	for i := 0; i < int(need.Last-need.First); i++ {
@@ -97,8 +109,8 @@ func (k *Kayak) receiveNeed(t Tracer, from KAddress, need KNeed) {
 func (k *Kayak) receiveEnsure(t Tracer, from KAddress, ensure KEnsure) {
 	t = t.Fork("receiveEnsure")
 
-	if _, fromServer := k.rkeys[from]; !fromServer {
-		k.traceF(t.Logf("rejected as not from server"))
+	if _, fromServer := k.rkeys[from]; !fromServer && !k.isLearner(from) {
+		k.traceF(t.Logf("rejected as not from server or learner"))
 		return
 	}
 
@@ -107,10 +119,15 @@ func (k *Kayak) receiveEnsure(t Tracer, from KAddress, ensure KEnsure) {
 		return
 	}
 
+	if ensure.Last < k.logDataOffset {
+		k.traceF(t.Logf("rejected as log is truncated at %#v", k.logDataOffset))
+		return
+	}
+
 	confirm := KConfirm{
 		Last:     ensure.Last,
-		DataHash: k.logDataHash[ensure.Last],
-		BuzzHash: k.logBuzzHash[ensure.Last],
+		DataHash: k.logDataHash[ensure.Last-k.logDataOffset],
+		BuzzHash: k.logBuzzHash[ensure.Last-k.logBuzzOffset],
 	}
 	k.sendF(from, confirm)
 
@@ -145,6 +162,16 @@ func (k *Kayak) receiveChunk(t Tracer, from KAddress, chunk KChunk) {
 
 	indexFrom := len(chunk.Data) - usefulLen
 
+	if len(chunk.Origins) != len(chunk.Data) {
+		k.traceF(t.Logf("rejected as invalid -- no origins"))
+		return
+	}
+
+	if k.certifiedSync {
+		k.applyCertifiedChunk(t, chunk, indexFrom)
+		return
+	}
+
 	logDataHash := cumDataHash(
 		k.logDataHash[len(k.logDataHash)-1],
 		chunk.Data[indexFrom:]...,
@@ -167,6 +194,28 @@ func (k *Kayak) receiveChunk(t Tracer, from KAddress, chunk KChunk) {
 
 	k.syncBuzz[chunk.Last][logBuzzHash] = chunk.Buzz[indexFrom:]
 
+	for i := indexFrom; i < len(chunk.Data); i++ {
+		if hash(requestOf(chunk.Data[i], chunk.Origins[i])) != chunk.Buzz[i] {
+			k.traceF(t.Logf("rejected as invalid -- origin at %d does not match the buzz", i))
+			return
+		}
+	}
+
+	if _, ok := k.syncOrigins[chunk.Last]; !ok {
+		k.syncOrigins[chunk.Last] = make(map[KHash][]KOrigin)
+	}
+	k.syncOrigins[chunk.Last][logBuzzHash] = chunk.Origins[indexFrom:]
+
+	for _, certificate := range chunk.Certificates {
+		if certificate.Round < k.round || certificate.Round >= chunk.Last {
+			continue
+		}
+		if _, ok := k.syncCerts[certificate.Round]; !ok {
+			k.syncCerts[certificate.Round] = make(map[KAddress]KCertificate)
+		}
+		k.syncCerts[certificate.Round][from] = certificate
+	}
+
 	confirm := KConfirm{
 		Last:     chunk.Last,
 		DataHash: logDataHash,
@@ -202,9 +251,9 @@ func (k *Kayak) receiveConfirm(t Tracer, from KAddress, confirm KConfirm) {
 	k.confirms[confirm.Last][confirm.DataHash][confirm.BuzzHash][from] = struct{}{}
 	k.traceF(t.Logf("recorded"))
 
-	hasN := uint(len(k.confirms[confirm.Last][confirm.DataHash][confirm.BuzzHash]))
-	if hasN >= k.q {
-		k.traceF(t.Logf("confirm quorum (%d/%d) reached", hasN, k.q))
+	hasN := k.votes(k.confirms[confirm.Last][confirm.DataHash][confirm.BuzzHash])
+	if hasN >= k.factQuorum() {
+		k.traceF(t.Logf("confirm quorum (%d/%d) reached", hasN, k.factQuorum()))
 		if confirm.Last > k.mostRecentRoundToSync {
 			k.traceF(t.Logf("updating most recent round to sync from %#v to %#v", k.mostRecentRoundToSync, confirm.Last))
 			k.mostRecentRoundToSync = confirm.Last
@@ -214,7 +263,7 @@ func (k *Kayak) receiveConfirm(t Tracer, from KAddress, confirm KConfirm) {
 			k.mostRecentBuzzToSync = confirm.BuzzHash
 		}
 	} else {
-		k.traceF(t.Logf("confirm quorum (%d/%d) not reached", hasN, k.q))
+		k.traceF(t.Logf("confirm quorum (%d/%d) not reached", hasN, k.factQuorum()))
 	}
 }
 
@@ -246,6 +295,10 @@ func (k *Kayak) maybeSync(t Tracer) bool {
 		return false
 	}
 
+	if k.certifiedSync {
+		return k.maybeCertifiedSync(t)
+	}
+
 	if k.syncSent[k.mostRecentRoundKnown] {
 		k.traceF(t.Logf("sync already sent"))
 		return false
@@ -283,6 +336,7 @@ func (k *Kayak) maybeUpdate(t Tracer) bool {
 
 	missingData, dataFound := k.syncData[k.mostRecentRoundToSync][k.mostRecentHashToSync]
 	missingBuzz, buzzFound := k.syncBuzz[k.mostRecentRoundToSync][k.mostRecentBuzzToSync]
+	missingOrigins, originsFound := k.syncOrigins[k.mostRecentRoundToSync][k.mostRecentBuzzToSync]
 
 	if !dataFound {
 		k.traceF(t.Logf("data chunk with hash %#v not found", k.mostRecentHashToSync))
@@ -293,8 +347,13 @@ func (k *Kayak) maybeUpdate(t Tracer) bool {
 		return false
 	}
 
-	if len(missingData) != len(missingBuzz) {
-		k.traceF(t.Logf("slice lengths not equal: %d != %d", len(missingData), len(missingBuzz)))
+	if !originsFound {
+		k.traceF(t.Logf("origins with hash %#v not found", k.mostRecentBuzzToSync))
+		return false
+	}
+
+	if len(missingData) != len(missingBuzz) || len(missingData) != len(missingOrigins) {
+		k.traceF(t.Logf("slice lengths not equal: %d, %d, %d", len(missingData), len(missingBuzz), len(missingOrigins)))
 		return false
 	}
 
@@ -304,26 +363,66 @@ func (k *Kayak) maybeUpdate(t Tracer) bool {
 
 	k.traceF(t.Logf("advancing log to %d entries", advanceN))
 
+	missingBuzz = missingBuzz[len(missingBuzz)-advanceN:]
+
+	var certificate *KCertificate
 	for i := 0; i < advanceN; i++ {
 		data := missingData[len(missingData)-advanceN+i]
-		buzz := missingBuzz[len(missingBuzz)-advanceN+i]
-		k.decide(t, data, buzz)
+		buzz := missingBuzz[i]
+		origin := missingOrigins[len(missingOrigins)-advanceN+i]
+		if certificate == nil || !certificate.covers(k.round) {
+			certificate = k.syncedCertificate(t, missingBuzz[i:])
+		}
+		k.decide(t, data, buzz, origin, certificate)
 	}
 
-	if k.epoch < k.mostRecentEpochKnown {
-		k.traceF(t.Logf("advancing epoch %#v >> %#v", k.epoch, k.mostRecentEpochKnown))
-		k.epoch = k.mostRecentEpochKnown
-	} else {
-		k.traceF(t.Logf("no need to advance epoch"))
+	k.completeUpdate(t)
+
+	return true
+
+}
+
+// syncedCertificate returns a certificate received with the chunks for the
+// batch starting at the current round, if one is valid for the buzz which
+// follows. Batches reaching past the buzz are left uncertified.
+func (k *Kayak) syncedCertificate(t Tracer, buzz []KHash) *KCertificate {
+	keys, weights := k.keysAtRound(k.round)
+
+	for from, certificate := range k.syncCerts[k.round] {
+		if len(certificate.Buzz) > len(buzz) {
+			continue
+		}
+		if cumBuzzHash(KHash{}, certificate.Buzz...) != cumBuzzHash(KHash{}, buzz[:len(certificate.Buzz)]...) {
+			k.traceF(t.Logf("certificate from %#v does not match the buzz", from))
+			continue
+		}
+		if err := checkCertificateSignatures(certificate, keys, weights, k.faultModel); err != nil {
+			k.traceF(t.Logf("certificate from %#v is invalid: %s", from, err))
+			continue
+		}
+		return &certificate
 	}
 
+	return nil
+}
+
+// completeUpdate catches up with the rest of the protocol state once the log
+// is advanced by sync
+func (k *Kayak) completeUpdate(t Tracer) {
+	if len(k.slots) > 0 && k.slots[0].round != k.round {
+		k.traceF(t.Logf("rounds in flight do not follow the log"))
+		k.resetPipeline(t)
+	}
+
+	k.advanceEpoch(t, k.mostRecentEpochKnown)
+
 	if k.lcState != LCStateIdle {
 		k.traceF(t.Logf("modify leader change state from %#v to %#v", k.lcState, LCStateIdle))
 		k.lcState = LCStateIdle
 	}
 
-	return true
-
+	k.pruneRounds(t)
+	k.pruneEpochs(t)
 }
 
 func (k *Kayak) rescheduleWhatsup(t Tracer) {
@@ -0,0 +1,156 @@
+package test
+
+import (
+	"testing"
+
+	"github.com/stratumn/kayak"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// externalClients records the responses to the processes which are not in
+// the network, and drops the proposes if asked for
+type externalClients struct {
+	network      *signedNetwork
+	keys         []kayak.KAddress
+	responses    map[kayak.KAddress][]kayak.KResponse
+	dropProposes bool
+}
+
+func newExternalClients(network *signedNetwork, keys []kayak.KAddress) *externalClients {
+	e := &externalClients{
+		network:   network,
+		keys:      keys,
+		responses: make(map[kayak.KAddress][]kayak.KResponse),
+	}
+	network.tamperF = func(p packet) (packet, bool) {
+		s, signed := p.payload.(kayak.KSigned)
+		if !signed {
+			return p, true
+		}
+		if _, isPropose := s.Payload.(kayak.KPropose); isPropose && e.dropProposes {
+			return p, false
+		}
+		if r, ok := s.Payload.(kayak.KResponse); ok {
+			if _, found := network.nodes[p.to]; !found {
+				e.responses[p.to] = append(e.responses[p.to], r)
+				return p, false
+			}
+		}
+		return p, true
+	}
+	return e
+}
+
+func (e *externalClients) send(client kayak.KAddress, request kayak.KRequest) []kayak.KResponse {
+	e.responses[client] = nil
+	for _, key := range e.keys {
+		e.network.nodes[key].ReceiveNet(client, request)
+	}
+	e.network.run()
+	return e.responses[client]
+}
+
+func assertRejected(t *testing.T, responses []kayak.KResponse, n int, reason kayak.KErrorReason) {
+	require.Len(t, responses, n)
+	for _, r := range responses {
+		assert.Equal(t, reason, r.Error)
+	}
+}
+
+// The test ensures that the requests over the limits of pending jobs and of
+// payload size are rejected
+func TestAdmissionLimits(t *testing.T) {
+	network, keys := makeSignedNetwork(t, 4, func(c *kayak.KServerConfig) {
+		c.AllowExternal = true
+		c.MaxJobs = 2
+		c.MaxClientJobs = 1
+		c.MaxPayload = 8
+	})
+	clients := newExternalClients(network, keys)
+
+	clientA := kayak.KAddress{0xCC, 0x0A}
+	clientB := kayak.KAddress{0xCC, 0x0B}
+	clientC := kayak.KAddress{0xCC, 0x0C}
+
+	large := kayak.KRequest{Nonce: kayak.KNonce{0x01}, Payload: make(kayak.KData, 9)}
+	assertRejected(t, clients.send(clientA, large), len(keys), kayak.ErrorReasonTooLarge)
+
+	// The jobs stay pending as nothing is proposed
+	clients.dropProposes = true
+
+	assert.Empty(t, clients.send(clientA, kayak.KRequest{Nonce: kayak.KNonce{0x02}, Payload: kayak.KData("a1")}))
+	assertRejected(t, clients.send(clientA, kayak.KRequest{Nonce: kayak.KNonce{0x03}, Payload: kayak.KData("a2")}), len(keys), kayak.ErrorReasonOverloaded)
+
+	assert.Empty(t, clients.send(clientB, kayak.KRequest{Nonce: kayak.KNonce{0x04}, Payload: kayak.KData("b1")}))
+	assertRejected(t, clients.send(clientC, kayak.KRequest{Nonce: kayak.KNonce{0x05}, Payload: kayak.KData("c1")}), len(keys), kayak.ErrorReasonOverloaded)
+
+	for _, key := range keys {
+		assert.Equal(t, 2, network.nodes[key].Footprint().Jobs)
+	}
+}
+
+// The test ensures that a client gets a token every ClientRateT ticks
+func TestAdmissionRate(t *testing.T) {
+	const rateT = 100
+
+	network, keys := makeSignedNetwork(t, 4, func(c *kayak.KServerConfig) {
+		c.AllowExternal = true
+		c.ClientRateT = rateT
+		c.ClientBurst = 2
+	})
+	clients := newExternalClients(network, keys)
+
+	client := kayak.KAddress{0xCC, 0x0A}
+
+	for i := 0; i < 2; i++ {
+		responses := clients.send(client, kayak.KRequest{Nonce: kayak.KNonce{byte(i)}, Payload: kayak.KData{byte(i)}})
+		require.Len(t, responses, len(keys))
+		for _, r := range responses {
+			assert.Equal(t, kayak.ErrorReasonNone, r.Error)
+		}
+	}
+
+	assertRejected(t, clients.send(client, kayak.KRequest{Nonce: kayak.KNonce{0x02}, Payload: kayak.KData{0x02}}), len(keys), kayak.ErrorReasonRateLimited)
+
+	for _, key := range keys {
+		network.nodes[key].Tick(rateT)
+	}
+	network.run()
+
+	responses := clients.send(client, kayak.KRequest{Nonce: kayak.KNonce{0x03}, Payload: kayak.KData{0x03}, Index: 2})
+	require.Len(t, responses, len(keys))
+	for _, r := range responses {
+		assert.Equal(t, kayak.ErrorReasonNone, r.Error)
+	}
+
+	for _, key := range keys {
+		assert.Len(t, network.logs[key].Entries, 3)
+	}
+}
+
+// The test ensures that a suspect with more loads than the limit of pending
+// jobs still counts for the leader change, its loads are truncated
+func TestAdmissionSuspectLoads(t *testing.T) {
+	network, keys := makeSignedNetwork(t, 4, func(c *kayak.KServerConfig) {
+		c.MaxJobs = 1
+	})
+
+	var loads []kayak.KLoad
+	for i := 0; i < 3; i++ {
+		loads = append(loads, kayak.KLoad{
+			From:    kayak.KAddress{0xCC, byte(i)},
+			Request: kayak.KRequest{Nonce: kayak.KNonce{byte(i)}, Payload: kayak.KData{byte(i)}},
+		})
+	}
+
+	for _, from := range keys[1:] {
+		suspect := kayak.KSuspect{Epoch: 1, Loads: loads}
+		network.queue = append(network.queue, packet{from: from, to: keys[0], payload: network.sign(from, suspect)})
+	}
+	network.run()
+
+	assert.Equal(t, kayak.KEpoch(1), network.nodes[keys[0]].Status().Epoch)
+	assert.Equal(t, 1, network.nodes[keys[0]].Footprint().Jobs)
+}
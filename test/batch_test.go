@@ -0,0 +1,204 @@
+package test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stratumn/kayak"
+	"github.com/stratumn/zmey"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const batchSize = 8
+
+func extractIndexesFromResponses(t *testing.T, responses []interface{}) []kayak.KIndex {
+	indexes := []kayak.KIndex{}
+
+	for i := range responses {
+		kreturn, ok := responses[i].(kayak.KReturn)
+		if !ok {
+			t.Fatalf("cannot convert %+v to KReturn", responses[i])
+		}
+		indexes = append(indexes, kreturn.Index)
+	}
+
+	return indexes
+}
+
+func countProposes(traces []interface{}) int {
+	var count int
+	for i := range traces {
+		if trace, ok := traces[i].(string); ok && strings.Contains(trace, "SEND KPropose") {
+			count++
+		}
+	}
+	return count
+}
+
+func TestKayakBatchFull(t *testing.T) {
+	z := zmey.NewZmey(&zmey.Config{
+	// Debug: true,
+	})
+
+	logs := make(map[int]*Storage)
+
+	for _, pid := range serverPids {
+		logs[pid] = &Storage{}
+		serverConfig := makeDefaultServerConfig(pid, logs[pid])
+		serverConfig.BatchSize = batchSize
+		serverConfig.BatchT = serverTimeout / 2
+		z.SetProcess(pid, NewKayakWrapper(serverConfig))
+	}
+
+	z.SetProcess(client1Pid, NewClientWrapper(makeDefaultClientConfig(client1Pid)))
+
+	messages := map[int][]kayak.KCall{
+		client1Pid: makeCalls(t, batchSize),
+	}
+
+	var ctx context.Context
+	var cancelF context.CancelFunc
+	var responses, traces map[int][]interface{}
+	var err error
+
+	// ========== ROUND 1 ==========
+	z.Inject(makeInjectF(messages))
+	ctx, cancelF = context.WithTimeout(context.Background(), 3*time.Second)
+	responses, traces, err = z.Round(ctx)
+	cancelF()
+
+	require.NoError(t, err)
+
+	printOut(t, responses, traces, logs, "R1")
+
+	// A single propose sent to every server
+	assert.Equal(t, len(serverKeys), countProposes(traces[server1Pid]))
+
+	assert.ElementsMatch(t, extractTagsFromMessages(t, messages[client1Pid]), extractTagsFromResponses(t, responses[client1Pid]))
+
+	indexesExpected := []kayak.KIndex{}
+	for i := 0; i < batchSize; i++ {
+		indexesExpected = append(indexesExpected, kayak.KIndex(i))
+	}
+	assert.ElementsMatch(t, indexesExpected, extractIndexesFromResponses(t, responses[client1Pid]))
+
+	assert.ElementsMatch(t, makeEntries(t, messages), logs[server1Pid].Entries)
+	for pid := range logs {
+		assert.Equal(t, logs[server1Pid].Entries, logs[pid].Entries)
+	}
+
+}
+
+// The test ensures that the batch limits are the leader's, the followers
+// configured with smaller ones still accept its batches
+func TestKayakBatchLeaderLimits(t *testing.T) {
+	z := zmey.NewZmey(&zmey.Config{
+	// Debug: true,
+	})
+
+	logs := make(map[int]*Storage)
+
+	for _, pid := range serverPids {
+		logs[pid] = &Storage{}
+		serverConfig := makeDefaultServerConfig(pid, logs[pid])
+		if pid == server1Pid {
+			serverConfig.BatchSize = batchSize
+			serverConfig.BatchT = serverTimeout / 2
+		} else {
+			serverConfig.BatchBytes = 1
+		}
+		z.SetProcess(pid, NewKayakWrapper(serverConfig))
+	}
+
+	z.SetProcess(client1Pid, NewClientWrapper(makeDefaultClientConfig(client1Pid)))
+
+	messages := map[int][]kayak.KCall{
+		client1Pid: makeCalls(t, batchSize),
+	}
+
+	z.Inject(makeInjectF(messages))
+	ctx, cancelF := context.WithTimeout(context.Background(), 3*time.Second)
+	responses, traces, err := z.Round(ctx)
+	cancelF()
+
+	require.NoError(t, err)
+
+	printOut(t, responses, traces, logs, "R1")
+
+	// A single propose sent to every server
+	assert.Equal(t, len(serverKeys), countProposes(traces[server1Pid]))
+
+	assert.ElementsMatch(t, extractTagsFromMessages(t, messages[client1Pid]), extractTagsFromResponses(t, responses[client1Pid]))
+
+	assert.ElementsMatch(t, makeEntries(t, messages), logs[server1Pid].Entries)
+	for pid := range logs {
+		assert.Equal(t, logs[server1Pid].Entries, logs[pid].Entries)
+	}
+}
+
+func TestKayakBatchDelay(t *testing.T) {
+	z := zmey.NewZmey(&zmey.Config{
+	// Debug: true,
+	})
+
+	logs := make(map[int]*Storage)
+
+	for _, pid := range serverPids {
+		logs[pid] = &Storage{}
+		serverConfig := makeDefaultServerConfig(pid, logs[pid])
+		serverConfig.BatchSize = batchSize
+		serverConfig.BatchT = serverTimeout / 2
+		z.SetProcess(pid, NewKayakWrapper(serverConfig))
+	}
+
+	z.SetProcess(client1Pid, NewClientWrapper(makeDefaultClientConfig(client1Pid)))
+
+	messages := map[int][]kayak.KCall{
+		client1Pid: makeCalls(t, batchSize/2),
+	}
+
+	var ctx context.Context
+	var cancelF context.CancelFunc
+	var responses, traces map[int][]interface{}
+	var err error
+
+	// ========== ROUND 1 ==========
+	z.Inject(makeInjectF(messages))
+	ctx, cancelF = context.WithTimeout(context.Background(), 3*time.Second)
+	responses, traces, err = z.Round(ctx)
+	cancelF()
+
+	require.NoError(t, err)
+
+	printOut(t, responses, traces, logs, "R1")
+
+	// The batch is not full, the leader waits for more jobs
+	assert.Empty(t, responses[client1Pid])
+	for pid := range logs {
+		assert.Empty(t, logs[pid].Entries)
+	}
+
+	// ========== ROUND 2 ==========
+	z.Tick(serverTimeout / 2)
+	ctx, cancelF = context.WithTimeout(context.Background(), 3*time.Second)
+	responses, traces, err = z.Round(ctx)
+	cancelF()
+
+	require.NoError(t, err)
+
+	printOut(t, responses, traces, logs, "R2")
+
+	assert.Equal(t, len(serverKeys), countProposes(traces[server1Pid]))
+
+	assert.ElementsMatch(t, extractTagsFromMessages(t, messages[client1Pid]), extractTagsFromResponses(t, responses[client1Pid]))
+
+	assert.ElementsMatch(t, makeEntries(t, messages), logs[server1Pid].Entries)
+	for pid := range logs {
+		assert.Equal(t, logs[server1Pid].Entries, logs[pid].Entries)
+	}
+
+}
@@ -0,0 +1,206 @@
+package test
+
+import (
+	"testing"
+
+	"github.com/stratumn/kayak"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// partitionedNetwork cuts off the server and drops the proposes while the
+// leaders are meant to fail, it records the proposers of each epoch
+type partitionedNetwork struct {
+	partitioned  kayak.KAddress
+	cut          bool
+	dropProposes bool
+	proposers    map[kayak.KEpoch]kayak.KAddress
+}
+
+func (n *partitionedNetwork) tamperF(p packet) (packet, bool) {
+	if n.cut && (p.from == n.partitioned || p.to == n.partitioned) {
+		return p, false
+	}
+	if s, ok := p.payload.(kayak.KSigned); ok {
+		if propose, ok := s.Payload.(kayak.KPropose); ok {
+			if n.dropProposes {
+				return p, false
+			}
+			n.proposers[propose.Epoch] = p.from
+		}
+	}
+	return p, true
+}
+
+// failLeaders makes the connected servers suspect their leaders, which fail
+// to propose, until they reach the epoch. The jobs are rescheduled by each
+// leader change, so they time out after two timeouts.
+func failLeaders(t *testing.T, network *signedNetwork, keys []kayak.KAddress, epoch kayak.KEpoch) {
+	for network.nodes[keys[0]].Status().Epoch < epoch {
+		before := network.nodes[keys[0]].Status().Epoch
+		for i := 0; i < 2; i++ {
+			for _, key := range keys {
+				network.nodes[key].Tick(serverTimeout)
+			}
+			network.run()
+		}
+		require.Equal(t, before+1, network.nodes[keys[0]].Status().Epoch)
+	}
+}
+
+// The test ensures that a server which missed several leader changes in a
+// partition jumps to the epoch of the heads, although no round is decided
+func TestCatchUpEpochByHeads(t *testing.T) {
+	network, keys := makeSignedNetwork(t, 4)
+	partition := &partitionedNetwork{
+		partitioned:  keys[3],
+		cut:          true,
+		dropProposes: true,
+		proposers:    make(map[kayak.KEpoch]kayak.KAddress),
+	}
+	network.tamperF = partition.tamperF
+
+	calls := makeCalls(t, 1)
+	network.nodes[keys[0]].ReceiveCall(calls[0])
+	network.run()
+
+	// The leaders of the epochs 0 to 3 fail, the last one is partitioned
+	failLeaders(t, network, keys[:3], 4)
+
+	for _, key := range keys {
+		require.Empty(t, network.logs[key].Entries)
+	}
+	require.Equal(t, kayak.KEpoch(0), network.nodes[keys[3]].Status().Epoch)
+
+	partition.cut = false
+	network.nodes[keys[3]].Tick(serverTimeout)
+	network.run()
+
+	assert.Equal(t, kayak.KEpoch(4), network.nodes[keys[3]].Status().Epoch)
+	for _, key := range keys {
+		require.Empty(t, network.logs[key].Entries)
+	}
+
+	// The leader of the epoch proposes again once the proposes are delivered
+	partition.dropProposes = false
+	for _, key := range keys {
+		network.nodes[key].Tick(serverTimeout)
+	}
+	network.run()
+
+	entriesExpected := makeEntries(t, map[int][]kayak.KCall{0: calls})
+	for _, key := range keys {
+		assert.Equal(t, entriesExpected, network.logs[key].Entries)
+	}
+}
+
+// The test ensures that a server which missed several leader changes in a
+// partition jumps to the epoch of the suspects it receives, and takes part
+// in the next leader change as the new leader
+func TestCatchUpEpochBySuspects(t *testing.T) {
+	network, keys := makeSignedNetwork(t, 4)
+	partition := &partitionedNetwork{
+		partitioned:  keys[3],
+		cut:          true,
+		dropProposes: true,
+		proposers:    make(map[kayak.KEpoch]kayak.KAddress),
+	}
+	network.tamperF = partition.tamperF
+
+	calls := makeCalls(t, 1)
+	network.nodes[keys[0]].ReceiveCall(calls[0])
+	network.run()
+
+	failLeaders(t, network, keys[:3], 2)
+
+	require.Equal(t, kayak.KEpoch(0), network.nodes[keys[3]].Status().Epoch)
+
+	// The leader of the epoch 2 fails too, the partitioned server receives the
+	// suspects for the epoch 3 which it is the leader of
+	partition.cut = false
+	partition.dropProposes = false
+	failLeaders(t, network, keys[:3], 3)
+
+	assert.Equal(t, kayak.KEpoch(3), network.nodes[keys[3]].Status().Epoch)
+	assert.Equal(t, keys[3], partition.proposers[3])
+
+	entriesExpected := makeEntries(t, map[int][]kayak.KCall{0: calls})
+	for _, key := range keys {
+		assert.Equal(t, entriesExpected, network.logs[key].Entries)
+	}
+}
+
+// The test ensures that a server which jumps to the epoch of the heads takes
+// the batches to carry from the view of the leader, and does not write in
+// the epoch before it gets the view
+func TestCatchUpEpochView(t *testing.T) {
+	network, keys := makeSignedNetwork(t, 4)
+	partition := &partitionedNetwork{
+		partitioned:  keys[3],
+		cut:          true,
+		dropProposes: true,
+		proposers:    make(map[kayak.KEpoch]kayak.KAddress),
+	}
+
+	var needs int
+	var writes []kayak.KEpoch
+	dropViews := true
+	network.tamperF = func(p packet) (packet, bool) {
+		if p, deliver := partition.tamperF(p); !deliver {
+			return p, false
+		}
+		switch payload := p.payload.(type) {
+		case kayak.KNewView:
+			return p, p.to != keys[3] || !dropViews
+		case kayak.KNeedView:
+			needs++
+		case kayak.KSigned:
+			if write, ok := payload.Payload.(kayak.KWrite); ok && p.from == keys[3] {
+				writes = append(writes, write.Epoch)
+			}
+		}
+		return p, true
+	}
+
+	calls := makeCalls(t, 1)
+	network.nodes[keys[0]].ReceiveCall(calls[0])
+	network.run()
+
+	failLeaders(t, network, keys[:3], 4)
+
+	partition.cut = false
+	network.nodes[keys[3]].Tick(serverTimeout)
+	network.run()
+
+	require.Equal(t, kayak.KEpoch(4), network.nodes[keys[3]].Status().Epoch)
+	assert.True(t, needs > 0)
+
+	// The others decide without the server which has not the view
+	partition.dropProposes = false
+	for _, key := range keys {
+		network.nodes[key].Tick(serverTimeout)
+	}
+	network.run()
+
+	assert.Empty(t, writes)
+	entriesExpected := makeEntries(t, map[int][]kayak.KCall{0: calls})
+	for _, key := range keys[:3] {
+		assert.Equal(t, entriesExpected, network.logs[key].Entries)
+	}
+
+	// The view is delivered when asked for again, then the server writes
+	dropViews = false
+	network.nodes[keys[3]].Tick(serverTimeout)
+	network.run()
+
+	calls = append(calls, makeCalls(t, 1)...)
+	network.nodes[keys[0]].ReceiveCall(calls[1])
+	network.run()
+
+	assert.NotEmpty(t, writes)
+	entriesExpected = makeEntries(t, map[int][]kayak.KCall{0: calls})
+	for _, key := range keys {
+		assert.Equal(t, entriesExpected, network.logs[key].Entries)
+	}
+}
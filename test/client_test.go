@@ -214,3 +214,67 @@ func TestClientWithKayak(t *testing.T) {
 	}
 
 }
+
+func TestClientRejectedNotAllowed(t *testing.T) {
+
+	z := zmey.NewZmey(&zmey.Config{
+	// Debug: true,
+	})
+
+	logs := make(map[int]*Storage)
+
+	for _, pid := range serverPids {
+		logs[pid] = &Storage{}
+		serverConfig := makeDefaultServerConfig(pid, logs[pid])
+		serverConfig.AllowExternal = false
+		z.SetProcess(pid, NewKayakWrapper(serverConfig))
+	}
+
+	z.SetProcess(client1Pid, NewClientWrapper(makeDefaultClientConfig(client1Pid)))
+
+	messages := map[int][]kayak.KCall{
+		server1Pid: makeCalls(t, 2),
+		client1Pid: makeCalls(t, 2),
+	}
+
+	z.Inject(makeInjectF(messages))
+
+	ctx, cancelF := context.WithTimeout(context.Background(), 3*time.Second)
+	responses, traces, err := z.Round(ctx)
+	cancelF()
+
+	require.NoError(t, err)
+
+	printOut(t, responses, traces, logs, "")
+
+	assert.ElementsMatch(t,
+		extractTagsFromMessages(t, messages[client1Pid]),
+		extractTagsFromResponses(t, responses[client1Pid]))
+
+	for i := range responses[client1Pid] {
+		kreturn := responses[client1Pid][i].(kayak.KReturn)
+		assert.False(t, kreturn.Timeout)
+		assert.Equal(t, kayak.ErrorReasonNotAllowed, kreturn.Error)
+	}
+
+	expectedEntries := makeEntries(t, map[int][]kayak.KCall{server1Pid: messages[server1Pid]})
+
+	assert.ElementsMatch(t, expectedEntries, logs[server1Pid].Entries)
+	for pid := range logs {
+		assert.Equal(t, logs[server1Pid].Entries, logs[pid].Entries)
+	}
+
+}
+
+func TestClientRejectedAhead(t *testing.T) {
+	var sent []sentMessage
+	k := makeStandaloneKayak(server2Pid, &Storage{}, &Journal{}, &sent)
+	sent = nil
+
+	request := kayak.KRequest{Nonce: kayak.KNonce{0x01}, Payload: kayak.KData{0x01}, Index: 5}
+	k.ReceiveNet(client1Key, request)
+
+	require.Len(t, sent, 1)
+	assert.Equal(t, client1Key, sent[0].to)
+	assert.Equal(t, kayak.KResponse{Nonce: request.Nonce, Error: kayak.ErrorReasonAhead}, sent[0].payload)
+}
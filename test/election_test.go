@@ -0,0 +1,70 @@
+package test
+
+import (
+	"testing"
+
+	"github.com/stratumn/kayak"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// reverseLeaderPolicy elects the processes in turn from the last one
+type reverseLeaderPolicy struct{}
+
+func (reverseLeaderPolicy) Leader(epoch kayak.KEpoch, keys []kayak.KAddress) kayak.KAddress {
+	return keys[len(keys)-1-int(uint(epoch)%uint(len(keys)))]
+}
+
+// The test ensures that the leader is elected by the policy of the
+// configuration, including after a leader change
+func TestLeaderPolicy(t *testing.T) {
+	network, keys := makeSignedNetwork(t, 4, func(c *kayak.KServerConfig) {
+		c.LeaderPolicy = reverseLeaderPolicy{}
+	})
+
+	proposers := make(map[kayak.KEpoch]kayak.KAddress)
+	network.tamperF = func(p packet) (packet, bool) {
+		if s, ok := p.payload.(kayak.KSigned); ok {
+			if propose, ok := s.Payload.(kayak.KPropose); ok {
+				proposers[propose.Epoch] = p.from
+			}
+		}
+		return p, true
+	}
+
+	calls := makeCalls(t, 2)
+	network.nodes[keys[0]].ReceiveCall(calls[0])
+	network.run()
+
+	assert.Equal(t, map[kayak.KEpoch]kayak.KAddress{0: keys[3]}, proposers)
+
+	// The leader of the first epoch fails
+	network.tamperF = func(p packet) (packet, bool) {
+		if p.from == keys[3] || p.to == keys[3] {
+			return p, false
+		}
+		if s, ok := p.payload.(kayak.KSigned); ok {
+			if propose, ok := s.Payload.(kayak.KPropose); ok {
+				proposers[propose.Epoch] = p.from
+			}
+		}
+		return p, true
+	}
+
+	network.nodes[keys[0]].ReceiveCall(calls[1])
+	network.run()
+
+	for _, key := range keys[:3] {
+		network.nodes[key].Tick(serverTimeout)
+	}
+	network.run()
+
+	assert.Equal(t, map[kayak.KEpoch]kayak.KAddress{0: keys[3], 1: keys[2]}, proposers)
+
+	entriesExpected := makeEntries(t, map[int][]kayak.KCall{0: calls})
+	for _, key := range keys[:3] {
+		require.Len(t, network.logs[key].Entries, len(entriesExpected))
+		assert.Equal(t, entriesExpected, network.logs[key].Entries)
+	}
+}
@@ -0,0 +1,135 @@
+package test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"testing"
+
+	"github.com/stratumn/kayak"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// The test ensures that 3 servers out of 5 decide and answer the client in
+// the crash fault model, which needs 4 of them in the Byzantine model
+func TestCrashFaultModel(t *testing.T) {
+	for _, model := range []kayak.KFaultModel{kayak.FaultModelCrash, kayak.FaultModelByzantine} {
+		var returns []kayak.KReturn
+		network, keys := makeSignedNetwork(t, 5, func(c *kayak.KServerConfig) {
+			c.FaultModel = model
+			c.ReturnF = func(payload interface{}) {
+				if r, ok := payload.(kayak.KReturn); ok {
+					returns = append(returns, r)
+				}
+			}
+		})
+
+		network.tamperF = func(p packet) (packet, bool) {
+			for _, key := range keys[3:] {
+				if p.from == key || p.to == key {
+					return p, false
+				}
+			}
+			return p, true
+		}
+
+		calls := makeCalls(t, 2)
+		for i := range calls {
+			network.nodes[keys[0]].ReceiveCall(calls[i])
+		}
+		network.run()
+
+		if model == kayak.FaultModelByzantine {
+			for _, key := range keys {
+				assert.Empty(t, network.logs[key].Entries)
+			}
+			assert.Empty(t, returns)
+			continue
+		}
+
+		entriesExpected := makeEntries(t, map[int][]kayak.KCall{0: calls})
+		for _, key := range keys[:3] {
+			assert.Equal(t, entriesExpected, network.logs[key].Entries)
+		}
+		require.Len(t, returns, len(calls))
+	}
+}
+
+// The test ensures that 2 servers decide only together, and that the lagging
+// one catches up with the other one
+func TestTwoProcesses(t *testing.T) {
+	for _, model := range []kayak.KFaultModel{kayak.FaultModelCrash, kayak.FaultModelByzantine} {
+		network, keys := makeSignedNetwork(t, 2, func(c *kayak.KServerConfig) {
+			c.FaultModel = model
+		})
+
+		failed := true
+		network.tamperF = func(p packet) (packet, bool) {
+			if failed && (p.from == keys[1] || p.to == keys[1]) {
+				return p, false
+			}
+			return p, true
+		}
+
+		calls := makeCalls(t, 1)
+		network.nodes[keys[0]].ReceiveCall(calls[0])
+		network.run()
+
+		for _, key := range keys {
+			require.Empty(t, network.logs[key].Entries, "%#v", model)
+		}
+
+		failed = false
+		moreCalls := makeCalls(t, 1)
+		network.nodes[keys[0]].ReceiveCall(moreCalls[0])
+		network.run()
+
+		for _, key := range keys {
+			network.nodes[key].Tick(serverTimeout)
+		}
+		network.run()
+
+		entriesExpected := makeEntries(t, map[int][]kayak.KCall{0: calls, 1: moreCalls})
+		for _, key := range keys {
+			assert.ElementsMatch(t, entriesExpected, network.logs[key].Entries, "%#v", model)
+		}
+	}
+}
+
+// The test ensures that without signatures f+1 heads and confirms are not
+// enough in the Byzantine model, as a Byzantine server may send them on
+// behalf of another one
+func TestUnsignedSyncQuorum(t *testing.T) {
+	network, keys := makeSignedNetwork(t, 4, func(c *kayak.KServerConfig) {
+		c.PrivateKey = nil
+	})
+
+	data := kayak.KData{0xDE, 0xAD}
+	origin := kayak.KOrigin{Nonce: kayak.KNonce{0xFF}}
+	var buffer bytes.Buffer
+	require.NoError(t, json.NewEncoder(&buffer).Encode(kayak.KRequest{Nonce: origin.Nonce, Payload: data}))
+	buzz := kayak.KHash(sha256.Sum256(buffer.Bytes()))
+	var base kayak.KHash
+	dataHash := kayak.KHash(sha256.Sum256(append(base[:], data...)))
+	buzzHash := kayak.KHash(sha256.Sum256(append(base[:], buzz[:]...)))
+
+	// The Byzantine server keys[0] also speaks for keys[1]
+	for _, from := range keys[:2] {
+		network.queue = append(network.queue, packet{from: from, to: keys[3], payload: kayak.KHead{Round: 1}})
+	}
+	network.queue = append(network.queue,
+		packet{from: keys[0], to: keys[3], payload: kayak.KChunk{
+			Last:    1,
+			Data:    []kayak.KData{data},
+			Buzz:    []kayak.KHash{buzz},
+			Origins: []kayak.KOrigin{origin},
+		}},
+		packet{from: keys[1], to: keys[3], payload: kayak.KConfirm{Last: 1, DataHash: dataHash, BuzzHash: buzzHash}},
+	)
+	network.run()
+
+	assert.Equal(t, kayak.KRound(0), network.nodes[keys[3]].Status().Round)
+	assert.Empty(t, network.logs[keys[3]].Entries)
+}
@@ -0,0 +1,139 @@
+package test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stratumn/zmey"
+
+	"github.com/stratumn/kayak"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func countRequests(traces []interface{}) int {
+	var count int
+	for i := range traces {
+		if trace, ok := traces[i].(string); ok && strings.Contains(trace, "SEND KRequest") {
+			count++
+		}
+	}
+	return count
+}
+
+// The test ensures that the client sends each request to a single server,
+// which forwards it to the leader
+func TestForwardRequests(t *testing.T) {
+	z := zmey.NewZmey(&zmey.Config{
+	// Debug: true,
+	})
+
+	logs := make(map[int]*Storage)
+
+	for _, pid := range serverPids {
+		logs[pid] = &Storage{}
+		serverConfig := makeDefaultServerConfig(pid, logs[pid])
+		serverConfig.ForwardRequests = true
+		z.SetProcess(pid, NewKayakWrapper(serverConfig))
+	}
+
+	clientConfig := makeDefaultClientConfig(client1Pid)
+	clientConfig.ForwardT = serverTimeout
+	z.SetProcess(client1Pid, NewClientWrapper(clientConfig))
+
+	messages := map[int][]kayak.KCall{
+		client1Pid: makeCalls(t, 4),
+	}
+
+	z.Inject(makeInjectF(messages))
+	ctx, cancelF := context.WithTimeout(context.Background(), 3*time.Second)
+	responses, traces, err := z.Round(ctx)
+	cancelF()
+
+	require.NoError(t, err)
+
+	printOut(t, responses, traces, logs, "R1")
+
+	assert.Equal(t, len(messages[client1Pid]), countRequests(traces[client1Pid]))
+
+	assert.ElementsMatch(t, extractTagsFromMessages(t, messages[client1Pid]), extractTagsFromResponses(t, responses[client1Pid]))
+
+	assert.ElementsMatch(t, makeEntries(t, messages), logs[server1Pid].Entries)
+	for pid := range logs {
+		assert.Equal(t, logs[server1Pid].Entries, logs[pid].Entries)
+	}
+}
+
+// The test ensures that the client broadcasts the request when the server
+// it was sent to does not answer in time
+func TestForwardRequestsFallback(t *testing.T) {
+	z := zmey.NewZmey(&zmey.Config{
+	// Debug: true,
+	})
+
+	logs := make(map[int]*Storage)
+
+	for _, pid := range serverPids {
+		logs[pid] = &Storage{}
+		serverConfig := makeDefaultServerConfig(pid, logs[pid])
+		serverConfig.ForwardRequests = true
+		z.SetProcess(pid, NewKayakWrapper(serverConfig))
+	}
+
+	clientConfig := makeDefaultClientConfig(client1Pid)
+	clientConfig.ForwardT = serverTimeout
+	z.SetProcess(client1Pid, NewClientWrapper(clientConfig))
+
+	// The first server is picked by the client, but it does not receive
+	// anything from it
+	z.Filter(func(from, to int) bool {
+		return !(from == client1Pid && to == server1Pid)
+	})
+
+	messages := map[int][]kayak.KCall{
+		client1Pid: makeCalls(t, 1),
+	}
+
+	var ctx context.Context
+	var cancelF context.CancelFunc
+	var responses, traces map[int][]interface{}
+	var err error
+
+	// ========== ROUND 1 ==========
+	z.Inject(makeInjectF(messages))
+	ctx, cancelF = context.WithTimeout(context.Background(), 3*time.Second)
+	responses, traces, err = z.Round(ctx)
+	cancelF()
+
+	require.NoError(t, err)
+
+	printOut(t, responses, traces, logs, "R1")
+
+	assert.Equal(t, 1, countRequests(traces[client1Pid]))
+	assert.Empty(t, responses[client1Pid])
+	for pid := range logs {
+		assert.Empty(t, logs[pid].Entries)
+	}
+
+	// ========== ROUND 2 ==========
+	z.Tick(serverTimeout)
+	ctx, cancelF = context.WithTimeout(context.Background(), 3*time.Second)
+	responses, traces, err = z.Round(ctx)
+	cancelF()
+
+	require.NoError(t, err)
+
+	printOut(t, responses, traces, logs, "R2")
+
+	assert.Equal(t, len(serverKeys), countRequests(traces[client1Pid]))
+
+	assert.ElementsMatch(t, extractTagsFromMessages(t, messages[client1Pid]), extractTagsFromResponses(t, responses[client1Pid]))
+
+	assert.ElementsMatch(t, makeEntries(t, messages), logs[server1Pid].Entries)
+	for pid := range logs {
+		assert.Equal(t, logs[server1Pid].Entries, logs[pid].Entries)
+	}
+}
@@ -0,0 +1,48 @@
+package test
+
+import (
+	"testing"
+
+	"github.com/stratumn/kayak"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJobsProposedInArrivalOrder(t *testing.T) {
+	const jobsN = 16
+
+	var sent []sentMessage
+
+	config := makeDefaultServerConfig(server1Pid, &Storage{})
+	config.BatchSize = jobsN
+	config.BatchT = serverTimeout
+	config.SendF = func(to kayak.KAddress, payload interface{}) {
+		sent = append(sent, sentMessage{to: to, payload: payload})
+	}
+	config.ReturnF = func(payload interface{}) {}
+	config.TraceF = func(payload interface{}) {}
+	config.ErrorF = func(err error) {}
+
+	k := kayak.NewKayak(config)
+	k.Start()
+
+	calls := makeCalls(t, jobsN)
+	for i := range calls {
+		k.ReceiveNet(client1Key, kayak.KRequest{Payload: calls[i].Payload})
+		k.Tick(1)
+	}
+
+	var proposes []kayak.KPropose
+	for i := range sent {
+		if propose, ok := sent[i].payload.(kayak.KPropose); ok {
+			proposes = append(proposes, propose)
+		}
+	}
+
+	require.Len(t, proposes, len(serverKeys))
+	require.Len(t, proposes[0].Jobs, jobsN)
+	for i := range calls {
+		assert.Equal(t, kayak.KData(calls[i].Payload), proposes[0].Jobs[i].Request.Payload)
+	}
+}
@@ -0,0 +1,18 @@
+package test
+
+import (
+	"github.com/stratumn/kayak"
+)
+
+type Journal struct {
+	Votes []kayak.KVote
+}
+
+func (j *Journal) Record(vote kayak.KVote) error {
+	j.Votes = append(j.Votes, vote)
+	return nil
+}
+
+func (j *Journal) Load() ([]kayak.KVote, error) {
+	return j.Votes, nil
+}
@@ -0,0 +1,89 @@
+package test
+
+import (
+	"testing"
+
+	"github.com/stratumn/kayak"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type sentMessage struct {
+	to      kayak.KAddress
+	payload interface{}
+}
+
+func makeStandaloneKayak(pid int, storage *Storage, journal *Journal, sent *[]sentMessage) *kayak.Kayak {
+	config := makeDefaultServerConfig(pid, storage)
+	config.Journal = journal
+	config.SendF = func(to kayak.KAddress, payload interface{}) {
+		*sent = append(*sent, sentMessage{to: to, payload: payload})
+	}
+	config.ReturnF = func(payload interface{}) {}
+	config.TraceF = func(payload interface{}) {}
+	config.ErrorF = func(err error) {}
+
+	k := kayak.NewKayak(config)
+	k.Start()
+	return k
+}
+
+func extractWrites(sent []sentMessage) []kayak.KWrite {
+	var writes []kayak.KWrite
+	for i := range sent {
+		if write, ok := sent[i].payload.(kayak.KWrite); ok {
+			writes = append(writes, write)
+		}
+	}
+	return writes
+}
+
+func TestJournalNoEquivocationAfterRestart(t *testing.T) {
+	journal := &Journal{}
+	calls := makeCalls(t, 2)
+
+	jobA := kayak.KJob{From: client1Key, Request: kayak.KRequest{Payload: calls[0].Payload}}
+	jobB := kayak.KJob{From: client1Key, Request: kayak.KRequest{Payload: calls[1].Payload}}
+
+	// ========== BEFORE CRASH ==========
+	var sent []sentMessage
+	k := makeStandaloneKayak(server2Pid, &Storage{}, journal, &sent)
+	k.ReceiveNet(server1Key, kayak.KPropose{Round: 0, Epoch: 0, Jobs: []kayak.KJob{jobA}})
+
+	writes := extractWrites(sent)
+	require.Len(t, writes, len(serverKeys))
+	hashA := writes[0].Hash
+
+	// ========== RESTART, DIFFERENT PROPOSE ==========
+	sent = nil
+	k = makeStandaloneKayak(server2Pid, &Storage{}, journal, &sent)
+	k.ReceiveNet(server1Key, kayak.KPropose{Round: 0, Epoch: 0, Jobs: []kayak.KJob{jobB}})
+
+	assert.Empty(t, extractWrites(sent))
+
+	// ========== RESTART, SAME PROPOSE ==========
+	sent = nil
+	k = makeStandaloneKayak(server2Pid, &Storage{}, journal, &sent)
+	k.ReceiveNet(server1Key, kayak.KPropose{Round: 0, Epoch: 0, Jobs: []kayak.KJob{jobA}})
+
+	writes = extractWrites(sent)
+	require.Len(t, writes, len(serverKeys))
+	assert.Equal(t, hashA, writes[0].Hash)
+}
+
+func TestJournalRestoreEpoch(t *testing.T) {
+	journal := &Journal{
+		Votes: []kayak.KVote{
+			{Kind: kayak.VoteWrite, Round: 0, Epoch: 2},
+			{Kind: kayak.VoteAccept, Round: 0, Epoch: 2},
+			{Kind: kayak.VoteSuspect, Round: 0, Epoch: 4},
+		},
+	}
+
+	var sent []sentMessage
+	k := makeStandaloneKayak(server2Pid, &Storage{}, journal, &sent)
+
+	assert.Equal(t, kayak.KEpoch(3), k.Status().Epoch)
+	assert.Equal(t, server4Key, k.Status().Leader)
+}
@@ -403,3 +403,161 @@ func TestKayakUnballancedSuspects(t *testing.T) {
 	assert.Equal(t, logs[server2Pid].Entries, logs[server4Pid].Entries)
 
 }
+
+// The test ensures that a batch prepared in an epoch, which may have been
+// decided somewhere, is proposed again by the next leader before any other
+// batch. The accepts are lost, so the batch is written by all the servers
+// but not decided.
+func TestKayakViewChangeCarriesPrepared(t *testing.T) {
+	network, keys := makeSignedNetwork(t, 4)
+
+	network.tamperF = func(p packet) (packet, bool) {
+		if s, ok := p.payload.(kayak.KSigned); ok {
+			if _, ok := s.Payload.(kayak.KAccept); ok {
+				return p, false
+			}
+		}
+		return p, true
+	}
+
+	prepared := makeCalls(t, 1)
+	network.nodes[keys[0]].ReceiveCall(prepared[0])
+	network.run()
+
+	// The pipeline is full, so these are not proposed before the leader change
+	calls := makeCalls(t, 7)
+	for i := range calls {
+		network.nodes[keys[0]].ReceiveCall(calls[i])
+	}
+	network.run()
+
+	for _, key := range keys {
+		require.Empty(t, network.logs[key].Entries)
+	}
+
+	var proposes []kayak.KPropose
+	network.tamperF = func(p packet) (packet, bool) {
+		if s, ok := p.payload.(kayak.KSigned); ok {
+			if propose, ok := s.Payload.(kayak.KPropose); ok && p.from == keys[1] && p.to == keys[1] {
+				proposes = append(proposes, propose)
+			}
+		}
+		return p, true
+	}
+
+	for _, key := range keys {
+		network.nodes[key].Tick(serverTimeout)
+	}
+	network.run()
+
+	require.NotEmpty(t, proposes)
+	assert.Equal(t, kayak.KEpoch(1), proposes[0].Epoch)
+	require.Len(t, proposes[0].Jobs, 1)
+	assert.Equal(t, prepared[0].Payload, proposes[0].Jobs[0].Request.Payload)
+
+	entriesExpected := makeEntries(t, map[int][]kayak.KCall{0: prepared, 1: calls})
+	for _, key := range keys {
+		require.Len(t, network.logs[key].Entries, len(entriesExpected))
+		assert.Equal(t, []byte(prepared[0].Payload), network.logs[key].Entries[0])
+		assert.ElementsMatch(t, entriesExpected, network.logs[key].Entries)
+	}
+}
+
+// The test ensures that without signatures a suspect cannot carry a batch
+// into the next epoch, as the batch cannot be proven prepared
+func TestKayakViewChangeUnsignedPrepared(t *testing.T) {
+	network, keys := makeSignedNetwork(t, 4, func(c *kayak.KServerConfig) {
+		c.PrivateKey = nil
+	})
+
+	forged := kayak.KJob{
+		From:    keys[3],
+		Request: kayak.KRequest{Nonce: kayak.KNonce{0xFF}, Payload: kayak.KData{0xFF, 0xFF}},
+	}
+
+	// The leader of the epoch 0 fails to propose, a Byzantine server
+	// reports a forged batch prepared in a later epoch and makes the suspect
+	// quorum of the next leader
+	network.tamperF = func(p packet) (packet, bool) {
+		switch payload := p.payload.(type) {
+		case kayak.KPropose:
+			return p, payload.Epoch > 0
+		case kayak.KSuspect:
+			if p.from == keys[2] && p.to == keys[1] {
+				return p, false
+			}
+			if p.from == keys[3] {
+				payload.Prepared = []kayak.KPrepared{{Round: 0, Epoch: 5, Jobs: []kayak.KJob{forged}}}
+				p.payload = payload
+			}
+		}
+		return p, true
+	}
+
+	calls := makeCalls(t, 1)
+	network.nodes[keys[0]].ReceiveCall(calls[0])
+	network.run()
+
+	for i := 0; i < 2; i++ {
+		for _, key := range keys {
+			network.nodes[key].Tick(serverTimeout)
+		}
+		network.run()
+	}
+
+	entriesExpected := makeEntries(t, map[int][]kayak.KCall{0: calls})
+	for _, key := range keys {
+		assert.Equal(t, entriesExpected, network.logs[key].Entries)
+	}
+}
+
+// The test ensures that a follower which missed the new view does not write
+// in the epoch until it gets the view from the leader
+func TestKayakNewViewNeeded(t *testing.T) {
+	network, keys := makeSignedNetwork(t, 4)
+
+	// The leader of the epoch 0 fails to propose, the writes of a follower
+	// are lost so the one which misses the view is needed for the quorum
+	var needs []kayak.KNeedView
+	network.tamperF = func(p packet) (packet, bool) {
+		switch payload := p.payload.(type) {
+		case kayak.KNewView:
+			return p, p.to != keys[3] || len(needs) > 0
+		case kayak.KNeedView:
+			needs = append(needs, payload)
+		case kayak.KSigned:
+			switch signed := payload.Payload.(type) {
+			case kayak.KPropose:
+				return p, signed.Epoch > 0
+			case kayak.KWrite:
+				return p, p.from != keys[2]
+			}
+		}
+		return p, true
+	}
+
+	calls := makeCalls(t, 1)
+	network.nodes[keys[0]].ReceiveCall(calls[0])
+	network.run()
+
+	for _, key := range keys {
+		network.nodes[key].Tick(serverTimeout)
+	}
+	network.run()
+
+	for _, key := range keys {
+		require.Equal(t, kayak.KEpoch(1), network.nodes[key].Status().Epoch)
+		require.Empty(t, network.logs[key].Entries)
+	}
+	require.Empty(t, needs)
+
+	network.nodes[keys[3]].Tick(serverTimeout)
+	network.run()
+
+	assert.Equal(t, []kayak.KNeedView{{Epoch: 1}}, needs)
+
+	entriesExpected := makeEntries(t, map[int][]kayak.KCall{0: calls})
+	for _, key := range keys {
+		assert.Equal(t, entriesExpected, network.logs[key].Entries)
+	}
+}
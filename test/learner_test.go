@@ -0,0 +1,110 @@
+package test
+
+import (
+	"testing"
+
+	"github.com/stratumn/kayak"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func makeLearnerCommand(magic [32]byte, key kayak.KAddress) []byte {
+	return append(magic[:], key[:]...)
+}
+
+// The test ensures that the learner receives the decided entries pushed by
+// the voters and catches up by sync after missing them, without ever voting
+func TestLearner(t *testing.T) {
+	network, keys := makeSignedNetwork(t, 5, func(c *kayak.KServerConfig) {
+		c.Learners = c.Keys[4:]
+		c.Keys = c.Keys[:4]
+	})
+	learner := keys[4]
+
+	var disconnected bool
+	network.tamperF = func(p packet) (packet, bool) {
+		if p.from == learner {
+			if s, ok := p.payload.(kayak.KSigned); ok {
+				switch s.Payload.(type) {
+				case kayak.KPropose, kayak.KWrite, kayak.KAccept, kayak.KSuspect:
+					t.Errorf("learner sent %#v", s.Payload)
+				}
+			}
+		}
+		if disconnected && (p.from == learner || p.to == learner) {
+			return p, false
+		}
+		return p, true
+	}
+
+	calls := makeCalls(t, 2)
+	for i := range calls {
+		network.nodes[keys[0]].ReceiveCall(calls[i])
+	}
+	network.run()
+
+	entriesExpected := makeEntries(t, map[int][]kayak.KCall{0: calls})
+	for _, key := range keys {
+		assert.Equal(t, entriesExpected, network.logs[key].Entries)
+	}
+
+	disconnected = true
+	moreCalls := makeCalls(t, 2)
+	for i := range moreCalls {
+		network.nodes[keys[0]].ReceiveCall(moreCalls[i])
+	}
+	network.run()
+
+	assert.Equal(t, entriesExpected, network.logs[learner].Entries)
+
+	disconnected = false
+	network.nodes[learner].Tick(serverTimeout)
+	network.run()
+
+	entriesExpected = append(entriesExpected, makeEntries(t, map[int][]kayak.KCall{0: moreCalls})...)
+	for _, key := range keys {
+		assert.Equal(t, entriesExpected, network.logs[key].Entries)
+	}
+
+	// The learner is not counted in the quorum
+	assert.Equal(t, keys[:4], network.nodes[learner].Status().Keys)
+}
+
+// The test ensures that the learners are added and removed by the
+// reconfiguration commands
+func TestLearnerReconfiguration(t *testing.T) {
+	network, keys := makeSignedNetwork(t, 5, func(c *kayak.KServerConfig) {
+		c.Keys = c.Keys[:4]
+	})
+	learner := keys[4]
+
+	addLearner := kayak.KCall{Payload: makeLearnerCommand(kayak.MagicAddLearner, learner)}
+	network.nodes[keys[0]].ReceiveCall(addLearner)
+	network.run()
+
+	// The batch adding the learner is pushed to it
+	require.Len(t, network.logs[learner].Entries, 1)
+
+	calls := makeCalls(t, 1)
+	network.nodes[keys[0]].ReceiveCall(calls[0])
+	network.run()
+
+	entriesExpected := append([][]byte{addLearner.Payload}, calls[0].Payload)
+	assert.Equal(t, entriesExpected, network.logs[learner].Entries)
+
+	removeLearner := kayak.KCall{Payload: makeLearnerCommand(kayak.MagicRemoveLearner, learner)}
+	network.nodes[keys[0]].ReceiveCall(removeLearner)
+	network.run()
+
+	moreCalls := makeCalls(t, 1)
+	network.nodes[keys[0]].ReceiveCall(moreCalls[0])
+	network.run()
+
+	network.nodes[learner].Tick(serverTimeout)
+	network.run()
+
+	// The batch removing the learner is not pushed to it any more
+	assert.Equal(t, entriesExpected, network.logs[learner].Entries)
+	assert.Len(t, network.logs[keys[0]].Entries, 4)
+}
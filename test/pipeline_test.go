@@ -0,0 +1,152 @@
+package test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stratumn/kayak"
+	"github.com/stratumn/zmey"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const pipelineDepth = 4
+
+func TestKayakPipeline(t *testing.T) {
+	z := zmey.NewZmey(&zmey.Config{
+	// Debug: true,
+	})
+
+	logs := make(map[int]*Storage)
+
+	for _, pid := range serverPids {
+		logs[pid] = &Storage{}
+		serverConfig := makeDefaultServerConfig(pid, logs[pid])
+		serverConfig.PipelineDepth = pipelineDepth
+		z.SetProcess(pid, NewKayakWrapper(serverConfig))
+	}
+
+	z.SetProcess(client1Pid, NewClientWrapper(makeDefaultClientConfig(client1Pid)))
+
+	messages := map[int][]kayak.KCall{
+		client1Pid: makeCalls(t, 2*pipelineDepth),
+	}
+
+	var ctx context.Context
+	var cancelF context.CancelFunc
+	var responses, traces map[int][]interface{}
+	var err error
+
+	// ========== ROUND 1 ==========
+	z.Inject(makeInjectF(messages))
+	ctx, cancelF = context.WithTimeout(context.Background(), 3*time.Second)
+	responses, traces, err = z.Round(ctx)
+	cancelF()
+
+	require.NoError(t, err)
+
+	printOut(t, responses, traces, logs, "R1")
+
+	// The leader proposes the next rounds before the first one is decided
+	var proposes int
+	for _, trace := range traces[server1Pid] {
+		s, ok := trace.(string)
+		if !ok {
+			continue
+		}
+		if strings.Contains(s, "SEND KResponse") {
+			break
+		}
+		if strings.Contains(s, "SEND KPropose") {
+			proposes++
+		}
+	}
+	assert.True(t, proposes > len(serverKeys), "proposes before first decision: %d", proposes)
+
+	assert.ElementsMatch(t, extractTagsFromMessages(t, messages[client1Pid]), extractTagsFromResponses(t, responses[client1Pid]))
+
+	indexesExpected := []kayak.KIndex{}
+	for i := 0; i < 2*pipelineDepth; i++ {
+		indexesExpected = append(indexesExpected, kayak.KIndex(i))
+	}
+	assert.ElementsMatch(t, indexesExpected, extractIndexesFromResponses(t, responses[client1Pid]))
+
+	assert.ElementsMatch(t, makeEntries(t, messages), logs[server1Pid].Entries)
+	for pid := range logs {
+		assert.Equal(t, logs[server1Pid].Entries, logs[pid].Entries)
+	}
+
+}
+
+func TestKayakPipelineLeaderChange(t *testing.T) {
+	z := zmey.NewZmey(&zmey.Config{
+	// Debug: true,
+	})
+
+	logs := make(map[int]*Storage)
+
+	for _, pid := range serverPids {
+		logs[pid] = &Storage{}
+		serverConfig := makeDefaultServerConfig(pid, logs[pid])
+		serverConfig.PipelineDepth = pipelineDepth
+		z.SetProcess(pid, NewKayakWrapper(serverConfig))
+	}
+
+	z.SetProcess(client1Pid, NewClientWrapper(makeDefaultClientConfig(client1Pid)))
+
+	messages := map[int][]kayak.KCall{
+		client1Pid: makeCalls(t, 2*pipelineDepth),
+	}
+
+	// The leader reaches only server2, so all the rounds in flight stall
+	// without quorum
+	filterF := func(from, to int) bool {
+		if from == server1Pid && (to == server3Pid || to == server4Pid) {
+			return false
+		}
+		return true
+	}
+
+	z.Filter(filterF)
+
+	var ctx context.Context
+	var cancelF context.CancelFunc
+	var responses, traces map[int][]interface{}
+	var err error
+
+	// ========== ROUND 1 ==========
+	z.Inject(makeInjectF(messages))
+	ctx, cancelF = context.WithTimeout(context.Background(), 3*time.Second)
+	responses, traces, err = z.Round(ctx)
+	cancelF()
+
+	require.NoError(t, err)
+
+	printOut(t, responses, traces, logs, "R1")
+
+	assert.Empty(t, responses[client1Pid])
+	for pid := range logs {
+		assert.Empty(t, logs[pid].Entries)
+	}
+
+	// ========== ROUND 2 ==========
+	z.Tick(serverTimeout)
+	ctx, cancelF = context.WithTimeout(context.Background(), 3*time.Second)
+	responses, traces, err = z.Round(ctx)
+	cancelF()
+
+	require.NoError(t, err)
+
+	printOut(t, responses, traces, logs, "R2")
+
+	assert.ElementsMatch(t, extractTagsFromMessages(t, messages[client1Pid]), extractTagsFromResponses(t, responses[client1Pid]))
+
+	assert.ElementsMatch(t, makeEntries(t, messages), logs[server2Pid].Entries)
+	for pid := range logs {
+		assert.Equal(t, logs[server2Pid].Entries, logs[pid].Entries)
+	}
+
+}
@@ -0,0 +1,112 @@
+package test
+
+import (
+	"testing"
+
+	"github.com/stratumn/kayak"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// The test ensures that the read client returns the entries which f+1
+// servers agree on, when one server lies about them
+func TestReadEntries(t *testing.T) {
+	var returns []kayak.KReadReturn
+	network, keys := makeSignedNetwork(t, 4, func(c *kayak.KServerConfig) {
+		c.ReturnF = func(payload interface{}) {
+			if r, ok := payload.(kayak.KReadReturn); ok {
+				returns = append(returns, r)
+			}
+		}
+	})
+
+	calls := makeCalls(t, 4)
+	for i := range calls {
+		network.nodes[keys[0]].ReceiveCall(calls[i])
+	}
+	network.run()
+
+	entries := network.logs[keys[0]].Entries
+	require.Len(t, entries, len(calls))
+
+	// The second server is silent and the last one lies, the client does not
+	// require signatures so the lie is counted
+	network.tamperF = func(p packet) (packet, bool) {
+		signed, ok := p.payload.(kayak.KSigned)
+		if !ok {
+			return p, true
+		}
+		e, ok := signed.Payload.(kayak.KEntries)
+		if !ok {
+			return p, true
+		}
+		switch p.from {
+		case keys[1]:
+			return p, false
+		case keys[3]:
+			e.Data = []kayak.KData{kayak.KData("lie"), kayak.KData("lie")}
+			p.payload = e
+		}
+		return p, true
+	}
+
+	network.nodes[keys[0]].ReceiveCall(kayak.KReadCall{Tag: 1, First: 1, Last: 3})
+	network.run()
+
+	require.Len(t, returns, 1)
+	assert.Equal(t, 1, returns[0].Tag)
+	assert.Equal(t, kayak.ErrorReasonNone, returns[0].Error)
+	assert.Equal(t, kayak.KIndex(1), returns[0].First)
+	require.Len(t, returns[0].Data, 2)
+	for i := range returns[0].Data {
+		assert.Equal(t, entries[1+i], []byte(returns[0].Data[i]))
+	}
+	assert.NotEqual(t, kayak.KHash{}, returns[0].Hash)
+
+	// The entries which are not decided yet are rejected
+	returns = nil
+	network.nodes[keys[0]].ReceiveCall(kayak.KReadCall{Tag: 2, First: 3, Last: 5})
+	network.run()
+
+	require.Len(t, returns, 1)
+	assert.Equal(t, 2, returns[0].Tag)
+	assert.Equal(t, kayak.ErrorReasonAhead, returns[0].Error)
+	assert.Empty(t, returns[0].Data)
+}
+
+// The test ensures that the servers reject the reads of more entries than
+// the limit
+func TestReadTooLarge(t *testing.T) {
+	var returns []kayak.KReadReturn
+	network, keys := makeSignedNetwork(t, 4, func(c *kayak.KServerConfig) {
+		c.MaxRead = 2
+		c.ReturnF = func(payload interface{}) {
+			if r, ok := payload.(kayak.KReadReturn); ok {
+				returns = append(returns, r)
+			}
+		}
+	})
+
+	calls := makeCalls(t, 4)
+	for i := range calls {
+		network.nodes[keys[0]].ReceiveCall(calls[i])
+	}
+	network.run()
+	require.Len(t, network.logs[keys[0]].Entries, len(calls))
+
+	network.nodes[keys[0]].ReceiveCall(kayak.KReadCall{Tag: 1, First: 0, Last: 3})
+	network.run()
+
+	require.Len(t, returns, 1)
+	assert.Equal(t, kayak.ErrorReasonTooLarge, returns[0].Error)
+	assert.Empty(t, returns[0].Data)
+
+	returns = nil
+	network.nodes[keys[0]].ReceiveCall(kayak.KReadCall{Tag: 2, First: 1, Last: 3})
+	network.run()
+
+	require.Len(t, returns, 1)
+	assert.Equal(t, kayak.ErrorReasonNone, returns[0].Error)
+	assert.Len(t, returns[0].Data, 2)
+}
@@ -886,3 +886,55 @@ func TestKayakRemoveProcessEpochJumpLeader(t *testing.T) {
 	}
 
 }
+
+// The test ensures that the epoch jumps of the reconfiguration do not stall
+// the signed consensus, which otherwise waits for a view of the new epoch.
+// The leader of epoch 1 is kept by a jump to epoch 3 when the crashed former
+// leader is removed, and by a jump to epoch 4 when the learner is added.
+func TestReconfigEpochJumpSigned(t *testing.T) {
+	network, keys := makeSignedNetwork(t, 5, func(c *kayak.KServerConfig) {
+		c.Learners = c.Keys[4:]
+		c.Keys = c.Keys[:4]
+	})
+
+	network.tamperF = func(p packet) (packet, bool) {
+		return p, p.from != keys[0] && p.to != keys[0]
+	}
+
+	calls := makeCalls(t, 1)
+	network.nodes[keys[1]].ReceiveCall(calls[0])
+	network.run()
+	for _, key := range keys[1:4] {
+		network.nodes[key].Tick(serverTimeout)
+	}
+	network.run()
+
+	require.Equal(t, kayak.KEpoch(1), network.nodes[keys[1]].Status().Epoch)
+	require.Len(t, network.logs[keys[1]].Entries, 1)
+
+	commands := []struct {
+		call  kayak.KCall
+		epoch kayak.KEpoch
+	}{
+		{kayak.KCall{Tag: getNextTag(), Payload: append(kayak.MagicRemoveProcess[:], keys[0][:]...)}, 3},
+		{kayak.KCall{Tag: getNextTag(), Payload: append(kayak.MagicAddProcess[:], keys[4][:]...)}, 4},
+	}
+
+	for _, command := range commands {
+		network.nodes[keys[1]].ReceiveCall(command.call)
+		network.run()
+
+		calls := makeCalls(t, 1)
+		network.nodes[keys[1]].ReceiveCall(calls[0])
+		network.run()
+
+		for _, key := range keys[1:4] {
+			status := network.nodes[key].Status()
+			assert.Equal(t, command.epoch, status.Epoch)
+			assert.Equal(t, keys[1], status.Leader)
+			entries := network.logs[key].Entries
+			require.NotEmpty(t, entries)
+			assert.Equal(t, []byte(calls[0].Payload), entries[len(entries)-1])
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stratumn/kayak"
+	"github.com/stratumn/zmey"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKayakRecoverAndDoConsensus(t *testing.T) {
+	z := zmey.NewZmey(&zmey.Config{
+	// Debug: true,
+	})
+
+	logs := make(map[int]*Storage)
+
+	for _, pid := range serverPids {
+		logs[pid] = &Storage{}
+		z.SetProcess(pid, NewKayakWrapper(makeDefaultServerConfig(pid, logs[pid])))
+	}
+
+	var ctx context.Context
+	var cancelF context.CancelFunc
+	var responses, traces map[int][]interface{}
+	var err error
+
+	// ========== ROUND 1 ==========
+	messages1 := map[int][]kayak.KCall{
+		server1Pid: makeCalls(t, 2),
+		server2Pid: makeCalls(t, 2),
+	}
+	z.Inject(makeInjectF(messages1))
+
+	ctx, cancelF = context.WithTimeout(context.Background(), 3*time.Second)
+	responses, traces, err = z.Round(ctx)
+	cancelF()
+
+	require.NoError(t, err)
+
+	printOut(t, responses, traces, logs, "R1")
+
+	require.Len(t, logs[server1Pid].Entries, 4)
+
+	// ========== ROUND 2 ==========
+	// All processes restart from their storages, nobody has to sync
+	for _, pid := range serverPids {
+		z.SetProcess(pid, NewKayakWrapper(makeDefaultServerConfig(pid, logs[pid])))
+	}
+
+	messages2 := map[int][]kayak.KCall{
+		server3Pid: makeCalls(t, 2),
+		server4Pid: makeCalls(t, 2),
+	}
+	z.Inject(makeInjectF(messages2))
+
+	ctx, cancelF = context.WithTimeout(context.Background(), 3*time.Second)
+	responses, traces, err = z.Round(ctx)
+	cancelF()
+
+	require.NoError(t, err)
+
+	printOut(t, responses, traces, logs, "R2")
+
+	for pid := range responses {
+		tagsExpected := extractTagsFromMessages(t, messages2[pid])
+		tagsActual := extractTagsFromResponses(t, responses[pid])
+		assert.ElementsMatch(t, tagsExpected, tagsActual)
+	}
+
+	entriesExpected := makeEntries(t, messages1, messages2)
+
+	assert.ElementsMatch(t, entriesExpected, logs[server1Pid].Entries)
+	for pid := range logs {
+		assert.Equal(t, logs[server1Pid].Entries, logs[pid].Entries)
+		assert.Equal(t, logs[server1Pid].Origins, logs[pid].Origins)
+	}
+
+}
+
+func TestKayakRecoverReplayResistance(t *testing.T) {
+	z := zmey.NewZmey(&zmey.Config{
+	// Debug: true,
+	})
+
+	logs := make(map[int]*Storage)
+
+	for _, pid := range serverPids {
+		logs[pid] = &Storage{}
+		z.SetProcess(pid, NewKayakWrapper(makeDefaultServerConfig(pid, logs[pid])))
+	}
+
+	client1config := makeDefaultClientConfig(client1Pid)
+	client1config.ByzantineFlags = kayak.ByzantineFlagClientFixNonce
+	z.SetProcess(client1Pid, NewClientWrapper(client1config))
+
+	calls := makeCalls(t, 1)
+	messages := map[int][]kayak.KCall{
+		client1Pid: calls,
+	}
+
+	var ctx context.Context
+	var cancelF context.CancelFunc
+	var responses, traces map[int][]interface{}
+	var err error
+
+	// ========== ROUND 1 ==========
+	z.Inject(makeInjectF(messages))
+	ctx, cancelF = context.WithTimeout(context.Background(), 3*time.Second)
+	responses, traces, err = z.Round(ctx)
+	cancelF()
+
+	require.NoError(t, err)
+
+	printOut(t, responses, traces, logs, "R1")
+
+	// ========== ROUND 2 ==========
+	for _, pid := range serverPids {
+		z.SetProcess(pid, NewKayakWrapper(makeDefaultServerConfig(pid, logs[pid])))
+	}
+
+	z.Inject(makeInjectF(messages))
+	ctx, cancelF = context.WithTimeout(context.Background(), 3*time.Second)
+	responses, traces, err = z.Round(ctx)
+	cancelF()
+
+	require.NoError(t, err)
+
+	printOut(t, responses, traces, logs, "R2")
+
+	expectedEntries := [][]byte{
+		calls[0].Payload,
+	}
+
+	assert.ElementsMatch(t, expectedEntries, logs[server1Pid].Entries)
+	for pid := range logs {
+		assert.Equal(t, logs[server1Pid].Entries, logs[pid].Entries)
+	}
+
+}
@@ -2,6 +2,7 @@ package test
 
 import (
 	"context"
+	"fmt"
 	"testing"
 	"time"
 
@@ -108,6 +109,9 @@ func TestReplayResistanceNextRound(t *testing.T) {
 
 	printOut(t, responses, traces, logs, "R2")
 
+	require.Len(t, responses[client1Pid], 1)
+	assert.Equal(t, kayak.ErrorReasonReplay, responses[client1Pid][0].(kayak.KReturn).Error)
+
 	expectedEntries := [][]byte{
 		calls[0].Payload,
 	}
@@ -168,6 +172,9 @@ func TestReplayResistanceAttackFromThePast(t *testing.T) {
 
 	printOut(t, responses, traces, logs, "R2")
 
+	require.Len(t, responses[client1Pid], 1)
+	assert.Equal(t, kayak.ErrorReasonTooOld, responses[client1Pid][0].(kayak.KReturn).Error)
+
 	expectedEntries := [][]byte{
 		calls[0].Payload,
 	}
@@ -178,3 +185,118 @@ func TestReplayResistanceAttackFromThePast(t *testing.T) {
 	}
 
 }
+
+func TestReplayResistanceBoundedBuzz(t *testing.T) {
+	const batchesN = 10
+	const tolerance = 5
+
+	z := zmey.NewZmey(&zmey.Config{
+	// Debug: true,
+	})
+
+	logs := make(map[int]*Storage)
+	wrappers := make(map[int]*KayakWrapper)
+
+	for _, pid := range serverPids {
+		logs[pid] = &Storage{}
+		serverConfig := makeDefaultServerConfig(pid, logs[pid])
+		serverConfig.IndexTolerance = tolerance
+		wrappers[pid] = NewKayakWrapper(serverConfig)
+		z.SetProcess(pid, wrappers[pid])
+	}
+
+	client1config := makeDefaultClientConfig(client1Pid)
+	client1config.ByzantineFlags = kayak.ByzantineFlagClientFixNonce
+	z.SetProcess(client1Pid, NewClientWrapper(client1config))
+
+	var ctx context.Context
+	var cancelF context.CancelFunc
+	var responses, traces map[int][]interface{}
+	var err error
+
+	var messagesAll []map[int][]kayak.KCall
+
+	for i := 0; i < batchesN; i++ {
+		// Each call is sent twice with the same nonce and the same index
+		calls := makeCalls(t, 1)
+		messages := map[int][]kayak.KCall{
+			server1Pid: makeCalls(t, 2),
+			client1Pid: calls,
+		}
+		messagesAll = append(messagesAll, messages)
+
+		z.Inject(makeInjectF(messages))
+
+		ctx, cancelF = context.WithTimeout(context.Background(), 3*time.Second)
+		responses, traces, err = z.Round(ctx)
+		cancelF()
+
+		require.NoError(t, err)
+
+		printOut(t, responses, traces, logs, fmt.Sprintf("R%d.1", i+1))
+
+		z.Inject(makeInjectF(map[int][]kayak.KCall{client1Pid: calls}))
+
+		ctx, cancelF = context.WithTimeout(context.Background(), 3*time.Second)
+		responses, traces, err = z.Round(ctx)
+		cancelF()
+
+		require.NoError(t, err)
+
+		printOut(t, responses, traces, logs, fmt.Sprintf("R%d.2", i+1))
+
+		// Refresh client indexes
+		z.Tick(clientTimeout)
+
+		ctx, cancelF = context.WithTimeout(context.Background(), 3*time.Second)
+		responses, traces, err = z.Round(ctx)
+		cancelF()
+
+		require.NoError(t, err)
+
+		for _, pid := range serverPids {
+			footprint := wrappers[pid].k.Footprint()
+			assert.True(t, footprint.SetBuzz <= tolerance, "buzz set: %d", footprint.SetBuzz)
+		}
+	}
+
+	assert.ElementsMatch(t, makeEntries(t, messagesAll...), logs[server1Pid].Entries)
+	for pid := range logs {
+		assert.Equal(t, logs[server1Pid].Entries, logs[pid].Entries)
+	}
+
+}
+
+// The test ensures that the followers drop a job the leader never received
+// once its index falls behind the tolerance window
+func TestReplayResistanceStaleJobs(t *testing.T) {
+	const tolerance = 2
+
+	network, keys := makeSignedNetwork(t, 4, func(c *kayak.KServerConfig) {
+		c.IndexTolerance = tolerance
+	})
+	network.tamperF = func(p packet) (packet, bool) {
+		if _, ok := p.payload.(kayak.KRequest); ok && p.from == keys[1] && p.to == keys[0] {
+			return p, false
+		}
+		return p, true
+	}
+
+	network.nodes[keys[1]].ReceiveCall(makeCalls(t, 1)[0])
+	network.run()
+
+	for _, key := range keys[1:] {
+		require.Equal(t, 1, network.nodes[key].Footprint().Jobs)
+	}
+
+	calls := makeCalls(t, tolerance+1)
+	for i := range calls {
+		network.nodes[keys[0]].ReceiveCall(calls[i])
+		network.run()
+	}
+
+	for _, key := range keys {
+		assert.Equal(t, kayak.KRound(tolerance+1), network.nodes[key].Status().Round)
+		assert.Zero(t, network.nodes[key].Footprint().Jobs)
+	}
+}
@@ -0,0 +1,296 @@
+package test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stratumn/zmey"
+
+	"github.com/stratumn/kayak"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// The test ensures that the retries of a request in a session are decided
+// once and answered with the original index
+func TestSessionRetries(t *testing.T) {
+	network, keys := makeSignedNetwork(t, 4, func(c *kayak.KServerConfig) {
+		c.AllowExternal = true
+	})
+
+	client := kayak.KAddress{0xCC, 0x02}
+	session := kayak.KNonce{0x5E}
+	var responses []kayak.KResponse
+	network.tamperF = func(p packet) (packet, bool) {
+		if p.to != client {
+			return p, true
+		}
+		if s, ok := p.payload.(kayak.KSigned); ok {
+			if r, ok := s.Payload.(kayak.KResponse); ok {
+				responses = append(responses, r)
+			}
+		}
+		return p, false
+	}
+
+	send := func(request kayak.KRequest) []kayak.KResponse {
+		responses = nil
+		for _, key := range keys {
+			network.nodes[key].ReceiveNet(client, request)
+		}
+		network.run()
+		return responses
+	}
+
+	first := kayak.KRequest{Nonce: kayak.KNonce{0x01}, Payload: kayak.KData("first"), Sequence: 1, Client: client, Session: session}
+	for _, r := range send(first) {
+		assert.Equal(t, kayak.KResponse{Nonce: first.Nonce}, r)
+	}
+
+	// The retry has another nonce and index, as made by another call
+	retry := kayak.KRequest{Nonce: kayak.KNonce{0x02}, Payload: kayak.KData("first"), Index: 1, Sequence: 1, Client: client, Session: session}
+	retryResponses := send(retry)
+	require.Len(t, retryResponses, len(keys))
+	for _, r := range retryResponses {
+		assert.Equal(t, kayak.KResponse{Index: 0, Nonce: retry.Nonce}, r)
+	}
+
+	second := kayak.KRequest{Nonce: kayak.KNonce{0x03}, Payload: kayak.KData("second"), Index: 1, Sequence: 2, Client: client, Session: session}
+	for _, r := range send(second) {
+		assert.Equal(t, kayak.KResponse{Index: 1, Nonce: second.Nonce}, r)
+	}
+
+	// The first request is behind the session
+	late := kayak.KRequest{Nonce: kayak.KNonce{0x04}, Payload: kayak.KData("first"), Index: 2, Sequence: 1, Client: client, Session: session}
+	lateResponses := send(late)
+	require.Len(t, lateResponses, len(keys))
+	for _, r := range lateResponses {
+		assert.Equal(t, kayak.ErrorReasonReplay, r.Error)
+	}
+
+	for _, key := range keys {
+		assert.Equal(t, [][]byte{[]byte("first"), []byte("second")}, network.logs[key].Entries)
+	}
+}
+
+// The test ensures that the client retries a timed out call with the
+// sequence number of its return, and gets the index it was decided at
+func TestSessionClientRetry(t *testing.T) {
+	z := zmey.NewZmey(&zmey.Config{
+	// Debug: true,
+	})
+
+	logs := make(map[int]*Storage)
+
+	for _, pid := range serverPids {
+		logs[pid] = &Storage{}
+		z.SetProcess(pid, NewKayakWrapper(makeDefaultServerConfig(pid, logs[pid])))
+	}
+
+	clientConfig := makeDefaultClientConfig(client1Pid)
+	clientConfig.Sessions = true
+	z.SetProcess(client1Pid, NewClientWrapper(clientConfig))
+
+	// The responses are lost
+	z.Filter(func(from, to int) bool {
+		return to != client1Pid
+	})
+
+	messages := map[int][]kayak.KCall{
+		client1Pid: makeCalls(t, 1),
+	}
+
+	var ctx context.Context
+	var cancelF context.CancelFunc
+	var responses, traces map[int][]interface{}
+	var err error
+
+	// ========== ROUND 1 ==========
+	z.Inject(makeInjectF(messages))
+	ctx, cancelF = context.WithTimeout(context.Background(), 3*time.Second)
+	responses, traces, err = z.Round(ctx)
+	cancelF()
+
+	require.NoError(t, err)
+
+	printOut(t, responses, traces, logs, "R1")
+
+	assert.Empty(t, responses[client1Pid])
+	for pid := range logs {
+		assert.Equal(t, makeEntries(t, messages), logs[pid].Entries)
+	}
+
+	// ========== ROUND 2 ==========
+	z.Filter(func(from, to int) bool {
+		return true
+	})
+
+	z.Tick(clientTimeout)
+	ctx, cancelF = context.WithTimeout(context.Background(), 3*time.Second)
+	responses, traces, err = z.Round(ctx)
+	cancelF()
+
+	require.NoError(t, err)
+
+	printOut(t, responses, traces, logs, "R2")
+
+	require.Len(t, responses[client1Pid], 1)
+	timeout := responses[client1Pid][0].(kayak.KReturn)
+	assert.True(t, timeout.Timeout)
+	assert.Equal(t, uint64(1), timeout.Sequence)
+
+	// ========== ROUND 3 ==========
+	retry := kayak.KCall{Tag: getNextTag(), Payload: messages[client1Pid][0].Payload, Sequence: timeout.Sequence}
+	z.Inject(makeInjectF(map[int][]kayak.KCall{client1Pid: {retry}}))
+	ctx, cancelF = context.WithTimeout(context.Background(), 3*time.Second)
+	responses, traces, err = z.Round(ctx)
+	cancelF()
+
+	require.NoError(t, err)
+
+	printOut(t, responses, traces, logs, "R3")
+
+	require.Len(t, responses[client1Pid], 1)
+	r := responses[client1Pid][0].(kayak.KReturn)
+	assert.Equal(t, retry.Tag, r.Tag)
+	assert.Equal(t, kayak.ErrorReasonNone, r.Error)
+	assert.Equal(t, kayak.KIndex(0), r.Index)
+
+	for pid := range logs {
+		assert.Equal(t, makeEntries(t, messages), logs[pid].Entries)
+	}
+}
+
+// The test ensures that a request forwarded by a server in the name of a
+// client does not advance the session of the client
+func TestSessionForwardedLoad(t *testing.T) {
+	network, keys, send := makeSessionNetwork(t, func(c *kayak.KServerConfig) {})
+
+	client := kayak.KAddress{0xCC, 0x02}
+	session := kayak.KNonce{0x5E}
+
+	// The Byzantine server forwards a request with a huge sequence number
+	forged := kayak.KLoad{
+		From:    client,
+		Request: kayak.KRequest{Nonce: kayak.KNonce{0x01}, Payload: kayak.KData("forged"), Sequence: 1 << 40, Client: client, Session: session},
+	}
+	network.queue = append(network.queue, packet{from: keys[3], to: keys[0], payload: network.sign(keys[3], forged)})
+	network.run()
+
+	for _, key := range keys {
+		require.Empty(t, network.logs[key].Entries)
+	}
+
+	request := kayak.KRequest{Nonce: kayak.KNonce{0x02}, Payload: kayak.KData("first"), Sequence: 1, Client: client, Session: session}
+	responses := send(client, request)
+	require.Len(t, responses, len(keys))
+	for _, r := range responses {
+		assert.Equal(t, kayak.KResponse{Nonce: request.Nonce}, r)
+	}
+}
+
+// makeSessionNetwork returns a network of 4 servers and a function sending
+// a request from a client to all of them, which returns the responses
+func makeSessionNetwork(t *testing.T, option func(c *kayak.KServerConfig)) (*signedNetwork, []kayak.KAddress, func(kayak.KAddress, kayak.KRequest) []kayak.KResponse) {
+	network, keys := makeSignedNetwork(t, 4, func(c *kayak.KServerConfig) {
+		c.AllowExternal = true
+		option(c)
+	})
+
+	var responses []kayak.KResponse
+	network.tamperF = func(p packet) (packet, bool) {
+		if _, found := network.nodes[p.to]; found {
+			return p, true
+		}
+		if s, ok := p.payload.(kayak.KSigned); ok {
+			if r, ok := s.Payload.(kayak.KResponse); ok {
+				responses = append(responses, r)
+			}
+		}
+		return p, false
+	}
+
+	send := func(client kayak.KAddress, request kayak.KRequest) []kayak.KResponse {
+		responses = nil
+		for _, key := range keys {
+			network.nodes[key].ReceiveNet(client, request)
+		}
+		network.run()
+		return responses
+	}
+
+	return network, keys, send
+}
+
+// The test ensures that the sessions are restored from the snapshot and
+// the log when a server restarts, so that it answers a retry as the others
+func TestSessionRecovery(t *testing.T) {
+	network, keys, send := makeSessionNetwork(t, func(c *kayak.KServerConfig) {
+		c.InstallF = func(kayak.KIndex, []byte) {}
+	})
+
+	client := kayak.KAddress{0xCC, 0x02}
+	session := kayak.KNonce{0x5E}
+
+	first := kayak.KRequest{Nonce: kayak.KNonce{0x01}, Payload: kayak.KData("first"), Sequence: 1, Client: client, Session: session}
+	require.Len(t, send(client, first), len(keys))
+
+	for _, key := range keys {
+		require.NoError(t, network.nodes[key].Snapshot(1, []byte("state")))
+	}
+
+	second := kayak.KRequest{Nonce: kayak.KNonce{0x02}, Payload: kayak.KData("second"), Index: 1, Sequence: 1, Client: client, Session: kayak.KNonce{0x5F}}
+	require.Len(t, send(client, second), len(keys))
+
+	for _, key := range keys {
+		network.restart(key)
+	}
+
+	// The session of the first request is in the snapshot, the one of the
+	// second request is in the log
+	for i, request := range []kayak.KRequest{first, second} {
+		retry := request
+		retry.Nonce = kayak.KNonce{0x10 + byte(i)}
+		retry.Index = 2
+		responses := send(client, retry)
+		require.Len(t, responses, len(keys))
+		for _, r := range responses {
+			assert.Equal(t, kayak.KResponse{Index: kayak.KIndex(i), Nonce: retry.Nonce}, r)
+		}
+	}
+
+	for _, key := range keys {
+		assert.Equal(t, kayak.KRound(2), network.nodes[key].Status().Round)
+	}
+}
+
+// The test ensures that the servers forget the oldest session once they
+// keep MaxSessions of them
+func TestSessionLimit(t *testing.T) {
+	network, keys, send := makeSessionNetwork(t, func(c *kayak.KServerConfig) {
+		c.MaxSessions = 1
+	})
+
+	clientA := kayak.KAddress{0xCC, 0x0A}
+	clientB := kayak.KAddress{0xCC, 0x0B}
+
+	a := kayak.KRequest{Nonce: kayak.KNonce{0x01}, Payload: kayak.KData("a"), Sequence: 1, Client: clientA}
+	require.Len(t, send(clientA, a), len(keys))
+
+	b := kayak.KRequest{Nonce: kayak.KNonce{0x02}, Payload: kayak.KData("b"), Index: 1, Sequence: 1, Client: clientB}
+	require.Len(t, send(clientB, b), len(keys))
+
+	// The retry of the forgotten session is decided again
+	retry := a
+	retry.Nonce = kayak.KNonce{0x03}
+	retry.Index = 2
+	for _, r := range send(clientA, retry) {
+		assert.Equal(t, kayak.KResponse{Index: 2, Nonce: retry.Nonce}, r)
+	}
+
+	for _, key := range keys {
+		assert.Len(t, network.logs[key].Entries, 3)
+	}
+}
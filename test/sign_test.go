@@ -0,0 +1,458 @@
+package test
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/stratumn/kayak"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type packet struct {
+	from, to kayak.KAddress
+	payload  interface{}
+}
+
+// signedNetwork delivers the packets in order, tamperF may modify or drop
+// them on the way
+type signedNetwork struct {
+	nodes       map[kayak.KAddress]*kayak.Kayak
+	logs        map[kayak.KAddress]*Storage
+	configs     map[kayak.KAddress]*kayak.KServerConfig
+	privateKeys map[kayak.KAddress]ed25519.PrivateKey
+	queue       []packet
+	tamperF     func(p packet) (packet, bool)
+}
+
+func makeSignedNetwork(t *testing.T, n int, options ...func(c *kayak.KServerConfig)) (*signedNetwork, []kayak.KAddress) {
+	network := &signedNetwork{
+		nodes:       make(map[kayak.KAddress]*kayak.Kayak),
+		logs:        make(map[kayak.KAddress]*Storage),
+		configs:     make(map[kayak.KAddress]*kayak.KServerConfig),
+		privateKeys: make(map[kayak.KAddress]ed25519.PrivateKey),
+	}
+
+	var keys []kayak.KAddress
+	var privateKeys []ed25519.PrivateKey
+	for i := 0; i < n; i++ {
+		seed := sha256.Sum256([]byte{byte(i)})
+		privateKey := ed25519.NewKeyFromSeed(seed[:])
+		var key kayak.KAddress
+		copy(key[:], privateKey.Public().(ed25519.PublicKey))
+		keys = append(keys, key)
+		privateKeys = append(privateKeys, privateKey)
+		network.privateKeys[key] = privateKey
+	}
+
+	for i, key := range keys {
+		from := key
+		network.logs[key] = &Storage{}
+		config := &kayak.KServerConfig{
+			Key:            key,
+			Keys:           keys,
+			Storage:        network.logs[key],
+			RequestT:       serverTimeout,
+			CallT:          clientTimeout,
+			WhatsupT:       serverTimeout,
+			BonjourT:       clientTimeout,
+			IndexTolerance: indexTolerance,
+			PrivateKey:     privateKeys[i],
+			SendF: func(to kayak.KAddress, payload interface{}) {
+				network.queue = append(network.queue, packet{from: from, to: to, payload: payload})
+			},
+			ReturnF: func(payload interface{}) {},
+			TraceF:  func(payload interface{}) {},
+			ErrorF: func(err error) {
+				t.Errorf("%#v: %s", from, err)
+			},
+		}
+		for _, option := range options {
+			option(config)
+		}
+		network.configs[key] = config
+		network.nodes[key] = kayak.NewKayak(config)
+	}
+
+	for _, key := range keys {
+		network.nodes[key].Start()
+	}
+
+	return network, keys
+}
+
+// sign signs the payload as the server would, so that a Byzantine server
+// can send forged messages with valid signatures
+func (n *signedNetwork) sign(from kayak.KAddress, payload interface{}) kayak.KSigned {
+	var buffer bytes.Buffer
+	err := json.NewEncoder(&buffer).Encode(struct {
+		Type    string
+		Payload interface{}
+	}{
+		Type:    fmt.Sprintf("%T", payload),
+		Payload: payload,
+	})
+	if err != nil {
+		panic(err)
+	}
+	digest := sha256.Sum256(buffer.Bytes())
+	return kayak.KSigned{Payload: payload, Signature: ed25519.Sign(n.privateKeys[from], digest[:])}
+}
+
+// restart replaces the server by a new one recovering from its storage
+func (n *signedNetwork) restart(key kayak.KAddress) {
+	n.nodes[key] = kayak.NewKayak(n.configs[key])
+	n.nodes[key].Start()
+}
+
+// verifyCertificates checks that every entry of the storage has a valid
+// certificate
+func verifyCertificates(t *testing.T, storage *Storage, keys []kayak.KAddress) {
+	for i := range storage.Entries {
+		index := kayak.KIndex(i)
+		certificate, found, err := storage.LoadCertificate(index)
+		require.NoError(t, err)
+		require.True(t, found, "no certificate at %d", i)
+		assert.NoError(t, kayak.VerifyCertificate(certificate, index, storage.Entries[i], storage.Origins[i], keys))
+	}
+}
+
+func (n *signedNetwork) run() {
+	for len(n.queue) > 0 {
+		p := n.queue[0]
+		n.queue = n.queue[1:]
+		if n.tamperF != nil {
+			var deliver bool
+			if p, deliver = n.tamperF(p); !deliver {
+				continue
+			}
+		}
+		if node, found := n.nodes[p.to]; found {
+			node.ReceiveNet(p.from, p.payload)
+		}
+	}
+}
+
+func TestSignedMessages(t *testing.T) {
+	network, keys := makeSignedNetwork(t, 4)
+
+	signed := make(map[string]bool)
+	network.tamperF = func(p packet) (packet, bool) {
+		if s, ok := p.payload.(kayak.KSigned); ok {
+			switch s.Payload.(type) {
+			case kayak.KPropose:
+				signed["propose"] = true
+			case kayak.KWrite:
+				signed["write"] = true
+			case kayak.KAccept:
+				signed["accept"] = true
+			}
+		}
+		return p, true
+	}
+
+	calls := makeCalls(t, 4)
+	for i := range calls {
+		network.nodes[keys[0]].ReceiveCall(calls[i])
+	}
+	network.run()
+
+	assert.Equal(t, map[string]bool{"propose": true, "write": true, "accept": true}, signed)
+
+	entriesExpected := makeEntries(t, map[int][]kayak.KCall{0: calls})
+	for _, key := range keys {
+		assert.ElementsMatch(t, entriesExpected, network.logs[key].Entries)
+	}
+}
+
+func TestSignedMessagesTampered(t *testing.T) {
+	network, keys := makeSignedNetwork(t, 4)
+
+	// Writes are either stripped of the signature or forged
+	var tampered int
+	network.tamperF = func(p packet) (packet, bool) {
+		s, ok := p.payload.(kayak.KSigned)
+		if !ok {
+			return p, true
+		}
+		write, ok := s.Payload.(kayak.KWrite)
+		if !ok {
+			return p, true
+		}
+		tampered++
+		if tampered%2 == 0 {
+			p.payload = write
+		} else {
+			write.Hash[0]++
+			s.Payload = write
+			p.payload = s
+		}
+		return p, true
+	}
+
+	calls := makeCalls(t, 1)
+	network.nodes[keys[0]].ReceiveCall(calls[0])
+	network.run()
+
+	require.NotZero(t, tampered)
+	for _, key := range keys {
+		assert.Empty(t, network.logs[key].Entries)
+	}
+}
+
+// The test ensures that the chunks and the snapshots are not trusted unless
+// signed by their senders, so that spoofed addresses do not make a sync or a
+// snapshot quorum
+func TestSignedSyncSpoofed(t *testing.T) {
+	var installed bool
+	network, keys := makeSignedNetwork(t, 4, func(c *kayak.KServerConfig) {
+		c.InstallF = func(index kayak.KIndex, state []byte) {
+			installed = true
+		}
+	})
+
+	data := kayak.KData{0xDE, 0xAD}
+	buzz := kayak.KHash{0xBE, 0xEF}
+	var buzzBase kayak.KHash
+	buzzHash := kayak.KHash(sha256.Sum256(append(buzzBase[:], buzz[:]...)))
+
+	for _, from := range keys[:3] {
+		network.queue = append(network.queue,
+			packet{from: from, to: keys[3], payload: kayak.KChunk{
+				Last: 1,
+				Data: []kayak.KData{data},
+				Buzz: []kayak.KHash{buzz},
+			}},
+			packet{from: from, to: keys[3], payload: kayak.KSnapshot{
+				Index:    1,
+				State:    kayak.KData("forged"),
+				Keys:     keys,
+				BuzzHash: buzzHash,
+				Buzz:     []kayak.KHash{buzz},
+			}},
+		)
+	}
+	network.run()
+
+	assert.False(t, installed)
+	assert.Equal(t, kayak.KRound(0), network.nodes[keys[3]].Status().Round)
+	assert.Empty(t, network.logs[keys[3]].Entries)
+}
+
+func TestCommitCertificates(t *testing.T) {
+	network, keys := makeSignedNetwork(t, 4)
+
+	calls := makeCalls(t, 4)
+	for i := range calls {
+		network.nodes[keys[0]].ReceiveCall(calls[i])
+	}
+	network.run()
+
+	for _, key := range keys {
+		storage := network.logs[key]
+		require.Len(t, storage.Entries, len(calls))
+		require.NotEmpty(t, storage.Certificates)
+		require.True(t, len(storage.Certificates) <= len(calls))
+
+		verifyCertificates(t, storage, keys)
+
+		for i := range storage.Entries {
+			index := kayak.KIndex(i)
+			certificate, _, _ := storage.LoadCertificate(index)
+			origin := storage.Origins[i]
+
+			// Another entry
+			assert.Error(t, kayak.VerifyCertificate(certificate, index, []byte{0xFF, 0xFF, 0xFF}, origin, keys))
+
+			// Another origin
+			otherOrigin := origin
+			otherOrigin.Sequence++
+			assert.Error(t, kayak.VerifyCertificate(certificate, index, storage.Entries[i], otherOrigin, keys))
+
+			// Another index
+			assert.Error(t, kayak.VerifyCertificate(certificate, certificate.Round+kayak.KIndex(len(certificate.Buzz)), storage.Entries[i], origin, keys))
+
+			// Another membership
+			otherKeys := append([]kayak.KAddress{keys[1], keys[0]}, keys[2:]...)
+			assert.Error(t, kayak.VerifyCertificate(certificate, index, storage.Entries[i], origin, otherKeys))
+
+			// Not enough signatures
+			partial := certificate
+			partial.Signers = []byte{0}
+			partial.Signatures = nil
+			for j := range keys {
+				if certificate.Signers[0]&(1<<uint(j)) != 0 {
+					partial.Signers[0] |= 1 << uint(j)
+					partial.Signatures = append(partial.Signatures, certificate.Signatures[len(partial.Signatures)])
+				}
+				if len(partial.Signatures) == 2 {
+					break
+				}
+			}
+			assert.Error(t, kayak.VerifyCertificate(partial, index, storage.Entries[i], origin, keys))
+		}
+	}
+}
+
+func TestCertifiedSync(t *testing.T) {
+	network, keys := makeSignedNetwork(t, 4, func(c *kayak.KServerConfig) {
+		c.CertifiedSync = true
+	})
+
+	lagging := keys[3]
+
+	// The lagging server misses the consensus
+	network.tamperF = func(p packet) (packet, bool) {
+		return p, p.from != lagging && p.to != lagging
+	}
+
+	calls := makeCalls(t, 4)
+	for i := range calls {
+		network.nodes[keys[0]].ReceiveCall(calls[i])
+	}
+	network.run()
+
+	require.Empty(t, network.logs[lagging].Entries)
+
+	// The first peer asked sends forged data, the lagging server asks the
+	// next one after a while
+	var needs []kayak.KAddress
+	var ensures int
+	network.tamperF = func(p packet) (packet, bool) {
+		switch payload := p.payload.(type) {
+		case kayak.KNeed:
+			needs = append(needs, p.to)
+		case kayak.KEnsure:
+			ensures++
+		case kayak.KSigned:
+			if chunk, ok := payload.Payload.(kayak.KChunk); ok && p.from == keys[0] {
+				chunk.Data = append([]kayak.KData{[]byte{0xFF}}, chunk.Data[1:]...)
+				p.payload = network.sign(p.from, chunk)
+			}
+		}
+		return p, true
+	}
+
+	network.nodes[lagging].Tick(serverTimeout)
+	network.run()
+
+	assert.Empty(t, network.logs[lagging].Entries)
+
+	network.nodes[lagging].Tick(serverTimeout)
+	network.run()
+
+	assert.Equal(t, []kayak.KAddress{keys[0], keys[1]}, needs)
+	assert.Zero(t, ensures)
+
+	entriesExpected := makeEntries(t, map[int][]kayak.KCall{0: calls})
+	assert.ElementsMatch(t, entriesExpected, network.logs[lagging].Entries)
+
+	verifyCertificates(t, network.logs[lagging], keys)
+}
+
+// The test ensures that the certificates are restored with the log, so that
+// restarted servers still serve a certified sync
+func TestCertifiedSyncAfterRestart(t *testing.T) {
+	network, keys := makeSignedNetwork(t, 4, func(c *kayak.KServerConfig) {
+		c.CertifiedSync = true
+	})
+
+	lagging := keys[3]
+
+	network.tamperF = func(p packet) (packet, bool) {
+		return p, p.from != lagging && p.to != lagging
+	}
+
+	calls := makeCalls(t, 4)
+	for i := range calls {
+		network.nodes[keys[0]].ReceiveCall(calls[i])
+	}
+	network.run()
+
+	require.Empty(t, network.logs[lagging].Entries)
+
+	for _, key := range keys[:3] {
+		network.restart(key)
+	}
+
+	network.tamperF = nil
+	network.nodes[lagging].Tick(serverTimeout)
+	network.run()
+
+	entriesExpected := makeEntries(t, map[int][]kayak.KCall{0: calls})
+	assert.ElementsMatch(t, entriesExpected, network.logs[lagging].Entries)
+
+	verifyCertificates(t, network.logs[lagging], keys)
+}
+
+// The test ensures that the entries obtained through the usual sync are
+// stored with their certificates
+func TestSyncedCertificates(t *testing.T) {
+	network, keys := makeSignedNetwork(t, 4)
+
+	lagging := keys[3]
+
+	network.tamperF = func(p packet) (packet, bool) {
+		return p, p.from != lagging && p.to != lagging
+	}
+
+	calls := makeCalls(t, 4)
+	for i := range calls {
+		network.nodes[keys[0]].ReceiveCall(calls[i])
+	}
+	network.run()
+
+	require.Empty(t, network.logs[lagging].Entries)
+
+	network.tamperF = nil
+	network.nodes[lagging].Tick(serverTimeout)
+	network.run()
+
+	entriesExpected := makeEntries(t, map[int][]kayak.KCall{0: calls})
+	assert.ElementsMatch(t, entriesExpected, network.logs[lagging].Entries)
+
+	verifyCertificates(t, network.logs[lagging], keys)
+}
+
+// The test ensures that a client requiring signatures only learns the index
+// from signed tips, so that spoofed addresses do not make a tip quorum
+func TestSignedTips(t *testing.T) {
+	network, keys := makeSignedNetwork(t, 4)
+
+	var requests []kayak.KRequest
+	client := kayak.NewClient(&kayak.KClientConfig{
+		Key:               kayak.KAddress{0xC1},
+		ServerKeys:        keys,
+		CallT:             clientTimeout,
+		BonjourT:          clientTimeout,
+		RequireSignatures: true,
+		SendF: func(to kayak.KAddress, payload interface{}) {
+			if request, ok := payload.(kayak.KRequest); ok {
+				requests = append(requests, request)
+			}
+		},
+		ReturnF: func(payload interface{}) {},
+		TraceF:  func(payload interface{}) {},
+		ErrorF:  func(err error) {},
+	})
+
+	for _, key := range keys {
+		client.ReceiveNet(key, kayak.KTip{Round: 10})
+	}
+	calls := makeCalls(t, 1)
+	client.ReceiveCall(calls[0])
+	require.NotEmpty(t, requests)
+	assert.Equal(t, kayak.KIndex(0), requests[len(requests)-1].Index)
+
+	for _, key := range keys {
+		client.ReceiveNet(key, network.sign(key, kayak.KTip{Round: 10}))
+	}
+	calls = makeCalls(t, 1)
+	client.ReceiveCall(calls[0])
+	assert.Equal(t, kayak.KIndex(10), requests[len(requests)-1].Index)
+}
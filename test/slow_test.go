@@ -259,3 +259,90 @@ func TestKayakCrashCarousel(t *testing.T) {
 	}
 
 }
+
+// The test runs thousands of consensus rounds with periodic leader changes
+// and checks that the protocol maps do not grow with the number of rounds.
+func TestKayakFootprint(t *testing.T) {
+	const batchesN = 20
+	const callsN = 100
+
+	z := zmey.NewZmey(&zmey.Config{
+	// Debug: true,
+	})
+
+	logs := make(map[int]*Storage)
+	wrappers := make(map[int]*KayakWrapper)
+
+	for _, pid := range serverPids {
+		logs[pid] = &Storage{}
+		serverConfig := makeDefaultServerConfig(pid, logs[pid])
+		serverConfig.IndexTolerance = batchesN * callsN
+		wrappers[pid] = NewKayakWrapper(serverConfig)
+		z.SetProcess(pid, wrappers[pid])
+	}
+
+	var ctx context.Context
+	var cancelF context.CancelFunc
+	var err error
+
+	for i := 0; i < batchesN; i++ {
+		leaderPid := kayakToZmey[wrappers[server1Pid].k.Status().Leader]
+
+		pid := serverPids[i%len(serverPids)]
+		if pid == leaderPid {
+			pid = serverPids[(i+1)%len(serverPids)]
+		}
+		messages := map[int][]kayak.KCall{
+			pid: makeCalls(t, callsN),
+		}
+		z.Inject(makeInjectF(messages))
+
+		// Isolate the leader every few batches to force leader changes
+		if i%5 == 4 {
+			z.Filter(func(from, to int) bool {
+				if from == leaderPid && to == leaderPid {
+					return true
+				}
+				return from != leaderPid && to != leaderPid
+			})
+			z.Tick(serverTimeout)
+		} else {
+			z.Filter(nil)
+		}
+
+		ctx, cancelF = context.WithTimeout(context.Background(), 60*time.Second)
+		_, _, err = z.Round(ctx)
+		cancelF()
+
+		require.NoError(t, err)
+
+		z.Filter(nil)
+		z.Tick(serverTimeout)
+
+		ctx, cancelF = context.WithTimeout(context.Background(), 60*time.Second)
+		_, _, err = z.Round(ctx)
+		cancelF()
+
+		require.NoError(t, err)
+
+		for _, pid := range serverPids {
+			footprint := wrappers[pid].k.Footprint()
+			t.Logf("batch %2d, pid %d: %#v", i+1, pid, footprint)
+
+			assert.True(t, footprint.Proposes <= 2, "proposes: %d", footprint.Proposes)
+			assert.True(t, footprint.Writes <= 2, "writes: %d", footprint.Writes)
+			assert.True(t, footprint.Accepts <= 2, "accepts: %d", footprint.Accepts)
+			assert.True(t, footprint.Suspects <= 2, "suspects: %d", footprint.Suspects)
+			assert.True(t, footprint.Heads <= 2, "heads: %d", footprint.Heads)
+			assert.True(t, footprint.SyncSent <= 1, "sync sent: %d", footprint.SyncSent)
+			assert.True(t, footprint.SyncData <= 1, "sync data: %d", footprint.SyncData)
+			assert.True(t, footprint.SyncBuzz <= 1, "sync buzz: %d", footprint.SyncBuzz)
+			assert.True(t, footprint.Confirms <= 1, "confirms: %d", footprint.Confirms)
+		}
+	}
+
+	require.Len(t, logs[server1Pid].Entries, batchesN*callsN)
+	for pid := range logs {
+		assert.Equal(t, logs[server1Pid].Entries, logs[pid].Entries)
+	}
+}
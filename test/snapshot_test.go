@@ -0,0 +1,192 @@
+package test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stratumn/kayak"
+	"github.com/stratumn/zmey"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKayakSnapshotSync(t *testing.T) {
+	z := zmey.NewZmey(&zmey.Config{
+	// Debug: true,
+	})
+
+	logs := make(map[int]*Storage)
+	wrappers := make(map[int]*KayakWrapper)
+
+	var installedIndex kayak.KIndex
+	var installedState []byte
+
+	for _, pid := range serverPids {
+		logs[pid] = &Storage{}
+		config := makeDefaultServerConfig(pid, logs[pid])
+		if pid == server4Pid {
+			config.InstallF = func(index kayak.KIndex, state []byte) {
+				installedIndex = index
+				installedState = state
+			}
+		}
+		wrappers[pid] = NewKayakWrapper(config)
+		z.SetProcess(pid, wrappers[pid])
+	}
+
+	messages1 := map[int][]kayak.KCall{
+		server2Pid: makeCalls(t, 3),
+		server3Pid: makeCalls(t, 3),
+	}
+
+	z.Inject(makeInjectF(messages1))
+
+	filterF := func(from, to int) bool {
+		if from == server4Pid && to == server4Pid {
+			return true
+		}
+		if from == server4Pid || to == server4Pid {
+			return false
+		}
+		return true
+	}
+
+	z.Filter(filterF)
+
+	var ctx context.Context
+	var cancelF context.CancelFunc
+	var responses, traces map[int][]interface{}
+	var err error
+
+	// ========== ROUND 1 ==========
+	ctx, cancelF = context.WithTimeout(context.Background(), 3*time.Second)
+	responses, traces, err = z.Round(ctx)
+	cancelF()
+
+	require.NoError(t, err)
+
+	printOut(t, responses, traces, logs, "R1")
+
+	require.Len(t, logs[server1Pid].Entries, 6)
+	require.Len(t, logs[server4Pid].Entries, 0)
+
+	state := []byte("state at 4")
+	for _, pid := range []int{server1Pid, server2Pid, server3Pid} {
+		require.NoError(t, wrappers[pid].k.Snapshot(4, state))
+	}
+
+	require.Error(t, wrappers[server1Pid].k.Snapshot(4, state))
+	require.Error(t, wrappers[server1Pid].k.Snapshot(7, state))
+
+	// ========== ROUND 2 ==========
+	z.Filter(nil)
+	z.Tick(serverTimeout)
+
+	ctx, cancelF = context.WithTimeout(context.Background(), 3*time.Second)
+	responses, traces, err = z.Round(ctx)
+	cancelF()
+
+	require.NoError(t, err)
+
+	printOut(t, responses, traces, logs, "R2")
+
+	assert.Equal(t, kayak.KIndex(4), installedIndex)
+	assert.Equal(t, state, installedState)
+
+	require.Len(t, logs[server4Pid].Entries, 6)
+	assert.Equal(t, logs[server1Pid].Entries[4:], logs[server4Pid].Entries[4:])
+
+	// ========== ROUND 3 ==========
+	messages3 := map[int][]kayak.KCall{
+		server4Pid: makeCalls(t, 2),
+	}
+
+	z.Inject(makeInjectF(messages3))
+
+	ctx, cancelF = context.WithTimeout(context.Background(), 3*time.Second)
+	responses, traces, err = z.Round(ctx)
+	cancelF()
+
+	require.NoError(t, err)
+
+	printOut(t, responses, traces, logs, "R3")
+
+	assert.ElementsMatch(t,
+		extractTagsFromMessages(t, messages3[server4Pid]),
+		extractTagsFromResponses(t, responses[server4Pid]))
+
+	assert.ElementsMatch(t, makeEntries(t, messages1, messages3), logs[server1Pid].Entries)
+	for pid := range logs {
+		require.Len(t, logs[pid].Entries, 8)
+		assert.Equal(t, logs[server1Pid].Entries[4:], logs[pid].Entries[4:])
+	}
+}
+
+// The test ensures that a snapshot stripped of its buzz by a Byzantine
+// server is not installed with the confirms of the others, which would lose
+// the replay protection of the requests before the snapshot. The lagging
+// server syncs from the Byzantine one, so it stays behind.
+func TestSnapshotStrippedBuzz(t *testing.T) {
+	network, keys := makeSignedNetwork(t, 4, func(c *kayak.KServerConfig) {
+		c.PrivateKey = nil
+	})
+
+	var cut bool
+	var stripped int
+	network.tamperF = func(p packet) (packet, bool) {
+		if cut && (p.from == keys[3] || p.to == keys[3]) {
+			return p, false
+		}
+		if snapshot, ok := p.payload.(kayak.KSnapshot); ok && p.from == keys[0] {
+			snapshot.BuzzBase = snapshot.BuzzHash
+			snapshot.Buzz = nil
+			p.payload = snapshot
+			stripped++
+		}
+		return p, true
+	}
+
+	cut = true
+	calls := makeCalls(t, 6)
+	for i := range calls {
+		network.nodes[keys[0]].ReceiveCall(calls[i])
+	}
+	network.run()
+
+	require.Len(t, network.logs[keys[0]].Entries, 6)
+	require.Empty(t, network.logs[keys[3]].Entries)
+
+	for _, key := range keys[:3] {
+		require.NoError(t, network.nodes[key].Snapshot(4, []byte("state at 4")))
+	}
+
+	cut = false
+	network.nodes[keys[3]].Tick(serverTimeout)
+	network.run()
+
+	require.NotZero(t, stripped)
+	assert.Equal(t, kayak.KRound(0), network.nodes[keys[3]].Status().Round)
+	assert.Zero(t, network.nodes[keys[3]].Footprint().SetBuzz)
+}
+
+// The test ensures that a server keeps a single pending snapshot confirm
+// from each server, and none beyond the index tolerance, so that a server
+// cannot fill the memory with snapshots
+func TestSnapshotConfirmsBounded(t *testing.T) {
+	network, keys := makeSignedNetwork(t, 4)
+
+	for index := kayak.KIndex(1); index <= 10; index++ {
+		network.nodes[keys[3]].ReceiveNet(keys[0], network.sign(keys[0], kayak.KConfirmSnapshot{Index: index, Hash: kayak.KHash{byte(index)}}))
+	}
+	assert.Equal(t, 1, network.nodes[keys[3]].Footprint().SnapshotConfirms)
+
+	network.nodes[keys[3]].ReceiveNet(keys[0], network.sign(keys[0], kayak.KConfirmSnapshot{Index: 5, Hash: kayak.KHash{5}}))
+	network.nodes[keys[3]].ReceiveNet(keys[1], network.sign(keys[1], kayak.KConfirmSnapshot{Index: 5, Hash: kayak.KHash{5}}))
+	assert.Equal(t, 2, network.nodes[keys[3]].Footprint().SnapshotConfirms)
+
+	far := kayak.KIndex(indexTolerance) + 1
+	network.nodes[keys[3]].ReceiveNet(keys[2], network.sign(keys[2], kayak.KConfirmSnapshot{Index: far, Hash: kayak.KHash{1}}))
+	assert.Equal(t, 2, network.nodes[keys[3]].Footprint().SnapshotConfirms)
+}
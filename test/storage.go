@@ -1,9 +1,65 @@
 package test
 
+import (
+	"errors"
+
+	"github.com/stratumn/kayak"
+)
+
 type Storage struct {
-	Entries [][]byte
+	Entries      [][]byte
+	Origins      []kayak.KOrigin
+	Snapshot     *kayak.KSnapshot
+	Certificates map[kayak.KRound]kayak.KCertificate
 }
 
 func (s *Storage) Append(entry []byte) {
 	s.Entries = append(s.Entries, entry)
 }
+
+func (s *Storage) AppendEntry(entry []byte, origin kayak.KOrigin) {
+	s.Entries = append(s.Entries, entry)
+	s.Origins = append(s.Origins, origin)
+}
+
+func (s *Storage) Len() kayak.KIndex {
+	return kayak.KIndex(len(s.Entries))
+}
+
+func (s *Storage) Entry(index kayak.KIndex) ([]byte, kayak.KOrigin, error) {
+	if int(index) >= len(s.Entries) || int(index) >= len(s.Origins) {
+		return nil, kayak.KOrigin{}, errors.New("index out of range")
+	}
+	return s.Entries[index], s.Origins[index], nil
+}
+
+func (s *Storage) SaveSnapshot(snapshot kayak.KSnapshot) {
+	for len(s.Entries) < int(snapshot.Index) {
+		s.Entries = append(s.Entries, nil)
+		s.Origins = append(s.Origins, kayak.KOrigin{})
+	}
+	s.Snapshot = &snapshot
+}
+
+func (s *Storage) LoadSnapshot() (kayak.KSnapshot, bool, error) {
+	if s.Snapshot == nil {
+		return kayak.KSnapshot{}, false, nil
+	}
+	return *s.Snapshot, true, nil
+}
+
+func (s *Storage) SaveCertificate(certificate kayak.KCertificate) {
+	if s.Certificates == nil {
+		s.Certificates = make(map[kayak.KRound]kayak.KCertificate)
+	}
+	s.Certificates[certificate.Round] = certificate
+}
+
+func (s *Storage) LoadCertificate(index kayak.KIndex) (kayak.KCertificate, bool, error) {
+	for round, certificate := range s.Certificates {
+		if index >= round && index < round+kayak.KRound(len(certificate.Buzz)) {
+			return certificate, true, nil
+		}
+	}
+	return kayak.KCertificate{}, false, nil
+}
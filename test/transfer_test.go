@@ -0,0 +1,203 @@
+package test
+
+import (
+	"testing"
+
+	"github.com/stratumn/kayak"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// The test ensures that the leader hands the leadership over once its round
+// in flight is decided, and that the servers move to the next epoch without
+// suspects nor timeouts
+func TestTransferLeadership(t *testing.T) {
+	network, keys := makeSignedNetwork(t, 4)
+
+	proposers := make(map[kayak.KRound]kayak.KAddress)
+	epochs := make(map[kayak.KRound]kayak.KEpoch)
+	var suspects, transfers int
+	network.tamperF = func(p packet) (packet, bool) {
+		payload := p.payload
+		if s, ok := payload.(kayak.KSigned); ok {
+			payload = s.Payload
+		}
+		switch msg := payload.(type) {
+		case kayak.KPropose:
+			proposers[msg.Round] = p.from
+			epochs[msg.Round] = msg.Epoch
+			// The transfer is requested while the round is in flight
+			if msg.Round == 1 && transfers == 0 {
+				network.nodes[keys[0]].ReceiveCall(kayak.KTransferCall{})
+			}
+		case kayak.KSuspect:
+			suspects++
+		case kayak.KTransfer:
+			transfers++
+		}
+		return p, true
+	}
+
+	calls := makeCalls(t, 3)
+
+	network.nodes[keys[0]].ReceiveCall(calls[0])
+	network.run()
+
+	// A follower cannot transfer the leadership
+	network.nodes[keys[1]].ReceiveCall(kayak.KTransferCall{})
+	network.run()
+	require.Zero(t, transfers)
+
+	network.nodes[keys[0]].ReceiveCall(calls[1])
+	network.run()
+
+	assert.Equal(t, 3, transfers)
+	assert.Equal(t, keys[0], proposers[1])
+	assert.Equal(t, kayak.KEpoch(0), epochs[1])
+	for _, key := range keys {
+		status := network.nodes[key].Status()
+		assert.Equal(t, kayak.KEpoch(1), status.Epoch)
+		assert.Equal(t, keys[1], status.Leader)
+	}
+
+	network.nodes[keys[2]].ReceiveCall(calls[2])
+	network.run()
+
+	assert.Equal(t, keys[1], proposers[2])
+	assert.Equal(t, kayak.KEpoch(1), epochs[2])
+	assert.Zero(t, suspects)
+
+	entriesExpected := makeEntries(t, map[int][]kayak.KCall{0: calls})
+	for _, key := range keys {
+		assert.Equal(t, entriesExpected, network.logs[key].Entries)
+	}
+}
+
+// withTransferReturns records the transfer returns of each server
+func withTransferReturns(returns map[kayak.KAddress][]kayak.KTransferReturn) func(c *kayak.KServerConfig) {
+	return func(c *kayak.KServerConfig) {
+		key := c.Key
+		c.ReturnF = func(payload interface{}) {
+			if r, ok := payload.(kayak.KTransferReturn); ok {
+				returns[key] = append(returns[key], r)
+			}
+		}
+	}
+}
+
+// The test ensures that the transfer is cancelled when the leader is
+// suspected before its round in flight is decided
+func TestTransferLeadershipCancelled(t *testing.T) {
+	returns := make(map[kayak.KAddress][]kayak.KTransferReturn)
+	network, keys := makeSignedNetwork(t, 4, withTransferReturns(returns))
+
+	var dropAccepts bool
+	var transfers int
+	network.tamperF = func(p packet) (packet, bool) {
+		payload := p.payload
+		if s, ok := payload.(kayak.KSigned); ok {
+			payload = s.Payload
+		}
+		switch payload.(type) {
+		case kayak.KAccept:
+			if dropAccepts {
+				return p, false
+			}
+		case kayak.KTransfer:
+			transfers++
+		}
+		return p, true
+	}
+
+	calls := makeCalls(t, 1)
+
+	dropAccepts = true
+	network.nodes[keys[0]].ReceiveCall(calls[0])
+	network.run()
+	network.nodes[keys[0]].ReceiveCall(kayak.KTransferCall{Tag: 1})
+	network.run()
+	require.Empty(t, returns)
+
+	dropAccepts = false
+	for i := 0; i < 2; i++ {
+		for _, key := range keys {
+			network.nodes[key].Tick(serverTimeout)
+		}
+		network.run()
+	}
+
+	assert.Zero(t, transfers)
+	assert.Equal(t, []kayak.KTransferReturn{{Tag: 1, Error: kayak.ErrorReasonCancelled}}, returns[keys[0]])
+	for _, key := range keys {
+		assert.Equal(t, kayak.KEpoch(1), network.nodes[key].Status().Epoch)
+	}
+
+	entriesExpected := makeEntries(t, map[int][]kayak.KCall{0: calls})
+	for _, key := range keys {
+		assert.Equal(t, entriesExpected, network.logs[key].Entries)
+	}
+}
+
+// The test ensures that a follower drops a transfer whose round is behind
+// the rounds it decided, as the leader stopped proposing before it
+func TestTransferLeadershipBehind(t *testing.T) {
+	network, keys := makeSignedNetwork(t, 4)
+
+	calls := makeCalls(t, 1)
+	network.nodes[keys[0]].ReceiveCall(calls[0])
+	network.run()
+
+	for _, key := range keys {
+		require.Len(t, network.logs[key].Entries, 1)
+	}
+
+	transfer := kayak.KTransfer{Round: 0, Epoch: 1}
+	network.queue = append(network.queue, packet{from: keys[0], to: keys[1], payload: network.sign(keys[0], transfer)})
+	network.run()
+
+	assert.Equal(t, kayak.KEpoch(0), network.nodes[keys[1]].Status().Epoch)
+}
+
+// The test ensures that the transfer call returns once the handover is sent,
+// and returns the reason it is refused otherwise
+func TestTransferLeadershipRefused(t *testing.T) {
+	returns := make(map[kayak.KAddress][]kayak.KTransferReturn)
+	network, keys := makeSignedNetwork(t, 4, withTransferReturns(returns))
+
+	var requested bool
+	network.tamperF = func(p packet) (packet, bool) {
+		if s, ok := p.payload.(kayak.KSigned); ok {
+			if _, ok := s.Payload.(kayak.KPropose); ok && !requested {
+				// The second call is made while the first one waits for the
+				// round in flight
+				requested = true
+				network.nodes[keys[0]].ReceiveCall(kayak.KTransferCall{Tag: 1})
+				network.nodes[keys[0]].ReceiveCall(kayak.KTransferCall{Tag: 2})
+			}
+		}
+		return p, true
+	}
+
+	calls := makeCalls(t, 1)
+	network.nodes[keys[0]].ReceiveCall(calls[0])
+	network.run()
+
+	network.nodes[keys[2]].ReceiveCall(kayak.KTransferCall{Tag: 3})
+	network.run()
+
+	assert.Equal(t, []kayak.KTransferReturn{
+		{Tag: 2, Error: kayak.ErrorReasonInProgress},
+		{Tag: 1},
+	}, returns[keys[0]])
+	assert.Equal(t, []kayak.KTransferReturn{{Tag: 3, Error: kayak.ErrorReasonNotLeader}}, returns[keys[2]])
+
+	// A single server has nobody to hand the leadership over to
+	single := make(map[kayak.KAddress][]kayak.KTransferReturn)
+	network, keys = makeSignedNetwork(t, 1, withTransferReturns(single))
+	network.nodes[keys[0]].ReceiveCall(kayak.KTransferCall{Tag: 4})
+	network.run()
+
+	assert.Equal(t, []kayak.KTransferReturn{{Tag: 4, Error: kayak.ErrorReasonNoTarget}}, single[keys[0]])
+	assert.Equal(t, kayak.KEpoch(0), network.nodes[keys[0]].Status().Epoch)
+}
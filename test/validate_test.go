@@ -0,0 +1,175 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stratumn/kayak"
+	"github.com/stratumn/zmey"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// validateF accepts payloads with the first byte odd
+func validateF(data kayak.KData) error {
+	if len(data) == 0 || data[0]%2 == 0 {
+		return errors.New("even payload")
+	}
+	return nil
+}
+
+func makeValidatedCalls(t *testing.T, n int, valid bool) []kayak.KCall {
+	calls := makeCalls(t, n)
+	for i := range calls {
+		if valid {
+			calls[i].Payload[0] |= 0x01
+		} else {
+			calls[i].Payload[0] &^= 0x01
+		}
+	}
+	return calls
+}
+
+func TestKayakValidateRequest(t *testing.T) {
+	z := zmey.NewZmey(&zmey.Config{
+	// Debug: true,
+	})
+
+	logs := make(map[int]*Storage)
+
+	for _, pid := range serverPids {
+		logs[pid] = &Storage{}
+		serverConfig := makeDefaultServerConfig(pid, logs[pid])
+		serverConfig.ValidateF = validateF
+		z.SetProcess(pid, NewKayakWrapper(serverConfig))
+	}
+
+	z.SetProcess(client1Pid, NewClientWrapper(makeDefaultClientConfig(client1Pid)))
+
+	validCalls := makeValidatedCalls(t, 4, true)
+	invalidCalls := makeValidatedCalls(t, 4, false)
+
+	messages := map[int][]kayak.KCall{
+		client1Pid: append(append([]kayak.KCall(nil), validCalls...), invalidCalls...),
+	}
+
+	var ctx context.Context
+	var cancelF context.CancelFunc
+	var responses, traces map[int][]interface{}
+	var err error
+
+	// ========== ROUND 1 ==========
+	z.Inject(makeInjectF(messages))
+	ctx, cancelF = context.WithTimeout(context.Background(), 3*time.Second)
+	responses, traces, err = z.Round(ctx)
+	cancelF()
+
+	require.NoError(t, err)
+
+	printOut(t, responses, traces, logs, "R1")
+
+	assert.ElementsMatch(t, extractTagsFromMessages(t, messages[client1Pid]), extractTagsFromResponses(t, responses[client1Pid]))
+
+	invalidTags := make(map[int]bool)
+	for _, tag := range extractTagsFromMessages(t, invalidCalls) {
+		invalidTags[tag] = true
+	}
+	for i := range responses[client1Pid] {
+		kreturn := responses[client1Pid][i].(kayak.KReturn)
+		assert.False(t, kreturn.Timeout)
+		if invalidTags[kreturn.Tag] {
+			assert.Equal(t, kayak.ErrorReasonInvalid, kreturn.Error)
+		} else {
+			assert.Equal(t, kayak.ErrorReasonNone, kreturn.Error)
+		}
+	}
+
+	assert.ElementsMatch(t, makeEntries(t, map[int][]kayak.KCall{client1Pid: validCalls}), logs[server1Pid].Entries)
+	for pid := range logs {
+		assert.Equal(t, logs[server1Pid].Entries, logs[pid].Entries)
+	}
+
+}
+
+func TestKayakValidateProposal(t *testing.T) {
+	z := zmey.NewZmey(&zmey.Config{
+	// Debug: true,
+	})
+
+	logs := make(map[int]*Storage)
+
+	// The leader does not validate and proposes anything
+	for _, pid := range serverPids {
+		logs[pid] = &Storage{}
+		serverConfig := makeDefaultServerConfig(pid, logs[pid])
+		if pid != server1Pid {
+			serverConfig.ValidateF = validateF
+		}
+		z.SetProcess(pid, NewKayakWrapper(serverConfig))
+	}
+
+	z.SetProcess(client1Pid, NewClientWrapper(makeDefaultClientConfig(client1Pid)))
+
+	messagesInvalid := map[int][]kayak.KCall{
+		client1Pid: makeValidatedCalls(t, 1, false),
+	}
+	messagesValid := map[int][]kayak.KCall{
+		client1Pid: makeValidatedCalls(t, 1, true),
+	}
+
+	var ctx context.Context
+	var cancelF context.CancelFunc
+	var responses, traces map[int][]interface{}
+	var err error
+
+	// ========== ROUND 1 ==========
+	z.Inject(makeInjectF(messagesInvalid))
+	ctx, cancelF = context.WithTimeout(context.Background(), 3*time.Second)
+	responses, traces, err = z.Round(ctx)
+	cancelF()
+
+	require.NoError(t, err)
+
+	printOut(t, responses, traces, logs, "R1")
+
+	// Rejected by the quorum of followers
+	require.Len(t, responses[client1Pid], 1)
+	assert.Equal(t, kayak.ErrorReasonInvalid, responses[client1Pid][0].(kayak.KReturn).Error)
+
+	// ========== ROUND 2 ==========
+	z.Inject(makeInjectF(messagesValid))
+	ctx, cancelF = context.WithTimeout(context.Background(), 3*time.Second)
+	responses, traces, err = z.Round(ctx)
+	cancelF()
+
+	require.NoError(t, err)
+
+	printOut(t, responses, traces, logs, "R2")
+
+	// The leader is stuck with the invalid proposal
+	assert.Empty(t, responses[client1Pid])
+	for pid := range logs {
+		assert.Empty(t, logs[pid].Entries)
+	}
+
+	// ========== ROUND 3 ==========
+	z.Tick(serverTimeout)
+	ctx, cancelF = context.WithTimeout(context.Background(), 3*time.Second)
+	responses, traces, err = z.Round(ctx)
+	cancelF()
+
+	require.NoError(t, err)
+
+	printOut(t, responses, traces, logs, "R3")
+
+	assert.ElementsMatch(t, extractTagsFromMessages(t, messagesValid[client1Pid]), extractTagsFromResponses(t, responses[client1Pid]))
+
+	assert.ElementsMatch(t, makeEntries(t, messagesValid), logs[server2Pid].Entries)
+	for pid := range logs {
+		assert.Equal(t, logs[server2Pid].Entries, logs[pid].Entries)
+	}
+
+}
@@ -0,0 +1,256 @@
+package test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/stratumn/kayak"
+	"github.com/stratumn/kayak/wal"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func makeWALDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "kayak-wal")
+	require.NoError(t, err)
+	return dir
+}
+
+func listWALSegments(t *testing.T, dir string) []string {
+	names, err := filepath.Glob(filepath.Join(dir, "*.wal"))
+	require.NoError(t, err)
+	sort.Strings(names)
+	return names
+}
+
+func TestWALAppendAndReopen(t *testing.T) {
+	dir := makeWALDir(t)
+	defer os.RemoveAll(dir)
+
+	w, err := wal.Open(&wal.Config{Dir: dir, SegmentSize: 256})
+	require.NoError(t, err)
+
+	calls := makeCalls(t, 50)
+	for i := range calls {
+		w.AppendEntry(calls[i].Payload, kayak.KOrigin{Nonce: kayak.KNonce{byte(i)}, Index: kayak.KIndex(i), Sequence: uint64(i) + 1, Client: kayak.KAddress{0xCC, byte(i)}, Session: kayak.KNonce{0x5E, byte(i)}})
+	}
+
+	require.NoError(t, w.Err())
+	require.Equal(t, kayak.KIndex(len(calls)), w.Len())
+	require.NoError(t, w.Close())
+
+	assert.True(t, len(listWALSegments(t, dir)) > 1, "expected several segments")
+
+	w, err = wal.Open(&wal.Config{Dir: dir, SegmentSize: 256})
+	require.NoError(t, err)
+	defer w.Close()
+
+	require.Equal(t, kayak.KIndex(len(calls)), w.Len())
+	for i := range calls {
+		data, origin, err := w.Entry(kayak.KIndex(i))
+		require.NoError(t, err)
+		assert.Equal(t, []byte(calls[i].Payload), data)
+		assert.Equal(t, kayak.KOrigin{Nonce: kayak.KNonce{byte(i)}, Index: kayak.KIndex(i), Sequence: uint64(i) + 1, Client: kayak.KAddress{0xCC, byte(i)}, Session: kayak.KNonce{0x5E, byte(i)}}, origin)
+	}
+
+	_, _, err = w.Entry(kayak.KIndex(len(calls)))
+	assert.Equal(t, wal.ErrOutOfRange, err)
+
+	// Appending continues after the last record
+	w.AppendEntry([]byte{0xAB}, kayak.KOrigin{})
+	require.Equal(t, kayak.KIndex(len(calls)+1), w.Len())
+}
+
+func TestWALTornWrite(t *testing.T) {
+	dir := makeWALDir(t)
+	defer os.RemoveAll(dir)
+
+	w, err := wal.Open(&wal.Config{Dir: dir, SyncPolicy: wal.SyncBatch, SyncEvery: 4})
+	require.NoError(t, err)
+
+	calls := makeCalls(t, 10)
+	for i := range calls {
+		w.AppendEntry(calls[i].Payload, kayak.KOrigin{Index: kayak.KIndex(i)})
+	}
+	require.NoError(t, w.Close())
+
+	segments := listWALSegments(t, dir)
+	require.Len(t, segments, 1)
+
+	// Simulate a crash in the middle of the next write
+	f, err := os.OpenFile(segments[0], os.O_WRONLY|os.O_APPEND, 0600)
+	require.NoError(t, err)
+	_, err = f.Write([]byte{0x00, 0x00, 0x00, 0x10, 0xDE, 0xAD})
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	var errs []error
+	w, err = wal.Open(&wal.Config{Dir: dir, ErrorF: func(err error) { errs = append(errs, err) }})
+	require.NoError(t, err)
+
+	assert.Len(t, errs, 1)
+	require.Equal(t, kayak.KIndex(len(calls)), w.Len())
+
+	w.AppendEntry([]byte{0xAB}, kayak.KOrigin{Sequence: 0xAB})
+	require.NoError(t, w.Close())
+
+	w, err = wal.Open(&wal.Config{Dir: dir})
+	require.NoError(t, err)
+	defer w.Close()
+
+	require.Equal(t, kayak.KIndex(len(calls)+1), w.Len())
+	data, origin, err := w.Entry(kayak.KIndex(len(calls)))
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0xAB}, data)
+	assert.Equal(t, kayak.KOrigin{Sequence: 0xAB}, origin)
+}
+
+func TestWALCorruptedSegment(t *testing.T) {
+	dir := makeWALDir(t)
+	defer os.RemoveAll(dir)
+
+	w, err := wal.Open(&wal.Config{Dir: dir, SegmentSize: 128})
+	require.NoError(t, err)
+
+	calls := makeCalls(t, 20)
+	for i := range calls {
+		w.AppendEntry(calls[i].Payload, kayak.KOrigin{})
+	}
+	require.NoError(t, w.Close())
+
+	segments := listWALSegments(t, dir)
+	require.True(t, len(segments) > 1)
+
+	// Flip a byte of the last record in the first segment
+	content, err := ioutil.ReadFile(segments[0])
+	require.NoError(t, err)
+	content[len(content)-1] ^= 0xFF
+	require.NoError(t, ioutil.WriteFile(segments[0], content, 0600))
+
+	_, err = wal.Open(&wal.Config{Dir: dir})
+	require.Error(t, err)
+}
+
+func TestWALCorruptedLastSegment(t *testing.T) {
+	dir := makeWALDir(t)
+	defer os.RemoveAll(dir)
+
+	w, err := wal.Open(&wal.Config{Dir: dir})
+	require.NoError(t, err)
+
+	calls := makeCalls(t, 10)
+	for i := range calls {
+		w.AppendEntry(calls[i].Payload, kayak.KOrigin{})
+	}
+	require.NoError(t, w.Close())
+
+	segments := listWALSegments(t, dir)
+	require.Len(t, segments, 1)
+
+	// Flip a byte of the first record, the next ones must not be cut off
+	content, err := ioutil.ReadFile(segments[0])
+	require.NoError(t, err)
+	content[len(content)/len(calls)-1] ^= 0xFF
+	require.NoError(t, ioutil.WriteFile(segments[0], content, 0600))
+
+	var errs []error
+	_, err = wal.Open(&wal.Config{Dir: dir, ErrorF: func(err error) { errs = append(errs, err) }})
+	require.Error(t, err)
+	assert.Len(t, errs, 1)
+
+	after, err := ioutil.ReadFile(segments[0])
+	require.NoError(t, err)
+	assert.Equal(t, content, after)
+}
+
+func TestWALAppendAfterClose(t *testing.T) {
+	dir := makeWALDir(t)
+	defer os.RemoveAll(dir)
+
+	var errs []error
+	w, err := wal.Open(&wal.Config{Dir: dir, ErrorF: func(err error) { errs = append(errs, err) }})
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	w.AppendEntry([]byte{0xAB}, kayak.KOrigin{Sequence: 0xAB})
+
+	assert.Equal(t, []error{wal.ErrClosed}, errs)
+	assert.Equal(t, kayak.KIndex(0), w.Len())
+}
+
+func TestWALJournal(t *testing.T) {
+	dir := makeWALDir(t)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "journal")
+
+	j, err := wal.OpenJournal(path)
+	require.NoError(t, err)
+
+	for i := 0; i < wal.JournalCompactThreshold+10; i++ {
+		require.NoError(t, j.Record(kayak.KVote{Kind: kayak.VoteWrite, Round: kayak.KRound(i), Hash: kayak.KHash{byte(i)}}))
+	}
+	require.NoError(t, j.Record(kayak.KVote{Kind: kayak.VoteSuspect, Round: 7, Epoch: 1}))
+	require.NoError(t, j.Close())
+
+	j, err = wal.OpenJournal(path)
+	require.NoError(t, err)
+	defer j.Close()
+
+	votes, err := j.Load()
+	require.NoError(t, err)
+
+	last := wal.JournalCompactThreshold + 9
+	assert.ElementsMatch(t, []kayak.KVote{
+		{Kind: kayak.VoteWrite, Round: kayak.KRound(last), Hash: kayak.KHash{byte(last)}},
+		{Kind: kayak.VoteSuspect, Round: 7, Epoch: 1},
+	}, votes)
+}
+
+func TestWALJournalCorrupted(t *testing.T) {
+	dir := makeWALDir(t)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "journal")
+
+	j, err := wal.OpenJournal(path)
+	require.NoError(t, err)
+
+	records := 4
+	for i := 0; i < records; i++ {
+		require.NoError(t, j.Record(kayak.KVote{Kind: kayak.VoteWrite, Round: kayak.KRound(i)}))
+	}
+	require.NoError(t, j.Close())
+
+	content, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+
+	// Flip a byte of the second record, the next ones must not be cut off
+	corrupted := append([]byte{}, content...)
+	corrupted[2*len(content)/records-1] ^= 0xFF
+	require.NoError(t, ioutil.WriteFile(path, corrupted, 0600))
+
+	_, err = wal.OpenJournal(path)
+	require.Error(t, err)
+
+	after, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, corrupted, after)
+
+	// Flip a byte of the last record, it is cut off
+	corrupted = append([]byte{}, content...)
+	corrupted[len(corrupted)-1] ^= 0xFF
+	require.NoError(t, ioutil.WriteFile(path, corrupted, 0600))
+
+	j, err = wal.OpenJournal(path)
+	require.NoError(t, err)
+	defer j.Close()
+
+	votes, err := j.Load()
+	require.NoError(t, err)
+	assert.Equal(t, []kayak.KVote{{Kind: kayak.VoteWrite, Round: kayak.KRound(records - 2)}}, votes)
+}
@@ -0,0 +1,153 @@
+package test
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/stratumn/kayak"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func makeSetWeight(key kayak.KAddress, weight uint64) []byte {
+	payload := append(kayak.MagicSetWeight[:], key[:]...)
+	var weightBytes [8]byte
+	binary.BigEndian.PutUint64(weightBytes[:], weight)
+	return append(payload, weightBytes[:]...)
+}
+
+// The test ensures that the heavy server with one more server reach the
+// quorum, when half of the servers fail
+func TestWeightedQuorum(t *testing.T) {
+	var returns []kayak.KReturn
+	network, keys := makeSignedNetwork(t, 4, func(c *kayak.KServerConfig) {
+		c.Weights = []uint{3, 1, 1, 1}
+		c.ReturnF = func(payload interface{}) {
+			if r, ok := payload.(kayak.KReturn); ok {
+				returns = append(returns, r)
+			}
+		}
+	})
+
+	network.tamperF = func(p packet) (packet, bool) {
+		for _, key := range keys[2:] {
+			if p.from == key || p.to == key {
+				return p, false
+			}
+		}
+		return p, true
+	}
+
+	calls := makeCalls(t, 2)
+	for i := range calls {
+		network.nodes[keys[0]].ReceiveCall(calls[i])
+	}
+	network.run()
+
+	entriesExpected := makeEntries(t, map[int][]kayak.KCall{0: calls})
+	for _, key := range keys[:2] {
+		assert.Equal(t, entriesExpected, network.logs[key].Entries)
+	}
+
+	// Responses of the heavy server and one more server are enough
+	require.Len(t, returns, len(calls))
+	for _, r := range returns {
+		assert.False(t, r.Timeout)
+	}
+}
+
+// The test ensures that the weights are changed by the reconfiguration
+// command. Two servers out of four are not a quorum until the weight of one
+// of them is increased.
+func TestSetWeight(t *testing.T) {
+	network, keys := makeSignedNetwork(t, 4)
+
+	var failed bool
+	network.tamperF = func(p packet) (packet, bool) {
+		if !failed {
+			return p, true
+		}
+		for _, key := range keys[2:] {
+			if p.from == key || p.to == key {
+				return p, false
+			}
+		}
+		return p, true
+	}
+
+	failed = true
+	calls := makeCalls(t, 1)
+	network.nodes[keys[0]].ReceiveCall(calls[0])
+	network.run()
+
+	for _, key := range keys {
+		require.Empty(t, network.logs[key].Entries)
+	}
+
+	// All the servers are back to decide the weight change with the call
+	failed = false
+	for _, key := range keys {
+		network.nodes[key].Tick(serverTimeout)
+	}
+	network.run()
+
+	setWeight := kayak.KCall{Payload: makeSetWeight(keys[0], 3)}
+	network.nodes[keys[0]].ReceiveCall(setWeight)
+	network.run()
+
+	for _, key := range keys {
+		require.Len(t, network.logs[key].Entries, 2)
+	}
+
+	failed = true
+	moreCalls := makeCalls(t, 1)
+	network.nodes[keys[0]].ReceiveCall(moreCalls[0])
+	network.run()
+
+	entriesExpected := append(makeEntries(t, map[int][]kayak.KCall{0: calls}), setWeight.Payload, moreCalls[0].Payload)
+	for _, key := range keys[:2] {
+		assert.Equal(t, entriesExpected, network.logs[key].Entries)
+	}
+}
+
+// The test ensures that weights which do not match the keys are reported
+// rather than completed
+func TestShortWeights(t *testing.T) {
+	var errors []error
+	makeSignedNetwork(t, 4, func(c *kayak.KServerConfig) {
+		c.Weights = []uint{3, 1}
+		c.ErrorF = func(err error) {
+			errors = append(errors, err)
+		}
+	})
+
+	require.Len(t, errors, 4)
+	for _, err := range errors {
+		assert.Contains(t, err.Error(), "2 weights for 4 keys")
+	}
+}
+
+// The test ensures that a certificate is not verified against an empty
+// membership, whose quorum cannot be reached
+func TestEmptyWeightsQuorum(t *testing.T) {
+	network, keys := makeSignedNetwork(t, 4)
+
+	calls := makeCalls(t, 1)
+	network.nodes[keys[0]].ReceiveCall(calls[0])
+	network.run()
+
+	storage := network.logs[keys[0]]
+	require.Len(t, storage.Entries, 1)
+	certificate, found, err := storage.LoadCertificate(0)
+	require.NoError(t, err)
+	require.True(t, found)
+
+	certificate.Signers = nil
+	certificate.Signatures = nil
+	for _, model := range []kayak.KFaultModel{kayak.FaultModelByzantine, kayak.FaultModelCrash} {
+		err := kayak.VerifyWeightedCertificate(certificate, 0, storage.Entries[0], storage.Origins[0], nil, nil, model)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "quorum (0/1) not reached")
+	}
+}
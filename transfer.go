@@ -0,0 +1,142 @@
+package kayak
+
+// receiveTransferCall makes the leader stop proposing, it hands the
+// leadership over once the rounds in flight are decided. The call returns
+// once the handover is sent, or with the reason it is refused or cancelled.
+func (k *Kayak) receiveTransferCall(t Tracer, call KTransferCall) {
+	t = t.Fork("receiveTransferCall")
+
+	if k.key != k.leader() {
+		k.traceF(t.Logf("rejected as not leader"))
+		k.returnF(KTransferReturn{Tag: call.Tag, Error: ErrorReasonNotLeader})
+		return
+	}
+
+	if k.transferring {
+		k.traceF(t.Logf("rejected as already transferring"))
+		k.returnF(KTransferReturn{Tag: call.Tag, Error: ErrorReasonInProgress})
+		return
+	}
+
+	if k.leaderPolicy.Leader(k.epoch+1, k.keys) == k.key {
+		k.traceF(t.Logf("rejected as leader of the next epoch too"))
+		k.returnF(KTransferReturn{Tag: call.Tag, Error: ErrorReasonNoTarget})
+		return
+	}
+
+	k.traceF(t.Logf("stop proposing in %#v", k.epoch))
+	k.transferring = true
+	k.transferEpoch = k.epoch
+	k.transferTag = call.Tag
+}
+
+func (k *Kayak) receiveTransfer(t Tracer, from KAddress, transfer KTransfer, signature []byte) {
+	t = t.Fork("receiveTransfer")
+
+	if _, fromServer := k.rkeys[from]; !fromServer {
+		k.traceF(t.Logf("rejected as not from server"))
+		return
+	}
+
+	if transfer.Epoch != k.epoch+1 {
+		k.traceF(t.Logf("rejected as with incorrect epoch"))
+		return
+	}
+
+	if from != k.leader() {
+		k.traceF(t.Logf("rejected as not from leader"))
+		return
+	}
+
+	k.transfer = &transfer
+	k.transferProof = KSigned{Payload: transfer, Signature: signature}
+	k.traceF(t.Logf("recorded"))
+}
+
+// maybeTransfer sends the handover once the rounds proposed by the leader
+// are decided. The transfer is cancelled if the epoch changes meanwhile.
+func (k *Kayak) maybeTransfer(t Tracer) bool {
+	t = t.Fork("maybeTransfer")
+
+	if !k.transferring {
+		k.traceF(t.Logf("not transferring"))
+		return false
+	}
+
+	if k.epoch != k.transferEpoch {
+		k.traceF(t.Logf("epoch changed from %#v, transfer cancelled", k.transferEpoch))
+		k.transferring = false
+		k.returnF(KTransferReturn{Tag: k.transferTag, Error: ErrorReasonCancelled})
+		return true
+	}
+
+	if len(k.slots) > 0 {
+		k.traceF(t.Logf("waiting for %d slots in flight", len(k.slots)))
+		return false
+	}
+
+	k.traceF(t.Logf("gogo"))
+
+	transfer := KTransfer{Round: k.round, Epoch: k.epoch + 1}
+	for _, key := range k.keys {
+		if key != k.key {
+			k.sendF(key, transfer)
+		}
+	}
+
+	k.transferring = false
+	k.transfer = &transfer
+	if k.privateKey != nil {
+		k.transferProof = sign(k.privateKey, transfer)
+	}
+	k.returnF(KTransferReturn{Tag: k.transferTag})
+	return true
+}
+
+// maybeFollowTransfer moves to the epoch of the handover once the rounds
+// decided by the former leader are decided locally. The handover is dropped
+// if more rounds are decided, as the former leader stopped proposing before
+// it; a round which is never decided leaves the usual leader change to take
+// place. The batches prepared locally are carried, so that no batch which
+// may have been decided in the former epoch is overridden by a write in the
+// new one.
+func (k *Kayak) maybeFollowTransfer(t Tracer) bool {
+	t = t.Fork("maybeFollowTransfer")
+
+	if k.transfer == nil {
+		k.traceF(t.Logf("no transfer"))
+		return false
+	}
+
+	if k.transfer.Epoch != k.epoch+1 {
+		k.traceF(t.Logf("transfer to %#v is outdated, drop", k.transfer.Epoch))
+		k.transfer = nil
+		return true
+	}
+
+	if k.round > k.transfer.Round {
+		k.traceF(t.Logf("transfer at %#v is behind the decided round %#v, drop", k.transfer.Round, k.round))
+		k.transfer = nil
+		return true
+	}
+
+	if k.round < k.transfer.Round {
+		k.traceF(t.Logf("waiting for %#v to be decided", k.transfer.Round))
+		return false
+	}
+
+	k.traceF(t.Logf("gogo"))
+
+	k.transfer = nil
+	k.advanceEpoch(t, k.epoch+1)
+
+	k.collectCarried(t, map[KAddress]KSuspect{k.key: {Prepared: k.listPrepared()}})
+	k.viewEpoch = k.epoch
+
+	if k.key == k.leader() && k.privateKey != nil {
+		proof := k.transferProof
+		k.view = &KNewView{Epoch: k.epoch, Transfer: &proof}
+	}
+
+	return true
+}
@@ -1,6 +1,7 @@
 package kayak
 
 import (
+	"crypto/ed25519"
 	"crypto/sha256"
 	"fmt"
 	"strings"
@@ -17,6 +18,49 @@ const (
 	LCStateAlert
 )
 
+const (
+	VoteWrite = KVoteKind(iota)
+	VoteAccept
+	VoteSuspect
+)
+
+const (
+	ErrorReasonNone = KErrorReason(iota)
+	ErrorReasonInvalid
+	ErrorReasonReplay
+	ErrorReasonAhead
+	ErrorReasonTooOld
+	ErrorReasonNotAllowed
+	ErrorReasonTooLarge
+	ErrorReasonOverloaded
+	ErrorReasonRateLimited
+	ErrorReasonNotLeader
+	ErrorReasonInProgress
+	ErrorReasonNoTarget
+	ErrorReasonCancelled
+)
+
+const (
+	// FaultModelByzantine tolerates f arbitrary faults out of 3f+1
+	FaultModelByzantine = KFaultModel(iota)
+	// FaultModelCrash tolerates f crashes out of 2f+1
+	FaultModelCrash
+)
+
+// DefaultMaxRead is the number of entries a read may ask for if MaxRead is
+// not set
+const DefaultMaxRead = 1024
+
+// DefaultMaxSessions is the number of sessions kept if MaxSessions is not
+// set
+const DefaultMaxSessions = 4096
+
+// MaxBatchSize and MaxBatchBytes bound the batches whatever the limits set
+// on the leader, the followers reject the batches beyond them only, as the
+// configured limits may differ from one server to another
+const MaxBatchSize = 4096
+const MaxBatchBytes = 64 << 20
+
 const NonceSize = 16
 const AddressSize = 32
 
@@ -40,6 +84,31 @@ var MagicRemoveProcess = [32]byte{
 	0x20, 0x63, 0x26, 0x30, 0x28, 0x71, 0xEA, 0x68,
 }
 
+// MagicSetWeight is followed by the address of the process and its new
+// voting weight as 8 bytes big-endian
+var MagicSetWeight = [32]byte{
+	0x5E, 0x77, 0xE1, 0x6B, 0x4A, 0x0C, 0x9D, 0x3E,
+	0x71, 0xB2, 0x08, 0xD5, 0x66, 0xA9, 0x1F, 0xC4,
+	0x93, 0x2D, 0xE0, 0x58, 0x0B, 0x7A, 0xC6, 0x31,
+	0x84, 0xF9, 0x12, 0x6E, 0xAD, 0x45, 0xBB, 0x07,
+}
+
+// MagicAddLearner is followed by the address of the learner, which receives
+// the decided entries without voting
+var MagicAddLearner = [32]byte{
+	0x1E, 0xA2, 0x4E, 0x50, 0xC7, 0x3B, 0x88, 0x0D,
+	0x92, 0xF6, 0x5A, 0x17, 0xE4, 0x69, 0x2C, 0xB1,
+	0x0F, 0xD8, 0x73, 0xA5, 0x3E, 0x91, 0x6C, 0x24,
+	0xBA, 0x57, 0x08, 0xED, 0x46, 0x9B, 0x31, 0xC2,
+}
+
+var MagicRemoveLearner = [32]byte{
+	0x7C, 0x05, 0xB9, 0x62, 0xD1, 0x2E, 0x4F, 0x93,
+	0x38, 0xAB, 0x6D, 0xF0, 0x15, 0x87, 0xC3, 0x5E,
+	0xE9, 0x20, 0x74, 0x1B, 0xA6, 0x4D, 0xF8, 0x03,
+	0x5B, 0xCE, 0x97, 0x32, 0x6A, 0x11, 0xD4, 0x8F,
+}
+
 type KRound uint
 type KIndex = KRound
 type KEpoch uint
@@ -52,61 +121,183 @@ type KAddress [AddressSize]byte
 
 type KConsensusState int
 type KLCState int
+type KVoteKind int
+type KErrorReason int
+type KFaultModel int
 
 type KStorage interface {
 	Append([]byte)
 }
 
-type KServerConfig struct {
-	Key            KAddress
-	Keys           []KAddress
-	Storage        KStorage
-	RequestT       uint
-	CallT          uint
-	WhatsupT       uint
-	BonjourT       uint
-	IndexTolerance uint
-	AllowExternal  bool
-	SendF          func(to KAddress, payload interface{})
-	ReturnF        func(payload interface{})
-	TraceF         func(payload interface{})
-	ErrorF         func(error)
-	ByzantineFlags int
+// KRecoverableStorage is a KStorage which can be read back. When supplied,
+// Kayak stores the origin alongside each entry and rebuilds its log from the
+// storage on creation, so that a restarted process does not have to sync
+// everything over the network
+type KRecoverableStorage interface {
+	KStorage
+	AppendEntry(data []byte, origin KOrigin)
+	Len() KIndex
+	Entry(index KIndex) ([]byte, KOrigin, error)
 }
 
-type KClientConfig struct {
-	Key            KAddress
-	ServerKeys     []KAddress
-	CallT          uint
-	BonjourT       uint
-	SendF          func(to KAddress, payload interface{})
-	ReturnF        func(payload interface{})
-	TraceF         func(payload interface{})
-	ErrorF         func(error)
-	ByzantineFlags int
+// KSnapshotStorage is a KRecoverableStorage which also keeps the latest
+// snapshot. Once a snapshot is saved, the entries before its index may be
+// dropped, and the next entry is appended at the snapshot index if the
+// storage holds fewer entries. Len and Entry always use absolute indexes.
+type KSnapshotStorage interface {
+	KRecoverableStorage
+	SaveSnapshot(snapshot KSnapshot)
+	LoadSnapshot() (KSnapshot, bool, error)
+}
+
+// KCertificateStorage is a KStorage which also keeps the commit certificates
+// of the batches. The certificates are only available when the messages are
+// signed. LoadCertificate returns the certificate of the batch holding the
+// entry at the index, the certificates are restored with the log if the
+// storage is a KRecoverableStorage too.
+type KCertificateStorage interface {
+	KStorage
+	SaveCertificate(certificate KCertificate)
+	LoadCertificate(index KIndex) (KCertificate, bool, error)
+}
+
+// KVoteJournal durably records votes before they are sent. Load returns
+// the recorded votes, of which only the last one of each kind matters.
+// A process restarted with its journal never sends a vote conflicting with
+// the ones sent before the crash
+type KVoteJournal interface {
+	Record(vote KVote) error
+	Load() ([]KVote, error)
+}
+
+// KLeaderPolicy elects the leader of each epoch. All the servers have to
+// elect the same leader, so the election may only depend on the arguments:
+// the servers which catch up an epoch or restart do not go through the
+// leader changes in between.
+type KLeaderPolicy interface {
+	Leader(epoch KEpoch, keys []KAddress) KAddress
+}
+
+type KServerConfig struct {
+	Key             KAddress
+	Keys            []KAddress
+	Weights         []uint
+	Learners        []KAddress
+	FaultModel      KFaultModel
+	Storage         KStorage
+	Journal         KVoteJournal
+	RequestT        uint
+	CallT           uint
+	WhatsupT        uint
+	BonjourT        uint
+	IndexTolerance  uint
+	BatchSize       uint
+	BatchBytes      uint
+	BatchT          uint
+	PipelineDepth   uint
+	LeaderPolicy    KLeaderPolicy
+	AllowExternal   bool
+	ForwardRequests bool
+	MaxJobs         uint
+	MaxClientJobs   uint
+	MaxPayload      uint
+	MaxRead         uint
+	MaxSessions     uint
+	ClientRateT     uint
+	ClientBurst     uint
+	SendF           func(to KAddress, payload interface{})
+	ReturnF         func(payload interface{})
+	TraceF          func(payload interface{})
+	ErrorF          func(error)
+	InstallF        func(index KIndex, state []byte)
+	ValidateF       func(KData) error
+	PrivateKey      ed25519.PrivateKey
+	CertifiedSync   bool
+	ByzantineFlags  int
 }
 
+type KClientConfig struct {
+	Key               KAddress
+	ServerKeys        []KAddress
+	ServerWeights     []uint
+	FaultModel        KFaultModel
+	CallT             uint
+	BonjourT          uint
+	ForwardT          uint
+	Sessions          bool
+	RequireSignatures bool
+	SendF             func(to KAddress, payload interface{})
+	ReturnF           func(payload interface{})
+	TraceF            func(payload interface{})
+	ErrorF            func(error)
+	ByzantineFlags    int
+}
+
+// KCall with a sequence number retries the call returned with it, the
+// sequence number is assigned by the client otherwise
 type KCall struct {
-	Tag     int
-	Payload KData
+	Tag      int
+	Payload  KData
+	Sequence uint64
 }
 
 type KReturn struct {
+	Tag      int
+	Index    KIndex
+	Sequence uint64
+	Timeout  bool
+	Error    KErrorReason
+}
+
+// KReadCall asks for the entries First, First+1, ..., Last-1
+type KReadCall struct {
+	Tag   int
+	First KIndex
+	Last  KIndex
+}
+
+// KTransferCall asks the leader to hand the leadership over to the leader of
+// the next epoch
+type KTransferCall struct {
+	Tag int
+}
+
+// KTransferReturn reports that the leadership is handed over, or why it is
+// not
+type KTransferReturn struct {
+	Tag   int
+	Error KErrorReason
+}
+
+// KReadReturn holds the entries from First on, and the cumulative data hash
+// of the log up to the last one
+type KReadReturn struct {
 	Tag     int
-	Index   KIndex
+	First   KIndex
+	Data    []KData
+	Hash    KHash
 	Timeout bool
+	Error   KErrorReason
 }
 
+// KRequest with a sequence number is sent by Client in the Session it
+// opened, it is decided once in the session
 type KRequest struct {
-	Nonce   KNonce
-	Payload KData
-	Index   KIndex
+	Nonce    KNonce
+	Payload  KData
+	Index    KIndex
+	Sequence uint64
+	Client   KAddress
+	Session  KNonce
 }
 
+// KJob is Forwarded if a server claims that From sent the request, it is not
+// charged to the quotas of From
 type KJob struct {
 	From      KAddress
 	Timestamp KTime
 	Request   KRequest
+	Forwarded bool
 }
 
 type KTicket struct {
@@ -114,21 +305,48 @@ type KTicket struct {
 	Tag       int
 	Timestamp KTime
 	Payload   KData
+	Sequence  uint64
+}
+
+type KReadTicket struct {
+	Nonce     KNonce
+	Tag       int
+	Timestamp KTime
+	First     KIndex
+	Last      KIndex
+}
+
+type KRead struct {
+	Nonce KNonce
+	First KIndex
+	Last  KIndex
 }
 
+// KEntries with an error reason rejects the read, it holds no data so that
+// the rejections of all servers are the same
+type KEntries struct {
+	Nonce KNonce
+	First KIndex
+	Data  []KData
+	Hash  KHash
+	Error KErrorReason
+}
+
+// KResponse with an error reason rejects the request, its index is always
+// zero so that the rejections of all servers are the same
 type KResponse struct {
 	Index KRound
 	Nonce KNonce
-	// TODO
-	// ErrorIDReplay bool
-	// ErrorIDAhead  bool
-	// ErrorInvalid  bool
+	Error KErrorReason
 }
 
+// KPropose carries an ordered batch of jobs. The batch proposed at round r
+// is decided as entries r, r+1, ... and the next batch is proposed at the
+// round following the last entry
 type KPropose struct {
 	Round KRound
 	Epoch KEpoch
-	Job   KJob
+	Jobs  []KJob
 }
 
 type KWrite struct {
@@ -143,14 +361,62 @@ type KAccept struct {
 	Hash  KHash
 }
 
+// KLoad is also sent alone by a follower forwarding the request of the
+// client to the leader
 type KLoad struct {
 	From    KAddress
 	Request KRequest
 }
 
+// KSuspect carries the batches the sender has seen prepared, so that the
+// next leader proposes again the ones which may have been decided
 type KSuspect struct {
+	Epoch    KEpoch
+	Loads    []KLoad
+	Prepared []KPrepared
+}
+
+// KTransfer is sent by the leader which hands the leadership over, once the
+// rounds it proposed are decided up to Round. The servers move to Epoch
+// without a leader change.
+type KTransfer struct {
+	Round KRound
 	Epoch KEpoch
-	Loads []KLoad
+}
+
+// KNewView proves the batches to carry into Epoch. The leader entering the
+// epoch by a leader change sends the signed suspects of the quorum it
+// collected the carried batches from, in the order of their Senders. If the
+// epoch has been entered by a transfer, Transfer holds instead the handover
+// signed by the former leader.
+type KNewView struct {
+	Epoch    KEpoch
+	Senders  []KAddress
+	Suspects []KSigned
+	Transfer *KSigned
+}
+
+// KNeedView asks the leader for the new view of the epoch, as a server which
+// missed it cannot write in the epoch
+type KNeedView struct {
+	Epoch KEpoch
+}
+
+// KPrepared is a batch which reached the write quorum at the round in the
+// epoch. The signed writes prove it if the messages are signed.
+type KPrepared struct {
+	Round      KRound
+	Epoch      KEpoch
+	Jobs       []KJob
+	Signers    []byte
+	Signatures [][]byte
+}
+
+type KVote struct {
+	Kind  KVoteKind
+	Round KRound
+	Epoch KEpoch
+	Hash  KHash
 }
 
 type KWhatsup struct{}
@@ -165,19 +431,25 @@ type KTip struct {
 	Round KRound
 }
 
+// KNeed with Certified set asks for the commit certificates of the entries
 type KNeed struct {
-	Last  KRound
-	First KRound
+	Last      KRound
+	First     KRound
+	Certified bool
 }
 
 type KEnsure struct {
 	Last KRound
 }
 
+// KChunk holds the commit certificates known for the batches of the
+// entries, in the order of their rounds
 type KChunk struct {
-	Last KRound
-	Data []KData
-	Buzz []KHash
+	Last         KRound
+	Data         []KData
+	Buzz         []KHash
+	Origins      []KOrigin
+	Certificates []KCertificate
 }
 
 type KConfirm struct {
@@ -186,6 +458,89 @@ type KConfirm struct {
 	BuzzHash KHash
 }
 
+type KSnapshot struct {
+	Index    KIndex
+	State    KData
+	Keys     []KAddress
+	Weights  []uint
+	Learners []KAddress
+	DataHash KHash
+	BuzzHash KHash
+	BuzzBase KHash
+	Buzz     []KHash
+	Sessions []KSession
+}
+
+type KEnsureSnapshot struct {
+	Index KIndex
+}
+
+type KConfirmSnapshot struct {
+	Index KIndex
+	Hash  KHash
+}
+
+// KCertificate proves that a batch has been decided. It holds the signed
+// accepts of a quorum for the batch, the signers are given as a bitmap over
+// the membership at the round of the batch, and the signatures follow the
+// order of the bitmap.
+type KCertificate struct {
+	Round      KRound
+	Epoch      KEpoch
+	Buzz       []KHash
+	Signers    []byte
+	Signatures [][]byte
+}
+
+// KOrigin completes the data of a decided entry into its request, the buzz
+// of the entry is the hash of the request
+type KOrigin struct {
+	Nonce    KNonce
+	Index    KIndex
+	Sequence uint64
+	Client   KAddress
+	Session  KNonce
+}
+
+// KSession is the last sequence number decided in the session of the
+// client, with the index it has been decided at
+type KSession struct {
+	Client   KAddress
+	Session  KNonce
+	Sequence uint64
+	Index    KIndex
+}
+
+// KSigned wraps a message signed by the sender. The servers configured with
+// a private key sign the messages which other processes act upon, and
+// require them to be signed by the key they are received from. Without a
+// private key the batches prepared in an epoch are not carried into the
+// next one, as they cannot be proven.
+type KSigned struct {
+	Payload   interface{}
+	Signature []byte
+}
+
+// KFootprint holds the number of entries in the log and in the top level of
+// the protocol maps, which are keyed by round or epoch
+type KFootprint struct {
+	LogData          int
+	LogBuzz          int
+	SetBuzz          int
+	Jobs             int
+	Proposes         int
+	Writes           int
+	Accepts          int
+	Suspects         int
+	Heads            int
+	SyncSent         int
+	SyncData         int
+	SyncBuzz         int
+	Confirms         int
+	Snapshots        int
+	SnapshotConfirms int
+}
+
 type KStatus struct {
 	Round  KRound
 	Epoch  KEpoch
@@ -253,6 +608,63 @@ func (k KLCState) GoString() string {
 	}
 }
 
+func (k KVoteKind) GoString() string {
+	switch k {
+	case VoteWrite:
+		return "Write"
+	case VoteAccept:
+		return "Accept"
+	case VoteSuspect:
+		return "Suspect"
+	default:
+		return "INVALID"
+	}
+}
+
+func (k KErrorReason) GoString() string {
+	switch k {
+	case ErrorReasonNone:
+		return "None"
+	case ErrorReasonInvalid:
+		return "Invalid"
+	case ErrorReasonReplay:
+		return "Replay"
+	case ErrorReasonAhead:
+		return "Ahead"
+	case ErrorReasonTooOld:
+		return "TooOld"
+	case ErrorReasonNotAllowed:
+		return "NotAllowed"
+	case ErrorReasonTooLarge:
+		return "TooLarge"
+	case ErrorReasonOverloaded:
+		return "Overloaded"
+	case ErrorReasonRateLimited:
+		return "RateLimited"
+	case ErrorReasonNotLeader:
+		return "NotLeader"
+	case ErrorReasonInProgress:
+		return "InProgress"
+	case ErrorReasonNoTarget:
+		return "NoTarget"
+	case ErrorReasonCancelled:
+		return "Cancelled"
+	default:
+		return "INVALID"
+	}
+}
+
+func (k KFaultModel) GoString() string {
+	switch k {
+	case FaultModelByzantine:
+		return "Byzantine"
+	case FaultModelCrash:
+		return "Crash"
+	default:
+		return "INVALID"
+	}
+}
+
 func (k KCall) GoString() string {
 	return fmt.Sprintf("KCall of %d with payload %#v", k.Tag, k.Payload)
 }
@@ -261,9 +673,37 @@ func (k KReturn) GoString() string {
 	if k.Timeout {
 		return fmt.Sprintf("KReturn of %d (timeout)", k.Tag)
 	}
+	if k.Error != ErrorReasonNone {
+		return fmt.Sprintf("KReturn of %d (rejected: %#v)", k.Tag, k.Error)
+	}
 	return fmt.Sprintf("KReturn of %d to put at index %d", k.Tag, k.Index)
 }
 
+func (k KReadCall) GoString() string {
+	return fmt.Sprintf("KReadCall of %d for entries from %d to %d", k.Tag, k.First, k.Last)
+}
+
+func (k KTransferCall) GoString() string {
+	return fmt.Sprintf("KTransferCall of %d", k.Tag)
+}
+
+func (k KTransferReturn) GoString() string {
+	if k.Error != ErrorReasonNone {
+		return fmt.Sprintf("KTransferReturn of %d (rejected: %#v)", k.Tag, k.Error)
+	}
+	return fmt.Sprintf("KTransferReturn of %d", k.Tag)
+}
+
+func (k KReadReturn) GoString() string {
+	if k.Timeout {
+		return fmt.Sprintf("KReadReturn of %d (timeout)", k.Tag)
+	}
+	if k.Error != ErrorReasonNone {
+		return fmt.Sprintf("KReadReturn of %d (rejected: %#v)", k.Tag, k.Error)
+	}
+	return fmt.Sprintf("KReadReturn of %d with %d entries from %d", k.Tag, len(k.Data), k.First)
+}
+
 func (k KRequest) GoString() string {
 	return fmt.Sprintf("KRequest %#v with payload %#v and index %#v", k.Nonce, k.Payload, k.Index)
 }
@@ -276,12 +716,33 @@ func (k KTicket) GoString() string {
 	return fmt.Sprintf("KTicket %#v with tag %d created at %#v with payload %#v", k.Nonce, k.Tag, k.Timestamp, k.Payload)
 }
 
+func (k KReadTicket) GoString() string {
+	return fmt.Sprintf("KReadTicket %#v with tag %d created at %#v for entries from %d to %d", k.Nonce, k.Tag, k.Timestamp, k.First, k.Last)
+}
+
+func (k KRead) GoString() string {
+	return fmt.Sprintf("KRead %#v for entries from %d to %d", k.Nonce, k.First, k.Last)
+}
+
+func (k KEntries) GoString() string {
+	if k.Error != ErrorReasonNone {
+		return fmt.Sprintf("KEntries %#v rejected: %#v", k.Nonce, k.Error)
+	}
+	return fmt.Sprintf("KEntries %#v with %d entries from %d and hash %#v", k.Nonce, len(k.Data), k.First, k.Hash)
+}
+
 func (k KResponse) GoString() string {
+	if k.Error != ErrorReasonNone {
+		return fmt.Sprintf("KResponse %#v rejected: %#v", k.Nonce, k.Error)
+	}
 	return fmt.Sprintf("KResponse %#v at index %d", k.Nonce, k.Index)
 }
 
 func (k KPropose) GoString() string {
-	return fmt.Sprintf("KPropose (%4d:%-4d) with %#v", k.Round, k.Epoch, k.Job)
+	if len(k.Jobs) == 1 {
+		return fmt.Sprintf("KPropose (%4d:%-4d) with %#v", k.Round, k.Epoch, k.Jobs[0])
+	}
+	return fmt.Sprintf("KPropose (%4d:%-4d) with %d jobs", k.Round, k.Epoch, len(k.Jobs))
 }
 
 func (k KWrite) GoString() string {
@@ -297,7 +758,27 @@ func (k KLoad) GoString() string {
 }
 
 func (k KSuspect) GoString() string {
-	return fmt.Sprintf("KSuspect to transition to %#v with %d loads", k.Epoch, len(k.Loads))
+	return fmt.Sprintf("KSuspect to transition to %#v with %d loads and %d prepared", k.Epoch, len(k.Loads), len(k.Prepared))
+}
+
+func (k KTransfer) GoString() string {
+	return fmt.Sprintf("KTransfer to transition to %#v after %#v", k.Epoch, k.Round)
+}
+
+func (k KNewView) GoString() string {
+	return fmt.Sprintf("KNewView of %#v with %d suspects", k.Epoch, len(k.Suspects))
+}
+
+func (k KNeedView) GoString() string {
+	return fmt.Sprintf("KNeedView of %#v", k.Epoch)
+}
+
+func (k KPrepared) GoString() string {
+	return fmt.Sprintf("KPrepared (%4d:%-4d) with %d jobs and %d signatures", k.Round, k.Epoch, len(k.Jobs), len(k.Signatures))
+}
+
+func (k KVote) GoString() string {
+	return fmt.Sprintf("KVote %#v (%4d:%-4d) with hash %#v", k.Kind, k.Round, k.Epoch, k.Hash)
 }
 
 func (k KWhatsup) GoString() string {
@@ -332,6 +813,35 @@ func (k KConfirm) GoString() string {
 	return fmt.Sprintf("KConfirm of %#v with data hash %#v and buzz hash %#v", k.Last, k.DataHash, k.BuzzHash)
 }
 
+func (k KSnapshot) GoString() string {
+	return fmt.Sprintf("KSnapshot at %#v with state %#v, %d keys and %d buzz", k.Index, k.State, len(k.Keys), len(k.Buzz))
+}
+
+func (k KEnsureSnapshot) GoString() string {
+	return fmt.Sprintf("KEnsureSnapshot requesting hash at %#v", k.Index)
+}
+
+func (k KConfirmSnapshot) GoString() string {
+	return fmt.Sprintf("KConfirmSnapshot of %#v with hash %#v", k.Index, k.Hash)
+}
+
+func (k KFootprint) GoString() string {
+	return fmt.Sprintf("KFootprint: log %d/%d, buzz set %d, jobs %d, proposes %d, writes %d, accepts %d, suspects %d, heads %d, sync %d/%d/%d, confirms %d, snapshots %d/%d",
+		k.LogData, k.LogBuzz, k.SetBuzz, k.Jobs, k.Proposes, k.Writes, k.Accepts, k.Suspects, k.Heads, k.SyncSent, k.SyncData, k.SyncBuzz, k.Confirms, k.Snapshots, k.SnapshotConfirms)
+}
+
+func (k KCertificate) GoString() string {
+	return fmt.Sprintf("KCertificate (%4d:%-4d) of %d entries with %d signatures", k.Round, k.Epoch, len(k.Buzz), len(k.Signatures))
+}
+
+func (k KOrigin) GoString() string {
+	return fmt.Sprintf("KOrigin with nonce %#v, index %d and sequence %d", k.Nonce, k.Index, k.Sequence)
+}
+
+func (k KSigned) GoString() string {
+	return fmt.Sprintf("KSigned %#v", k.Payload)
+}
+
 func (k KStatus) GoString() string {
 	keysStr := make([]string, len(k.Keys))
 	for i, key := range k.Keys {
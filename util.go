@@ -63,3 +63,27 @@ func cumBuzzHash(base KHash, items ...KHash) KHash {
 
 	return cumHash
 }
+
+// originOf returns the origin of the entry decided for the request
+func originOf(request KRequest) KOrigin {
+	return KOrigin{
+		Nonce:    request.Nonce,
+		Index:    request.Index,
+		Sequence: request.Sequence,
+		Client:   request.Client,
+		Session:  request.Session,
+	}
+}
+
+// requestOf rebuilds the request of the entry, its hash is the buzz of the
+// entry
+func requestOf(data KData, origin KOrigin) KRequest {
+	return KRequest{
+		Nonce:    origin.Nonce,
+		Payload:  data,
+		Index:    origin.Index,
+		Sequence: origin.Sequence,
+		Client:   origin.Client,
+		Session:  origin.Session,
+	}
+}
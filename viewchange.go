@@ -0,0 +1,278 @@
+package kayak
+
+import "sort"
+
+// preparedHash is the hash written for the batch
+func preparedHash(prepared KPrepared) KHash {
+	buzz := make([]KHash, len(prepared.Jobs))
+	for i := range prepared.Jobs {
+		buzz[i] = hash(prepared.Jobs[i].Request)
+	}
+	return cumBuzzHash(KHash{}, buzz...)
+}
+
+// listPrepared returns the batches prepared from the current round on, in
+// the order of their rounds
+func (k *Kayak) listPrepared() []KPrepared {
+	var list []KPrepared
+	for round, prepared := range k.prepared {
+		if round >= k.round {
+			list = append(list, prepared)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Round < list[j].Round
+	})
+	return list
+}
+
+// checkPrepared verifies the write quorum of the batch. The view change
+// requires signatures, so the batches are refused without them.
+func (k *Kayak) checkPrepared(t Tracer, prepared KPrepared) bool {
+	if len(prepared.Jobs) == 0 {
+		k.traceF(t.Logf("empty %#v", prepared))
+		return false
+	}
+
+	if k.privateKey == nil {
+		k.traceF(t.Logf("unsigned %#v", prepared))
+		return false
+	}
+
+	write := KWrite{Round: prepared.Round, Epoch: prepared.Epoch, Hash: preparedHash(prepared)}
+	keys, weights := k.keysAtRound(prepared.Round)
+	if err := verifySignatures(signatureDigest(write), prepared.Signers, prepared.Signatures, keys, weights, k.faultModel); err != nil {
+		k.traceF(t.Logf("invalid %#v: %s", prepared, err))
+		return false
+	}
+
+	return true
+}
+
+// collectCarried picks the batches the new leader has to propose again. A
+// batch decided somewhere has been prepared by a quorum, so at least one
+// correct sender of the suspect quorum reports it. At each round the batch
+// prepared in the most recent epoch is picked, as no other batch may have
+// been decided since. The batches are picked as long as they follow each
+// other from the current round, since decisions are made in order.
+func (k *Kayak) collectCarried(t Tracer, suspects map[KAddress]KSuspect) {
+	t = t.Fork("collectCarried")
+
+	latest := make(map[KRound]KPrepared)
+	for _, suspect := range suspects {
+		for _, prepared := range suspect.Prepared {
+			if last, found := latest[prepared.Round]; found && last.Epoch >= prepared.Epoch {
+				continue
+			}
+			latest[prepared.Round] = prepared
+		}
+	}
+
+	k.carried = make(map[KRound]KPrepared)
+
+	round := k.round
+	for {
+		prepared, found := latest[round]
+		if !found {
+			break
+		}
+		k.traceF(t.Logf("carry %#v", prepared))
+		k.carried[round] = prepared
+		round += KRound(len(prepared.Jobs))
+	}
+
+	k.traceF(t.Logf("carried %d batches", len(k.carried)))
+}
+
+// proposeCarried proposes again in the current epoch a batch which may have
+// been decided in a previous one
+func (k *Kayak) proposeCarried(t Tracer, carried KPrepared) bool {
+	t = t.Fork("proposeCarried")
+
+	k.traceF(t.Logf("gogo, propose again %#v", carried))
+
+	propose := KPropose{Round: carried.Round, Epoch: k.epoch, Jobs: carried.Jobs}
+	for _, key := range k.keys {
+		k.sendF(key, propose)
+	}
+
+	k.traceF(t.Logf("add slot at %#v in %#v state", carried.Round, ConsensusStateIdlePropose))
+	k.slots = append(k.slots, newSlot(carried.Round, ConsensusStateIdlePropose, carried.Jobs))
+	return true
+}
+
+// announceView is run by the leader entering the epoch by a leader change.
+// The batches are carried from the signed suspects of the epoch, which are
+// sent to the followers so that they carry the same ones.
+func (k *Kayak) announceView(t Tracer) {
+	t = t.Fork("announceView")
+
+	if k.privateKey == nil {
+		k.traceF(t.Logf("no view change without signatures"))
+		return
+	}
+
+	view := KNewView{Epoch: k.epoch}
+	for _, key := range k.keys {
+		suspect, found := k.suspects[k.epoch][key]
+		signature := k.suspectSignatures[k.epoch][key]
+		if !found || signature == nil {
+			continue
+		}
+		view.Senders = append(view.Senders, key)
+		view.Suspects = append(view.Suspects, KSigned{Payload: suspect, Signature: signature})
+	}
+
+	k.traceF(t.Logf("gogo, made %#v", view))
+
+	k.view = &view
+	k.carryView(t, view)
+
+	for _, key := range k.keys {
+		if key != k.key {
+			k.sendF(key, view)
+		}
+	}
+}
+
+// carryView picks the batches to propose again in the epoch of the view. A
+// view proven by a transfer carries the batches prepared locally, as the
+// followers of the transfer do.
+func (k *Kayak) carryView(t Tracer, view KNewView) {
+	suspects := make(map[KAddress]KSuspect)
+	if view.Transfer != nil {
+		suspects[k.key] = KSuspect{Prepared: k.listPrepared()}
+	}
+	for i, sender := range view.Senders {
+		suspects[sender] = view.Suspects[i].Payload.(KSuspect)
+	}
+
+	k.collectCarried(t, suspects)
+	k.viewEpoch = view.Epoch
+}
+
+// checkView verifies that the suspects of the view are signed by a quorum
+// and carry valid batches, or that the transfer is signed by the former
+// leader
+func (k *Kayak) checkView(t Tracer, view KNewView) bool {
+	if view.Transfer != nil {
+		transfer, ok := view.Transfer.Payload.(KTransfer)
+		if !ok || transfer.Epoch != view.Epoch || !verify(k.leaderPolicy.Leader(view.Epoch-1, k.keys), *view.Transfer) {
+			k.traceF(t.Logf("invalid transfer"))
+			return false
+		}
+		return true
+	}
+
+	if len(view.Senders) != len(view.Suspects) {
+		k.traceF(t.Logf("slice lengths differ"))
+		return false
+	}
+
+	suspects := make(map[KAddress]KSuspect)
+	for i, sender := range view.Senders {
+		if _, fromServer := k.rkeys[sender]; !fromServer {
+			k.traceF(t.Logf("suspect not from server"))
+			return false
+		}
+		if _, duplicate := suspects[sender]; duplicate {
+			k.traceF(t.Logf("duplicate suspect of %#v", sender))
+			return false
+		}
+		suspect, ok := view.Suspects[i].Payload.(KSuspect)
+		if !ok || suspect.Epoch != view.Epoch || !verify(sender, view.Suspects[i]) {
+			k.traceF(t.Logf("invalid suspect of %#v", sender))
+			return false
+		}
+		for _, prepared := range suspect.Prepared {
+			if !k.checkPrepared(t, prepared) {
+				return false
+			}
+		}
+		suspects[sender] = suspect
+	}
+
+	if k.suspectVotes(suspects) < k.q {
+		k.traceF(t.Logf("suspect quorum (%d/%d) not reached", k.suspectVotes(suspects), k.q))
+		return false
+	}
+
+	return true
+}
+
+func (k *Kayak) receiveNewView(t Tracer, from KAddress, view KNewView) {
+	t = t.Fork("receiveNewView")
+
+	if k.privateKey == nil {
+		k.traceF(t.Logf("rejected as no view change without signatures"))
+		return
+	}
+
+	if _, fromServer := k.rkeys[from]; !fromServer {
+		k.traceF(t.Logf("rejected as not from server"))
+		return
+	}
+
+	if view.Epoch == 0 || view.Epoch < k.epoch {
+		k.traceF(t.Logf("rejected as with outdated epoch"))
+		return
+	}
+
+	if k.view != nil && k.view.Epoch >= view.Epoch {
+		k.traceF(t.Logf("rejected as already known"))
+		return
+	}
+
+	if !k.checkView(t, view) {
+		k.traceF(t.Logf("rejected as invalid"))
+		return
+	}
+
+	k.view = &view
+	k.traceF(t.Logf("recorded"))
+}
+
+func (k *Kayak) receiveNeedView(t Tracer, from KAddress, need KNeedView) {
+	t = t.Fork("receiveNeedView")
+
+	if _, fromServer := k.rkeys[from]; !fromServer {
+		k.traceF(t.Logf("rejected as not from server"))
+		return
+	}
+
+	if k.view == nil || k.view.Epoch != need.Epoch {
+		k.traceF(t.Logf("rejected as no view of %#v", need.Epoch))
+		return
+	}
+
+	k.sendF(from, *k.view)
+}
+
+// maybeNewView carries the batches of the view of the current epoch once
+// known. Meanwhile nothing is written in the epoch, and the leader is asked
+// for the view after the whatsup period.
+func (k *Kayak) maybeNewView(t Tracer) bool {
+	t = t.Fork("maybeNewView")
+
+	if k.privateKey == nil || k.viewEpoch == k.epoch {
+		k.traceF(t.Logf("view of %#v known", k.epoch))
+		return false
+	}
+
+	if k.view != nil && k.view.Epoch == k.epoch {
+		k.traceF(t.Logf("gogo, carry %#v", *k.view))
+		k.carryView(t, *k.view)
+		return true
+	}
+
+	if k.key == k.leader() || k.time < k.viewRetryAt {
+		k.traceF(t.Logf("waiting for the view of %#v", k.epoch))
+		return false
+	}
+
+	k.traceF(t.Logf("ask %#v for the view of %#v", k.leader(), k.epoch))
+	k.sendF(k.leader(), KNeedView{Epoch: k.epoch})
+	k.viewRetryAt = k.time + k.whatsupT
+
+	return true
+}
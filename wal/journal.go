@@ -0,0 +1,204 @@
+package wal
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/stratumn/kayak"
+)
+
+// JournalCompactThreshold is the number of records after which the journal
+// file is rewritten to contain only the last vote of each kind
+const JournalCompactThreshold = 1024
+
+const voteSize = 1 + 8 + 8
+
+var _ kayak.KVoteJournal = (*Journal)(nil)
+
+// Journal is a file-backed kayak.KVoteJournal. Every vote is flushed to the
+// disk before Record returns.
+type Journal struct {
+	sync.Mutex
+
+	path    string
+	file    *os.File
+	size    int64
+	records int
+	votes   map[kayak.KVoteKind]kayak.KVote
+}
+
+// OpenJournal opens the journal file, creating it if needed. A torn record
+// at the end of the file is cut off, a bad record followed by others is
+// reported as ErrCorrupted.
+func OpenJournal(path string) (*Journal, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	j := Journal{
+		path:  path,
+		file:  file,
+		votes: make(map[kayak.KVoteKind]kayak.KVote),
+	}
+
+	if err := j.load(); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return &j, nil
+}
+
+// Record implements kayak.KVoteJournal
+func (j *Journal) Record(vote kayak.KVote) error {
+	j.Lock()
+	defer j.Unlock()
+
+	record := encode(encodeVote(vote), vote.Hash)
+	if _, err := j.file.WriteAt(record, j.size); err != nil {
+		return err
+	}
+	if err := j.file.Sync(); err != nil {
+		return err
+	}
+
+	j.size += int64(len(record))
+	j.records++
+	j.votes[vote.Kind] = vote
+
+	if j.records > JournalCompactThreshold {
+		return j.compact()
+	}
+
+	return nil
+}
+
+// Load implements kayak.KVoteJournal
+func (j *Journal) Load() ([]kayak.KVote, error) {
+	j.Lock()
+	defer j.Unlock()
+
+	var votes []kayak.KVote
+	for _, vote := range j.votes {
+		votes = append(votes, vote)
+	}
+
+	return votes, nil
+}
+
+// Close closes the journal file
+func (j *Journal) Close() error {
+	j.Lock()
+	defer j.Unlock()
+
+	return j.file.Close()
+}
+
+func (j *Journal) load() error {
+	info, err := j.file.Stat()
+	if err != nil {
+		return err
+	}
+	fileSize := info.Size()
+
+	buf := make([]byte, headerSize+voteSize)
+	for {
+		_, err := j.file.ReadAt(buf, j.size)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		data, hash, ok := decode(buf)
+		if !ok || len(data) != voteSize {
+			if j.size+int64(len(buf)) < fileSize {
+				return fmt.Errorf("%s: bad record at offset %d of journal %s followed by others", ErrCorrupted, j.size, j.path)
+			}
+			break
+		}
+
+		vote := decodeVote(data, hash)
+		j.votes[vote.Kind] = vote
+		j.size += int64(len(buf))
+		j.records++
+	}
+
+	if fileSize > j.size {
+		if err := j.file.Truncate(j.size); err != nil {
+			return err
+		}
+		return j.file.Sync()
+	}
+
+	return nil
+}
+
+// compact atomically replaces the journal file with one containing only the
+// last vote of each kind
+func (j *Journal) compact() error {
+	tmpPath := j.path + ".tmp"
+	tmp, err := os.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+
+	var size int64
+	for _, vote := range j.votes {
+		record := encode(encodeVote(vote), vote.Hash)
+		if _, err := tmp.Write(record); err != nil {
+			tmp.Close()
+			return err
+		}
+		size += int64(len(record))
+	}
+
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := os.Rename(tmpPath, j.path); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if dir, err := os.Open(filepath.Dir(j.path)); err == nil {
+		_ = dir.Sync()
+		_ = dir.Close()
+	}
+
+	if err := j.file.Close(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("wal: cannot close old journal: %s", err)
+	}
+
+	j.file = tmp
+	j.size = size
+	j.records = len(j.votes)
+
+	return nil
+}
+
+func encodeVote(vote kayak.KVote) []byte {
+	data := make([]byte, voteSize)
+	data[0] = byte(vote.Kind)
+	binary.BigEndian.PutUint64(data[1:9], uint64(vote.Round))
+	binary.BigEndian.PutUint64(data[9:17], uint64(vote.Epoch))
+	return data
+}
+
+func decodeVote(data []byte, hash kayak.KHash) kayak.KVote {
+	return kayak.KVote{
+		Kind:  kayak.KVoteKind(data[0]),
+		Round: kayak.KRound(binary.BigEndian.Uint64(data[1:9])),
+		Epoch: kayak.KEpoch(binary.BigEndian.Uint64(data[9:17])),
+		Hash:  hash,
+	}
+}
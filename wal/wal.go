@@ -0,0 +1,499 @@
+// Package wal provides a file-backed segmented write-ahead log, which
+// implements kayak.KRecoverableStorage.
+//
+// Every record is stored as
+//
+//	| length (4 bytes) | crc32c (4 bytes) | origin (32 bytes) | session (48 bytes) | data |
+//
+// where the origin is the nonce, the index and the sequence of the request
+// of the entry, the session is the client and the session of the request,
+// the length covers both session and data, and the checksum covers origin,
+// session and data. Records are appended to the
+// active segment until it exceeds the configured size, then a new segment is
+// started. Segment files are named after the index of their first record.
+//
+// On open all segments are scanned. A torn or corrupted record at the tail of
+// the last segment (e.g. after a crash in the middle of a write) is cut off;
+// corruption anywhere else, including a bad record followed by others in the
+// last segment, is reported as an error.
+//
+// The package also provides Journal, a file-backed kayak.KVoteJournal using
+// the same record format.
+package wal
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/stratumn/kayak"
+)
+
+const (
+	// SyncAlways flushes every record to the disk before returning
+	SyncAlways = SyncPolicy(iota)
+	// SyncBatch flushes every SyncEvery records and on segment rotation
+	SyncBatch
+	// SyncNever leaves flushing to the operating system
+	SyncNever
+)
+
+// DefaultSegmentSize is used when Config.SegmentSize is not set
+const DefaultSegmentSize = 64 << 20
+
+const segmentExt = ".wal"
+const headerSize = 4 + 4 + len(kayak.KHash{})
+const sessionSize = kayak.AddressSize + kayak.NonceSize
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+var _ kayak.KRecoverableStorage = (*WAL)(nil)
+
+// ErrCorrupted is returned when a record other than the last one cannot be read
+var ErrCorrupted = errors.New("wal: log corrupted")
+
+// ErrOutOfRange is returned when the requested index is not in the log
+var ErrOutOfRange = errors.New("wal: index out of range")
+
+// ErrClosed is reported when a record is appended to a closed log
+var ErrClosed = errors.New("wal: log closed")
+
+// SyncPolicy defines when the log is flushed to the disk
+type SyncPolicy int
+
+// Config holds the parameters of the log
+type Config struct {
+	Dir         string
+	SegmentSize int64
+	SyncPolicy  SyncPolicy
+	SyncEvery   int
+	ErrorF      func(error)
+}
+
+type segment struct {
+	first kayak.KIndex
+	file  *os.File
+	size  int64
+}
+
+type position struct {
+	segment int
+	offset  int64
+	length  int
+}
+
+// WAL is a segmented write-ahead log
+type WAL struct {
+	sync.Mutex
+
+	dir         string
+	segmentSize int64
+	syncPolicy  SyncPolicy
+	syncEvery   int
+
+	segments []*segment
+	index    []position
+	unsynced int
+	err      error
+
+	extErrorF func(error)
+}
+
+// Open opens the log in the given directory, creating it if needed, and
+// restores the index of records
+func Open(c *Config) (*WAL, error) {
+	if c.Dir == "" {
+		return nil, errors.New("wal: directory not defined")
+	}
+
+	segmentSize := c.SegmentSize
+	if segmentSize <= 0 {
+		segmentSize = DefaultSegmentSize
+	}
+
+	syncEvery := c.SyncEvery
+	if syncEvery <= 0 {
+		syncEvery = 1
+	}
+
+	if err := os.MkdirAll(c.Dir, 0700); err != nil {
+		return nil, err
+	}
+
+	w := WAL{
+		dir:         c.Dir,
+		segmentSize: segmentSize,
+		syncPolicy:  c.SyncPolicy,
+		syncEvery:   syncEvery,
+		extErrorF:   c.ErrorF,
+	}
+
+	if err := w.load(); err != nil {
+		w.closeSegments()
+		return nil, err
+	}
+
+	return &w, nil
+}
+
+// Append implements kayak.KStorage. The origin is stored as zero.
+func (w *WAL) Append(data []byte) {
+	w.AppendEntry(data, kayak.KOrigin{})
+}
+
+// AppendEntry implements kayak.KRecoverableStorage. The record is rejected
+// with ErrClosed once the log is closed.
+func (w *WAL) AppendEntry(data []byte, origin kayak.KOrigin) {
+	w.Lock()
+	defer w.Unlock()
+
+	if len(w.segments) == 0 {
+		w.errorF(ErrClosed)
+		return
+	}
+
+	if w.err != nil {
+		w.errorF(fmt.Errorf("wal: append rejected due to previous error: %s", w.err))
+		return
+	}
+
+	field, session := encodeOrigin(origin)
+	if err := w.append(append(session, data...), field); err != nil {
+		w.err = err
+		w.errorF(err)
+	}
+}
+
+// Len implements kayak.KRecoverableStorage
+func (w *WAL) Len() kayak.KIndex {
+	w.Lock()
+	defer w.Unlock()
+
+	return kayak.KIndex(len(w.index))
+}
+
+// Entry implements kayak.KRecoverableStorage
+func (w *WAL) Entry(index kayak.KIndex) ([]byte, kayak.KOrigin, error) {
+	w.Lock()
+	defer w.Unlock()
+
+	if int(index) >= len(w.index) {
+		return nil, kayak.KOrigin{}, ErrOutOfRange
+	}
+
+	pos := w.index[index]
+	buf := make([]byte, headerSize+pos.length)
+	if _, err := w.segments[pos.segment].file.ReadAt(buf, pos.offset); err != nil {
+		return nil, kayak.KOrigin{}, err
+	}
+
+	data, field, ok := decode(buf)
+	if !ok || len(data) < sessionSize {
+		return nil, kayak.KOrigin{}, ErrCorrupted
+	}
+
+	return data[sessionSize:], decodeOrigin(field, data[:sessionSize]), nil
+}
+
+// Sync flushes the active segment to the disk
+func (w *WAL) Sync() error {
+	w.Lock()
+	defer w.Unlock()
+
+	return w.sync()
+}
+
+// Err returns the error which made the log stop accepting appends, if any
+func (w *WAL) Err() error {
+	w.Lock()
+	defer w.Unlock()
+
+	return w.err
+}
+
+// Close flushes and closes all segments
+func (w *WAL) Close() error {
+	w.Lock()
+	defer w.Unlock()
+
+	err := w.sync()
+	if closeErr := w.closeSegments(); err == nil {
+		err = closeErr
+	}
+	w.segments = nil
+	w.index = nil
+
+	return err
+}
+
+func (w *WAL) append(data []byte, field kayak.KHash) error {
+	record := encode(data, field)
+
+	active := w.segments[len(w.segments)-1]
+	if active.size > 0 && active.size+int64(len(record)) > w.segmentSize {
+		if err := w.rotate(); err != nil {
+			return err
+		}
+		active = w.segments[len(w.segments)-1]
+	}
+
+	if _, err := active.file.WriteAt(record, active.size); err != nil {
+		return err
+	}
+
+	w.index = append(w.index, position{
+		segment: len(w.segments) - 1,
+		offset:  active.size,
+		length:  len(data),
+	})
+	active.size += int64(len(record))
+	w.unsynced++
+
+	switch w.syncPolicy {
+	case SyncAlways:
+		return w.sync()
+	case SyncBatch:
+		if w.unsynced >= w.syncEvery {
+			return w.sync()
+		}
+	}
+
+	return nil
+}
+
+func (w *WAL) rotate() error {
+	if w.syncPolicy != SyncNever {
+		if err := w.sync(); err != nil {
+			return err
+		}
+	}
+
+	s, err := w.createSegment(kayak.KIndex(len(w.index)))
+	if err != nil {
+		return err
+	}
+	w.segments = append(w.segments, s)
+
+	return nil
+}
+
+func (w *WAL) sync() error {
+	if len(w.segments) == 0 || w.unsynced == 0 {
+		return nil
+	}
+	if err := w.segments[len(w.segments)-1].file.Sync(); err != nil {
+		return err
+	}
+	w.unsynced = 0
+	return nil
+}
+
+func (w *WAL) load() error {
+	names, err := filepath.Glob(filepath.Join(w.dir, "*"+segmentExt))
+	if err != nil {
+		return err
+	}
+
+	var firsts []kayak.KIndex
+	for _, name := range names {
+		first, err := strconv.ParseUint(strings.TrimSuffix(filepath.Base(name), segmentExt), 10, 64)
+		if err != nil {
+			return fmt.Errorf("wal: unexpected segment name %s", name)
+		}
+		firsts = append(firsts, kayak.KIndex(first))
+	}
+	sort.Slice(firsts, func(i, j int) bool { return firsts[i] < firsts[j] })
+
+	if len(firsts) == 0 {
+		s, err := w.createSegment(0)
+		if err != nil {
+			return err
+		}
+		w.segments = append(w.segments, s)
+		return nil
+	}
+
+	for i, first := range firsts {
+		if first != kayak.KIndex(len(w.index)) {
+			return fmt.Errorf("%s: segment %d starts at %d, expected %d", ErrCorrupted, i, first, len(w.index))
+		}
+
+		file, err := os.OpenFile(w.segmentPath(first), os.O_RDWR, 0600)
+		if err != nil {
+			return err
+		}
+		s := &segment{first: first, file: file}
+		w.segments = append(w.segments, s)
+
+		isLast := i == len(firsts)-1
+		if err := w.scan(s, isLast); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// scan reads all records of the segment and adds them to the index. A torn
+// record is cut off if it is the tail of the last segment.
+func (w *WAL) scan(s *segment, isLast bool) error {
+	info, err := s.file.Stat()
+	if err != nil {
+		return err
+	}
+	fileSize := info.Size()
+
+	header := make([]byte, headerSize)
+	var offset int64
+	for offset < fileSize {
+		torn := false
+
+		if _, err := s.file.ReadAt(header, offset); err != nil {
+			if err != io.EOF {
+				return err
+			}
+			torn = true
+		}
+
+		length := int64(binary.BigEndian.Uint32(header[0:4]))
+		if !torn && offset+int64(headerSize)+length > fileSize {
+			torn = true
+		}
+
+		if !torn {
+			buf := make([]byte, int64(headerSize)+length)
+			if _, err := s.file.ReadAt(buf, offset); err != nil {
+				return err
+			}
+			if _, _, ok := decode(buf); !ok {
+				if offset+int64(headerSize)+length < fileSize {
+					err := fmt.Errorf("%s: bad record at offset %d of segment %d followed by others", ErrCorrupted, offset, s.first)
+					w.errorF(err)
+					return err
+				}
+				torn = true
+			}
+		}
+
+		if torn {
+			if !isLast {
+				return fmt.Errorf("%s: bad record at offset %d of segment %d", ErrCorrupted, offset, s.first)
+			}
+			w.errorF(fmt.Errorf("wal: torn record at offset %d of segment %d, truncating", offset, s.first))
+			if err := s.file.Truncate(offset); err != nil {
+				return err
+			}
+			if err := s.file.Sync(); err != nil {
+				return err
+			}
+			break
+		}
+
+		w.index = append(w.index, position{
+			segment: len(w.segments) - 1,
+			offset:  offset,
+			length:  int(length),
+		})
+		offset += int64(headerSize) + length
+	}
+
+	s.size = offset
+	return nil
+}
+
+func (w *WAL) createSegment(first kayak.KIndex) (*segment, error) {
+	file, err := os.OpenFile(w.segmentPath(first), os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	// Make the new file durable in the directory
+	if dir, err := os.Open(w.dir); err == nil {
+		_ = dir.Sync()
+		_ = dir.Close()
+	}
+
+	return &segment{first: first, file: file}, nil
+}
+
+func (w *WAL) closeSegments() error {
+	var err error
+	for _, s := range w.segments {
+		if closeErr := s.file.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+func (w *WAL) segmentPath(first kayak.KIndex) string {
+	return filepath.Join(w.dir, fmt.Sprintf("%020d%s", first, segmentExt))
+}
+
+func (w *WAL) errorF(err error) {
+	if w.extErrorF != nil {
+		w.extErrorF(err)
+	} else {
+		log.Print(err)
+	}
+}
+
+// encodeOrigin packs the origin into the hash field of the record and the
+// session which precedes the data
+func encodeOrigin(origin kayak.KOrigin) (kayak.KHash, []byte) {
+	var field kayak.KHash
+	copy(field[:kayak.NonceSize], origin.Nonce[:])
+	binary.BigEndian.PutUint64(field[kayak.NonceSize:kayak.NonceSize+8], uint64(origin.Index))
+	binary.BigEndian.PutUint64(field[kayak.NonceSize+8:], origin.Sequence)
+
+	session := make([]byte, sessionSize)
+	copy(session[:kayak.AddressSize], origin.Client[:])
+	copy(session[kayak.AddressSize:], origin.Session[:])
+	return field, session
+}
+
+func decodeOrigin(field kayak.KHash, session []byte) kayak.KOrigin {
+	var origin kayak.KOrigin
+	copy(origin.Nonce[:], field[:kayak.NonceSize])
+	origin.Index = kayak.KIndex(binary.BigEndian.Uint64(field[kayak.NonceSize : kayak.NonceSize+8]))
+	origin.Sequence = binary.BigEndian.Uint64(field[kayak.NonceSize+8:])
+	copy(origin.Client[:], session[:kayak.AddressSize])
+	copy(origin.Session[:], session[kayak.AddressSize:])
+	return origin
+}
+
+func encode(data []byte, buzz kayak.KHash) []byte {
+	record := make([]byte, headerSize+len(data))
+	binary.BigEndian.PutUint32(record[0:4], uint32(len(data)))
+	copy(record[8:headerSize], buzz[:])
+	copy(record[headerSize:], data)
+	binary.BigEndian.PutUint32(record[4:8], crc32.Checksum(record[8:], crcTable))
+	return record
+}
+
+func decode(record []byte) ([]byte, kayak.KHash, bool) {
+	var buzz kayak.KHash
+	if len(record) < headerSize {
+		return nil, buzz, false
+	}
+	length := int(binary.BigEndian.Uint32(record[0:4]))
+	if len(record) != headerSize+length {
+		return nil, buzz, false
+	}
+	if crc32.Checksum(record[8:], crcTable) != binary.BigEndian.Uint32(record[4:8]) {
+		return nil, buzz, false
+	}
+	copy(buzz[:], record[8:headerSize])
+	data := make([]byte, length)
+	copy(data, record[headerSize:])
+	return data, buzz, true
+}
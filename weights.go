@@ -0,0 +1,129 @@
+package kayak
+
+import "fmt"
+
+// makeWeights returns the voting weights of the keys, each key weighs 1 if
+// no weights are given
+func makeWeights(keys []KAddress, weights []uint) ([]uint, error) {
+	if err := checkWeights(keys, weights); err != nil {
+		return nil, err
+	}
+	result := make([]uint, len(keys))
+	for i := range result {
+		result[i] = 1
+		if weights != nil {
+			result[i] = weights[i]
+		}
+	}
+	return result, nil
+}
+
+// checkWeights reports the weights which cannot be used with the keys
+func checkWeights(keys []KAddress, weights []uint) error {
+	if weights == nil {
+		return nil
+	}
+	if len(weights) != len(keys) {
+		return fmt.Errorf("%d weights for %d keys", len(weights), len(keys))
+	}
+	for i, weight := range weights {
+		if weight == 0 {
+			return fmt.Errorf("zero weight of %#v", keys[i])
+		}
+	}
+	return nil
+}
+
+// quorumFactors returns the weight which may be faulty and the quorum weight.
+// Any two quorums share a correct process in the Byzantine model, and share
+// a process in the crash model:
+//
+//	Byzantine: f = (w-1)/3, q = (w+f)/2+1
+//	Crash:     f = (w-1)/2, q = w/2+1
+//
+// Votes need a quorum. Facts about the log, such as heads and confirms, need
+// f+1 only if the senders are authenticated, see factQuorum. Thus a system
+// of 2 processes needs both of them to decide, but a lagging one catches up
+// with the other one.
+func quorumFactors(weights []uint, model KFaultModel) (f, q uint) {
+	var w uint
+	for _, weight := range weights {
+		w += weight
+	}
+
+	// Without weight nothing can reach the quorum
+	if w == 0 {
+		return 0, 1
+	}
+
+	if model == FaultModelCrash {
+		f = (w - 1) / 2
+		q = w/2 + 1
+	} else {
+		f = (w - 1) / 3
+		q = (w+f)/2 + 1
+	}
+
+	return f, q
+}
+
+func sameWeights(a, b []uint) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// factQuorum returns the weight vouching for a fact about the log, such as a
+// head or a confirm. f+1 is enough if the servers only crash or sign the
+// messages, as a correct server is among the senders, and if no server may
+// be faulty. Otherwise a Byzantine server may send on behalf of others, so a
+// quorum is needed.
+func (k *Kayak) factQuorum() uint {
+	if k.faultModel == FaultModelCrash || k.privateKey != nil || k.f == 0 {
+		return k.f + 1
+	}
+	return k.q
+}
+
+// weight returns the voting weight of the key, the keys out of the
+// membership weigh nothing
+func (k *Kayak) weight(key KAddress) uint {
+	i, found := k.rkeys[key]
+	if !found {
+		return 0
+	}
+	return k.weights[i]
+}
+
+// votes returns the weight of the voters, which are the keys of the map
+func (k *Kayak) votes(voters map[KAddress]struct{}) uint {
+	var w uint
+	for key := range voters {
+		w += k.weight(key)
+	}
+	return w
+}
+
+// signedVotes returns the weight of the voters recorded with their signature
+func (k *Kayak) signedVotes(voters map[KAddress][]byte) uint {
+	var w uint
+	for key := range voters {
+		w += k.weight(key)
+	}
+	return w
+}
+
+// suspectVotes returns the weight of the voters recorded with their suspect
+func (k *Kayak) suspectVotes(voters map[KAddress]KSuspect) uint {
+	var w uint
+	for key := range voters {
+		w += k.weight(key)
+	}
+	return w
+}